@@ -0,0 +1,172 @@
+package rimer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// createAfterScript records that the ARGV[2] timer should be created with a
+// duration of ARGV[3] milliseconds once the ARGV[1] timer is consumed. The
+// records are kept in the KEYS[1] hash as JSON objects of dependent keys and
+// their durations. It returns 0 without recording anything if the ARGV[1]
+// timer already depends on the ARGV[2] timer, directly or transitively, which
+// would make a cycle.
+var createAfterScript = newNamespaceScript(`
+local err = checkMeta()
+if err then
+	return redis.error_reply(err)
+end
+local pending, seen = {ARGV[2]}, {}
+while #pending > 0 do
+	local key = table.remove(pending)
+	if key == ARGV[1] then
+		return 0
+	end
+	if not seen[key] then
+		seen[key] = true
+		local raw = redis.call('HGET', KEYS[1], key)
+		if raw then
+			for dependent in pairs(cjson.decode(raw)) do
+				table.insert(pending, dependent)
+			end
+		end
+	end
+end
+writeMeta()
+local dependents = {}
+local raw = redis.call('HGET', KEYS[1], ARGV[1])
+if raw then
+	dependents = cjson.decode(raw)
+end
+dependents[ARGV[2]] = tonumber(ARGV[3])
+redis.call('HSET', KEYS[1], ARGV[1], cjson.encode(dependents))
+return 1
+`)
+
+// forgetDependentsScript removes the dependency records of the timers in ARGV,
+// which alternates between the timer's key and its record, from the KEYS[1]
+// hash once their dependent timers have been created. A record that changed
+// since it was read, because CreateAfter added another dependent to it, is
+// kept so that the new dependent isn't lost.
+var forgetDependentsScript = newNamespaceScript(`
+for i = 1, nargs, 2 do
+	if redis.call('HGET', KEYS[1], ARGV[i]) == ARGV[i + 1] then
+		redis.call('HDEL', KEYS[1], ARGV[i])
+	end
+end
+return 1
+`)
+
+// CreateAfter creates the timer with the given key once the afterKey timer has
+// fired and been consumed, to fire delay after that. This is useful for
+// dependent workflows, such as "fire B 10 minutes after A fires". Several
+// timers can depend on the same timer, and calling CreateAfter again for the
+// same pair of keys replaces the delay. The dependent timer is created as if
+// by Create, right after afterKey is returned by Next or NextGroup, so if the
+// consumer crashes in between, the dependent timer is never created.
+//
+// If the afterKey timer is cancelled, the dependent timers are never created,
+// unless FireDependentsOnCancel is set, in which case they're created as if
+// afterKey had fired.
+//
+// The afterKey timer doesn't have to exist yet, so that chains of timers can be
+// created up front, where each timer depends on the one before it. This also
+// means that if afterKey has already been consumed, or is never created, the
+// dependent timer is never created either. It returns ErrDependencyCycle if
+// afterKey already depends on key, directly or through other timers.
+func (n *Namespace) CreateAfter(ctx context.Context, key, afterKey string, delay time.Duration) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	ms, err := durationMs(delay)
+	if err != nil {
+		return err
+	}
+	created, err := n.runScript(ctx, createAfterScript,
+		[]string{n.afterKey()},
+		afterKey, key, ms).Int()
+	if err != nil {
+		return err
+	}
+	if created == 0 {
+		return fmt.Errorf("%w: %s after %s", ErrDependencyCycle, key, afterKey)
+	}
+	return nil
+}
+
+// releaseDependents reads the dependency records of the given timers and
+// creates their dependent timers, if the timers fired or FireDependentsOnCancel
+// is set. Otherwise the dependent timers are never created, and neither are the
+// timers that depend on them in turn. The records of timers that fired are
+// only removed once their dependents are created, so they aren't lost if Redis
+// fails. Dependents that can never be created, because their delay is no
+// longer valid, e.g. after MaxDuration was lowered, are dropped along with the
+// records, and reported in the returned error.
+func (n *Namespace) releaseDependents(ctx context.Context, keys []string, fired bool) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	records, err := n.client.r.HMGet(ctx, n.afterKey(), keys...).Result()
+	if err != nil {
+		return err
+	}
+	dependents := make(map[string]time.Duration)
+	taken := make([]any, 0, 2*len(records))
+	for i, record := range records {
+		raw, ok := record.(string)
+		if !ok {
+			continue
+		}
+		taken = append(taken, keys[i], raw)
+		var delays map[string]int64
+		err = json.Unmarshal([]byte(raw), &delays)
+		if err != nil {
+			return fmt.Errorf("decoding dependency record: %w", err)
+		}
+		for k, ms := range delays {
+			dependents[k] = time.Duration(ms) * time.Millisecond
+		}
+	}
+	if len(dependents) == 0 {
+		return nil
+	}
+	if !fired && !n.FireDependentsOnCancel {
+		// The records are removed before moving on to the dependents, so
+		// that each timer is only visited once even if the records were
+		// written with a cycle.
+		err = n.runScript(ctx, forgetDependentsScript, []string{n.afterKey()}, taken...).Err()
+		if err != nil {
+			return err
+		}
+		next := make([]string, 0, len(dependents))
+		for k := range dependents {
+			next = append(next, k)
+		}
+		return n.releaseDependents(ctx, next, false)
+	}
+	var dropped []error
+	for k, d := range dependents {
+		if d <= 0 {
+			err = ErrInvalidDuration
+		} else {
+			_, err = n.limitDuration(n.scaleDuration(d))
+		}
+		if err != nil {
+			dropped = append(dropped, fmt.Errorf("dropping dependent timer %s: %w", k, err))
+			delete(dependents, k)
+		}
+	}
+	if len(dependents) > 0 {
+		err = n.CreateMany(ctx, dependents)
+		if err != nil {
+			return err
+		}
+	}
+	err = n.runScript(ctx, forgetDependentsScript, []string{n.afterKey()}, taken...).Err()
+	if err != nil {
+		return err
+	}
+	return errors.Join(dropped...)
+}
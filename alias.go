@@ -0,0 +1,112 @@
+package rimer
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+const (
+	// aliasField is the field of an aliased namespace's metadata hash that
+	// holds the name of the namespace it points to.
+	aliasField = "alias"
+
+	// maxAliasDepth is the longest chain of aliases that is followed, which
+	// stops resolution if aliases that were created concurrently form a cycle.
+	maxAliasDepth = 16
+
+	// aliasTimeout bounds resolving an alias when the client doesn't have a
+	// DefaultTimeout, since Namespace doesn't take a context.
+	aliasTimeout = 5 * time.Second
+)
+
+// AliasNamespace makes the alias namespace point at the target namespace, so
+// that producers and consumers that still use the alias's name keep working
+// after a namespace is renamed, for example by moving its timers over with
+// Export and Import. Namespace resolves the alias when the client's ResolveAliases
+// is set. The alias namespace's own timers aren't moved, so it should be empty
+// or drained first. Pointing the alias at a namespace that is itself an alias
+// is allowed, but AliasNamespace returns ErrAliasCycle if the target resolves
+// back to the alias.
+//
+// Clients cache the names they've resolved, so processes that already used
+// the alias before it was created need to be restarted to pick it up.
+func (c *Client) AliasNamespace(ctx context.Context, alias, target string) error {
+	ctx, cancel := c.withDefaultTimeout(ctx)
+	defer cancel()
+	resolved, err := c.followAliases(ctx, target)
+	if err != nil {
+		return err
+	}
+	if resolved == alias {
+		return fmt.Errorf("%w: %s resolves to %s", ErrAliasCycle, target, alias)
+	}
+	err = c.r.HSet(ctx, c.namespace(alias).metaKey(), aliasField, target).Err()
+	if err != nil {
+		return err
+	}
+	c.aliasMu.Lock()
+	defer c.aliasMu.Unlock()
+	if c.aliases == nil {
+		c.aliases = make(map[string]string)
+	}
+	for name, to := range c.aliases {
+		if to == alias {
+			c.aliases[name] = resolved
+		}
+	}
+	c.aliases[alias] = resolved
+	return nil
+}
+
+// resolveAlias returns the name of the namespace that the given name resolves
+// to, see AliasNamespace, using the client's cache if it's been resolved
+// before. Names that can't be resolved because Redis fails are used as is and
+// resolved again next time.
+func (c *Client) resolveAlias(name string) string {
+	c.aliasMu.Lock()
+	resolved, ok := c.aliases[name]
+	c.aliasMu.Unlock()
+	if ok {
+		return resolved
+	}
+	ctx, cancel := c.withDefaultTimeout(context.Background())
+	defer cancel()
+	if c.DefaultTimeout <= 0 {
+		ctx, cancel = context.WithTimeout(ctx, aliasTimeout)
+		defer cancel()
+	}
+	resolved, err := c.followAliases(ctx, name)
+	if err != nil {
+		return name
+	}
+	c.aliasMu.Lock()
+	defer c.aliasMu.Unlock()
+	if c.aliases == nil {
+		c.aliases = make(map[string]string)
+	}
+	c.aliases[name] = resolved
+	return resolved
+}
+
+// followAliases follows the chain of aliases starting at name, and returns the
+// name of the namespace at its end.
+func (c *Client) followAliases(ctx context.Context, name string) (string, error) {
+	seen := map[string]bool{name: true}
+	for i := 0; i < maxAliasDepth; i++ {
+		target, err := c.r.HGet(ctx, c.namespace(name).metaKey(), aliasField).Result()
+		if err == redis.Nil {
+			return name, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if seen[target] {
+			return "", fmt.Errorf("%w: %s points back to %s", ErrAliasCycle, name, target)
+		}
+		seen[target] = true
+		name = target
+	}
+	return "", fmt.Errorf("%w: more than %d aliases", ErrAliasCycle, maxAliasDepth)
+}
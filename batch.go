@@ -0,0 +1,184 @@
+package rimer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// BatchTimer is a timer to create with CreateBatch.
+type BatchTimer struct {
+	// Key is the key of the timer.
+	Key string
+	// Duration is the time before the timer expires, see Create.
+	Duration time.Duration
+	// Options are the options that the timer is created with, see
+	// CreateWithOptions.
+	Options CreateOptions
+}
+
+// BatchOutcome is what happened to a timer passed to CreateBatch.
+type BatchOutcome int
+
+const (
+	// BatchCreated is a timer that was created.
+	BatchCreated BatchOutcome = iota
+	// BatchExists is a timer that wasn't created because it was created with
+	// CreateOptions.OnlyIfAbsent and a timer with the same key already exists.
+	BatchExists
+	// BatchFailed is a timer that couldn't be created, see CreateResult.Err.
+	BatchFailed
+)
+
+// String returns the name of the outcome.
+func (o BatchOutcome) String() string {
+	switch o {
+	case BatchCreated:
+		return "created"
+	case BatchExists:
+		return "exists"
+	case BatchFailed:
+		return "failed"
+	default:
+		return "BatchOutcome(" + strconv.Itoa(int(o)) + ")"
+	}
+}
+
+// CreateResult is the outcome of creating a single timer with CreateBatch.
+type CreateResult struct {
+	// Key is the key of the timer.
+	Key string
+	// Outcome is what happened to the timer.
+	Outcome BatchOutcome
+	// Err is the reason that the timer failed. It's nil unless Outcome is
+	// BatchFailed.
+	Err error
+}
+
+// CreateBatch creates each of the given timers as if CreateWithOptions was
+// called for it, using a single pipeline, and returns the outcome for each of
+// them in the same order, so that callers can retry only the timers that
+// failed. Each timer is created atomically on its own, but the batch as a
+// whole isn't atomic. A timer whose warnings couldn't be created is reported
+// as failed even though the timer itself was created.
+func (n *Namespace) CreateBatch(ctx context.Context, timers []BatchTimer) []CreateResult {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	results := make([]CreateResult, len(timers))
+	for i, t := range timers {
+		results[i].Key = t.Key
+	}
+	fail := func(i int, err error) {
+		results[i].Outcome, results[i].Err = BatchFailed, err
+	}
+	// EvalSha can't fall back to sending the script in a pipeline, so it's
+	// loaded first.
+	err := createScript.Load(ctx, n.client.r).Err()
+	if err != nil {
+		for i := range results {
+			fail(i, err)
+		}
+		return results
+	}
+	cmds := make([]*redis.Cmd, len(timers))
+	durations := make([]time.Duration, len(timers))
+	params := make([]createParams, len(timers))
+	_, _ = n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, t := range timers {
+			var err error
+			durations[i], err = n.limitDuration(n.scaleDuration(t.Duration))
+			if err != nil {
+				fail(i, err)
+				continue
+			}
+			params[i] = n.scaleParams(t.Options.params())
+			keys, args, err := n.createArgs(t.Key, durations[i], params[i])
+			if err != nil {
+				fail(i, err)
+				continue
+			}
+			keys, args = n.scriptArgs(keys, args)
+			cmds[i] = createScript.EvalSha(ctx, p, keys, args...)
+		}
+		return nil
+	})
+	for i, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		res, err := cmd.Int64()
+		if err != nil {
+			fail(i, scriptError(err))
+			continue
+		}
+		if _, err = createResult(timers[i].Key, res); errors.Is(err, ErrTimerExists) {
+			results[i].Outcome = BatchExists
+			continue
+		} else if err != nil {
+			fail(i, err)
+			continue
+		}
+		err = n.created(ctx, timers[i].Key, durations[i], params[i])
+		if err != nil {
+			fail(i, err)
+		}
+	}
+	return results
+}
+
+// CreateMapError is returned by CreateMap when some of the timers couldn't be
+// created. The other timers were created.
+type CreateMapError struct {
+	// Failed holds the reason that each timer that couldn't be created failed,
+	// by its key.
+	Failed map[string]error
+}
+
+// Error reports how many timers couldn't be created.
+func (e *CreateMapError) Error() string {
+	return fmt.Sprintf("rimer: %d timers couldn't be created", len(e.Failed))
+}
+
+// Unwrap returns the reasons that the timers failed, so that errors.Is and
+// errors.As look at each of them.
+func (e *CreateMapError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// CreateMap creates a timer for each entry of the map with its own duration, as
+// if Create was called for each of them, using a single pipeline like
+// CreateBatch. Unlike CreateMany, each timer is created on its own, so a timer
+// that fails doesn't stop the others from being created. If some of them
+// fail, CreateMap returns a *CreateMapError with the reason for each of them.
+func (n *Namespace) CreateMap(ctx context.Context, timers map[string]time.Duration) error {
+	batch := make([]BatchTimer, 0, len(timers))
+	for key, duration := range timers {
+		batch = append(batch, BatchTimer{Key: key, Duration: duration})
+	}
+	// Sorting the timers makes the order they're created in predictable.
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].Key < batch[j].Key
+	})
+	var failed map[string]error
+	for _, r := range n.CreateBatch(ctx, batch) {
+		if r.Outcome != BatchFailed {
+			continue
+		}
+		if failed == nil {
+			failed = make(map[string]error)
+		}
+		failed[r.Key] = r.Err
+	}
+	if failed != nil {
+		return &CreateMapError{Failed: failed}
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package rimer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cancelBatchSize is the number of timers that CancelMatching looks at, and
+// cancels, at a time.
+const cancelBatchSize = 100
+
+// cancelScript cancels the timers in ARGV[3] onwards. KEYS[1] and KEYS[2] are
+// the cancelled hash and the cancellations sorted set, followed by the keys
+// returned by cleanupKeys for each of the timers in turn. If ARGV[1] isn't
+// empty, a tombstone with the ARGV[2] reason is recorded for each cancelled
+// timer, and tombstones older than ARGV[1] milliseconds are removed. Returns
+// the number of timers that were cancelled.
+var cancelScript = newNamespaceScript(`
+local retention = tonumber(ARGV[1])
+local cancelled = 0
+for i = 3, nargs do
+	if cleanup((i - 3) * (3 + companionKeyCount) + 3, ARGV[i]) then
+		cancelled = cancelled + 1
+		if retention then
+			redis.call('HSET', KEYS[1], ARGV[i], cjson.encode({at = now, reason = ARGV[2]}))
+			redis.call('ZADD', KEYS[2], now, ARGV[i])
+		end
+	end
+end
+if retention and cancelled > 0 then
+	for _, key in ipairs(redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', '(' .. (now - retention))) do
+		redis.call('HDEL', KEYS[1], key)
+	end
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', '(' .. (now - retention))
+	redis.call('PEXPIRE', KEYS[1], retention)
+	redis.call('PEXPIRE', KEYS[2], retention)
+end
+return cancelled
+`)
+
+// Cancel cancels the timer with the given key so that it never fires. This
+// includes timers that have expired but haven't been polled yet, and timers
+// that are waiting in the queue. It returns whether there was a timer to cancel.
+// Timers that were already added to the stream when using QueueStream can't be
+// cancelled.
+func (n *Namespace) Cancel(ctx context.Context, key string) (bool, error) {
+	return n.CancelWithReason(ctx, key, "")
+}
+
+// CancelWithReason is like Cancel, but records the reason in the timer's
+// tombstone when CancelRetention is set, see ListCancelled.
+func (n *Namespace) CancelWithReason(ctx context.Context, key, reason string) (bool, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	cancelled, err := n.cancel(ctx, []string{key}, reason)
+	return cancelled > 0, err
+}
+
+// CancelledTimer is the tombstone of a cancelled timer, see ListCancelled.
+type CancelledTimer struct {
+	// Key is the key of the timer that was cancelled.
+	Key string
+	// CancelledAt is the time that the timer was cancelled.
+	CancelledAt time.Time
+	// Reason is the reason given to CancelWithReason, "vetoed by BeforeFire"
+	// for timers that were vetoed, see Namespace.BeforeFire, or "" if there was
+	// none.
+	Reason string
+}
+
+// cancelledTombstone is the format of the tombstones in the cancelled hash.
+type cancelledTombstone struct {
+	At     int64  `json:"at"`
+	Reason string `json:"reason"`
+}
+
+// ListCancelled returns the tombstones of the timers that were cancelled within
+// CancelRetention, most recent first. A timer that was cancelled more than once
+// is only listed with its latest cancellation. Tombstones are only recorded
+// while CancelRetention is set, see CancelRetention.
+func (n *Namespace) ListCancelled(ctx context.Context) ([]CancelledTimer, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	min := "-inf"
+	if n.CancelRetention > 0 {
+		min = strconv.FormatInt(time.Now().Add(-n.CancelRetention).UnixMilli(), 10)
+	}
+	keys, err := n.client.r.ZRevRangeByScore(ctx, n.cancellationsKey(), &redis.ZRangeBy{
+		Min: min,
+		Max: "+inf",
+	}).Result()
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	raw, err := n.client.r.HMGet(ctx, n.cancelledKey(), keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	cancelled := make([]CancelledTimer, 0, len(keys))
+	for i, k := range keys {
+		v, ok := raw[i].(string)
+		if !ok {
+			// The tombstone was pruned since we listed it.
+			continue
+		}
+		var tombstone cancelledTombstone
+		err = json.Unmarshal([]byte(v), &tombstone)
+		if err != nil {
+			return nil, fmt.Errorf("decoding tombstone of timer %s: %w", k, err)
+		}
+		cancelled = append(cancelled, CancelledTimer{
+			Key:         k,
+			CancelledAt: time.UnixMilli(tombstone.At),
+			Reason:      tombstone.Reason,
+		})
+	}
+	return cancelled, nil
+}
+
+// CancelMatching cancels every timer whose key matches the glob-style pattern,
+// as understood by the Redis SCAN command, and returns the number of timers
+// that were cancelled. Like Cancel, this includes timers that are waiting to be
+// polled or are waiting in the queue. The matching timers are found in batches
+// before any of them are cancelled, so timers that are created while
+// CancelMatching is running may or may not be cancelled.
+func (n *Namespace) CancelMatching(ctx context.Context, pattern string) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	keys, err := n.matching(ctx, pattern)
+	if err != nil {
+		return 0, err
+	}
+	total := 0
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > cancelBatchSize {
+			batch = batch[:cancelBatchSize]
+		}
+		cancelled, err := n.cancel(ctx, batch, "")
+		total += cancelled
+		if err != nil {
+			return total, err
+		}
+		keys = keys[len(batch):]
+	}
+	return total, nil
+}
+
+// CancelSoonest cancels the count timers that are due to fire soonest, to shed
+// load during an incident, and returns the keys of the timers that were
+// cancelled, soonest first. Timers that have expired but haven't been polled
+// yet are the soonest of all, while timers that are already waiting in the
+// queue aren't considered. With RepresentationSortedSet the timers are read
+// from the sorted set in order, while the other representations look up the
+// remaining time of every registered timer.
+func (n *Namespace) CancelSoonest(ctx context.Context, count int) ([]string, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if count <= 0 {
+		return nil, nil
+	}
+	keys, err := n.soonest(ctx, count)
+	if err != nil {
+		return nil, err
+	}
+	var cancelled []string
+	for _, k := range keys {
+		// Timers are cancelled one at a time so that the ones that fired or
+		// were cancelled in the meantime aren't reported.
+		c, err := n.cancel(ctx, []string{k}, "")
+		if err != nil {
+			return cancelled, err
+		}
+		if c > 0 {
+			cancelled = append(cancelled, k)
+		}
+	}
+	return cancelled, nil
+}
+
+// soonest returns the keys of the count registered timers that are due to fire
+// soonest, soonest first.
+func (n *Namespace) soonest(ctx context.Context, count int) ([]string, error) {
+	if n.Representation == RepresentationSortedSet {
+		return n.client.r.ZRange(ctx, n.scheduledKey(), 0, int64(count-1)).Result()
+	}
+	keys, err := n.registeredMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := n.remaining(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remaining[order[i]] < remaining[order[j]]
+	})
+	if len(order) > count {
+		order = order[:count]
+	}
+	soonest := make([]string, len(order))
+	for i, o := range order {
+		soonest[i] = keys[o]
+	}
+	return soonest, nil
+}
+
+// matching returns the keys of the timers matching the pattern that are
+// pending, registered or queued. Each key is only returned once.
+func (n *Namespace) matching(ctx context.Context, pattern string) ([]string, error) {
+	seen := make(map[string]bool)
+	var keys []string
+	add := func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	// The queue can't be scanned with a pattern, so we match it ourselves.
+	for _, queue := range n.queueKeys() {
+		for start := int64(0); ; start += cancelBatchSize {
+			queued, err := n.client.r.LRange(ctx, queue, start, start+cancelBatchSize-1).Result()
+			if err != nil {
+				return nil, err
+			}
+			for _, k := range queued {
+				if globMatch(pattern, k) {
+					add(k)
+				}
+			}
+			if len(queued) < cancelBatchSize {
+				break
+			}
+		}
+	}
+
+	prefix := n.timerKey("")
+	iter := n.client.r.Scan(ctx, 0, n.timerKey(pattern), cancelBatchSize).Iterator()
+	for iter.Next(ctx) {
+		add(strings.TrimPrefix(iter.Val(), prefix))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, k := range n.registeredKeys() {
+		sorted := n.Representation == RepresentationSortedSet
+		if sorted {
+			iter = n.client.r.ZScan(ctx, k, 0, pattern, cancelBatchSize).Iterator()
+		} else {
+			iter = n.client.r.SScan(ctx, k, 0, pattern, cancelBatchSize).Iterator()
+		}
+		for iter.Next(ctx) {
+			add(iter.Val())
+			if sorted {
+				// ZSCAN returns the scores along with the members.
+				iter.Next(ctx)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// cancel runs cancelScript for the given timers and their warnings, releases
+// their dependent timers, and returns the number of timers that were cancelled,
+// not counting warnings. Tombstones are only recorded for the timers
+// themselves, with the given reason.
+func (n *Namespace) cancel(ctx context.Context, keys []string, reason string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	warnings, err := n.warnings(ctx, keys)
+	if err != nil {
+		return 0, err
+	}
+	if len(warnings) > 0 {
+		redisKeys, args := n.cancelArgs(warnings, "", false)
+		_, err = n.runScript(ctx, cancelScript, redisKeys, args...).Int()
+		if err != nil {
+			return 0, err
+		}
+	}
+	redisKeys, args := n.cancelArgs(keys, reason, true)
+	cancelled, err := n.runScript(ctx, cancelScript, redisKeys, args...).Int()
+	if err != nil {
+		return 0, err
+	}
+	return cancelled, n.releaseDependents(ctx, keys, false)
+}
+
+// cancelArgs returns the keys and arguments that cancelScript is run with to
+// cancel the given timers, recording tombstones with the reason if tombstones
+// is true and CancelRetention is set.
+func (n *Namespace) cancelArgs(keys []string, reason string, tombstones bool) ([]string, []any) {
+	var retention any = ""
+	if tombstones && n.CancelRetention > 0 {
+		retention = n.CancelRetention.Milliseconds()
+	}
+	return append([]string{n.cancelledKey(), n.cancellationsKey()}, n.cleanupKeysMany(keys)...),
+		append([]any{retention, reason}, toAny(keys)...)
+}
+
+// globMatch reports whether s matches the glob-style pattern, using the same
+// rules as Redis: '*' matches any sequence of characters, '?' matches any single
+// character, '[...]' matches a set or range of characters, optionally negated
+// with '^', and '\' escapes the next character.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			var ok bool
+			if pattern, ok = matchClass(pattern[1:], s[0]); !ok {
+				return false
+			}
+			s = s[1:]
+			continue
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// matchClass matches c against the character class at the start of pattern,
+// just after its opening '['. It returns the rest of the pattern after the
+// class, and whether c matched.
+func matchClass(pattern string, c byte) (string, bool) {
+	not := len(pattern) > 0 && pattern[0] == '^'
+	if not {
+		pattern = pattern[1:]
+	}
+	match := false
+	for len(pattern) > 0 && pattern[0] != ']' {
+		switch {
+		case pattern[0] == '\\' && len(pattern) > 1:
+			match = match || pattern[1] == c
+			pattern = pattern[2:]
+		case len(pattern) > 2 && pattern[1] == '-' && pattern[2] != ']':
+			lo, hi := pattern[0], pattern[2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || (lo <= c && c <= hi)
+			pattern = pattern[3:]
+		default:
+			match = match || pattern[0] == c
+			pattern = pattern[1:]
+		}
+	}
+	if len(pattern) > 0 {
+		// Skip the closing ']'.
+		pattern = pattern[1:]
+	}
+	return pattern, match != not
+}
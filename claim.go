@@ -0,0 +1,213 @@
+package rimer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+// claimScript pops the next timer from the KEYS[1] queue using the ARGV[1]
+// command, and records the ARGV[3] claim token for it in the KEYS[2] sorted
+// set, scored by its deadline ARGV[2] milliseconds from now, and in the KEYS[3]
+// hash along with the timer, the queue and the timer's entry in the KEYS[4]
+// tokens hash. Returns the timer's key, or false if the queue is empty.
+var claimScript = newNamespaceScript(`
+local key = redis.call(ARGV[1], KEYS[1])
+if not key then
+	return false
+end
+local entry = redis.call('HGET', KEYS[4], key)
+redis.call('ZADD', KEYS[2], now + tonumber(ARGV[2]), ARGV[3])
+redis.call('HSET', KEYS[3], ARGV[3], cjson.encode({key = key, queue = KEYS[1], entry = entry}))
+return key
+`)
+
+// completeScript removes the ARGV[1] claim token from the KEYS[1] sorted set
+// and the KEYS[2] hash. Unless ARGV[3] is set because the claimed timer was a
+// warning, it also removes the companion data of the one-shot ARGV[2] timer,
+// given the KEYS[3] timer, the KEYS[4] key that it's registered in and the
+// companion hashes starting at KEYS[5], which Claim keeps until the claim is
+// completed. The data is left alone if the timer was created again or is
+// queued again in the meantime. Returns whether the claim existed.
+var completeScript = newNamespaceScript(`
+redis.call('HDEL', KEYS[2], ARGV[1])
+local removed = redis.call('ZREM', KEYS[1], ARGV[1])
+if removed == 1 and ARGV[3] ~= '1'
+	and redis.call('HEXISTS', KEYS[5], ARGV[2]) == 0
+	and redis.call('HEXISTS', KEYS[6], ARGV[2]) == 0
+	and redis.call('EXISTS', KEYS[3]) == 0
+	and not isRegistered(KEYS[4], ARGV[2]) then
+	forget(5, ARGV[2])
+end
+return removed
+`)
+
+// reclaimScript pushes the timers whose claims in the KEYS[1] sorted set have
+// expired back onto the queues they were claimed from using the ARGV[1]
+// command, and removes the claims. Each timer is given the next fencing token
+// from the KEYS[3] counter in the KEYS[4] tokens hash, keeping the time that
+// it fired and its delivery ID, and marking it as reclaimed so that it isn't
+// re-armed again. Returns the number of timers that were returned to their
+// queues.
+var reclaimScript = newNamespaceScript(`
+local tokens = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now)
+for _, token in ipairs(tokens) do
+	local raw = redis.call('HGET', KEYS[2], token)
+	if raw then
+		local claim = cjson.decode(raw)
+		local fired, delivery = 0, ''
+		if type(claim.entry) == 'string' then
+			local _
+			_, fired, delivery = parseToken(claim.entry)
+		end
+		local next = redis.call('INCR', KEYS[3])
+		if delivery == '' then
+			delivery = next
+		end
+		redis.call('HSET', KEYS[4], claim.key, next .. ':' .. fired .. ':' .. delivery .. ':r')
+		redis.call(ARGV[1], claim.queue, claim.key)
+		redis.call('HDEL', KEYS[2], token)
+	end
+	redis.call('ZREM', KEYS[1], token)
+end
+return #tokens
+`)
+
+// Claim pops the next timer from the queue and claims it for the visibility
+// timeout, returning its key and a claim token, or ErrNoTimers if the queue is
+// empty. The timer is returned to the queue by Reclaim unless Complete is
+// called with the token before the visibility timeout passes. This gives
+// at-least-once delivery without a stream: a consumer that crashes while
+// handling a timer doesn't lose it. Claim doesn't block, use Subscribe to wait
+// for timers to fire.
+//
+// Claim only returns the timer's key, use ClaimTimer to get its value, label
+// and other details. A warning is returned with the key that it was queued
+// with rather than the key of the timer it warns about, so that it can't be
+// mistaken for the timer firing, see CreateOptions.Warnings. A timer that was
+// claimed is returned with its token even if there's an error, such as
+// ErrPayloadMissing, so that its claim can still be completed.
+//
+// Claim returns ErrQueueMismatch if the namespace uses QueueStream, where
+// NextGroup and ClaimPending provide the same guarantees.
+func (n *Namespace) Claim(ctx context.Context, visibility time.Duration) (key string, token string, err error) {
+	t, token, err := n.ClaimTimer(ctx, visibility)
+	if t.Key == "" {
+		return "", "", err
+	}
+	if t.Warning != 0 {
+		return warningKey(t.Key, t.Warning), token, err
+	}
+	return t.Key, token, err
+}
+
+// ClaimTimer is like Claim, but returns a FiredTimer with the details of the
+// timer, like NextTimer. Recurring timers are re-armed, and the timers that
+// depend on the timer are created, when it's first claimed, while the timer's
+// value and other data are kept until the claim is completed, so a timer
+// that's claimed again after Reclaim is returned with the same details and
+// delivery ID.
+func (n *Namespace) ClaimTimer(ctx context.Context, visibility time.Duration) (t FiredTimer, token string, err error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList {
+		return t, "", ErrQueueMismatch
+	}
+	ms, err := durationMs(visibility)
+	if err != nil {
+		return t, "", err
+	}
+	defer n.present(ctx)()
+	token = strconv.FormatInt(n.client.random(), 36)
+	var key string
+	for _, queue := range n.queueKeys() {
+		key, err = n.runScript(ctx, claimScript,
+			[]string{queue, n.claimedKey(), n.claimsKey(), n.tokensKey()},
+			n.popCommand(), ms, token).Text()
+		if err != redis.Nil {
+			t.Shard = n.queueShard(queue)
+			break
+		}
+	}
+	if err == redis.Nil {
+		return FiredTimer{}, "", ErrNoTimers
+	}
+	if err != nil {
+		return FiredTimer{}, "", err
+	}
+	t.Key = key
+	err = n.rearmFor(ctx, &t, true)
+	return t, token, err
+}
+
+// Complete marks the timer claimed with the given token as handled, so that it
+// isn't returned to the queue. It returns ErrClaimNotFound if the claim was
+// already completed, or expired and was reclaimed, in which case the timer may
+// be handled again by another consumer. Completing the claim of a one-shot
+// timer removes the value and other data that Claim kept for redeliveries.
+func (n *Namespace) Complete(ctx context.Context, token string) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	raw, err := n.client.r.HGet(ctx, n.claimsKey(), token).Result()
+	if err == redis.Nil {
+		return ErrClaimNotFound
+	}
+	if err != nil {
+		return err
+	}
+	var claim struct {
+		Key string `json:"key"`
+	}
+	err = json.Unmarshal([]byte(raw), &claim)
+	if err != nil {
+		return fmt.Errorf("decoding claim %s: %w", token, err)
+	}
+	key, offset := splitWarningKey(claim.Key)
+	warning := ""
+	if offset != 0 {
+		warning = "1"
+	}
+	companions := n.companionKeys()
+	removed, err := n.runScript(ctx, completeScript,
+		append([]string{n.claimedKey(), n.claimsKey(), n.timerKey(key), n.registeredKeyFor(key)}, companions[:]...),
+		token, key, warning).Int()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return ErrClaimNotFound
+	}
+	return nil
+}
+
+// Reclaim returns the timers whose claims have expired without being completed
+// to the end of the queue that's popped next, and returns how many there were.
+func (n *Namespace) Reclaim(ctx context.Context) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	return n.runScript(ctx, reclaimScript,
+		[]string{n.claimedKey(), n.claimsKey(), n.tokenKey(), n.tokensKey()},
+		n.pushFrontCommand()).Int()
+}
+
+// ReclaimLoop calls Reclaim every interval until the context is cancelled, and
+// then returns the context's error. Errors from Reclaim are passed to
+// OnPollError if it's set.
+func (n *Namespace) ReclaimLoop(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		_, err := n.Reclaim(ctx)
+		if err != nil && n.OnPollError != nil && ctx.Err() == nil {
+			n.OnPollError(err)
+		}
+	}
+}
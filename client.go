@@ -2,181 +2,1990 @@ package rimer
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/redis/go-redis/v9"
+	"hash/crc32"
+	"math/rand"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 var (
-	defaultPrefix = "timers"
+	defaultPrefix    = "timers"
+	defaultNamespace = "default"
 )
 
 // Client is a client for managing timers. It uses several Redis data structures
-// and stores them using the following key-naming scheme.
+// and stores them using the following key-naming scheme (using the default
+// KeyBuilder).
 //
 // timers:<namespace>:timer:<key>
 //
 //	The expiring timer itself, you can specify any key and value
 //
+// timers:<namespace>:dedup:<key>
+//
+//	A marker that suppresses duplicate timers created with CreateDedup
+//
 // timers:<namespace>:registered
 //
 //	A set of all registered timer keys
 //
-// timers:<namespace>:_registered_<random number>
+// timers:<namespace>:registered:<bucket>
+//
+//	The registered timer keys when using RepresentationBucketed
+//
+// timers:<namespace>:scheduled
+//
+//	A sorted set of registered timer keys scored by fire time when using
+//	RepresentationSortedSet
+//
+// timers:<namespace>:_meta
+//
+//	A hash of metadata about the namespace, such as its schema version
+//
+// timers:<namespace>:_registered_[<poller id>_]<random number>
 //
-//	Used temporarily during polling to determine which timers need to be fired
+//	Used temporarily during polling to determine which timers need to be
+//	fired, and expires after 10 minutes in case polling is interrupted. The
+//	poller ID is the client's PollerID, if it's set
 //
 // timers:<namespace>:queue
 //
 //	A list of all timers that need to be fired
+//
+// timers:<namespace>:queue:<shard>
+//
+//	The lists of timers that need to be fired when using QueueShards
+//
+// timers:<namespace>:claimed
+//
+//	A sorted set of the tokens of claimed timers scored by the time that their
+//	claims expire, see Claim
+//
+// timers:<namespace>:claims
+//
+//	A hash of claim tokens and the timers and queues that they were claimed
+//	from, see Claim
+//
+// timers:<namespace>:cancelled
+//
+//	A hash of the tombstones of recently cancelled timers, see CancelRetention
+//
+// timers:<namespace>:cancellations
+//
+//	A sorted set of recently cancelled timers scored by the time that they
+//	were cancelled, see CancelRetention
+//
+// timers:<namespace>:deadletters
+//
+//	The list of timers that consumers gave up on, see DeadLetter
+//
+// timers:<namespace>:deadlettered
+//
+//	A hash of the number of times that each timer in the dead letter queue was
+//	already replayed
+//
+// timers:<namespace>:stream
+//
+//	A stream of fired timers when using QueueStream
+//
+// timers:<namespace>:fired
+//
+//	A pub/sub channel that the keys of fired timers are published to
+//
+// timers:<namespace>:fires
+//
+//	A ring buffer of the number of timers fired in each second of the last
+//	hour, see FireRate
+//
+// timers:<namespace>:recurring
+//
+//	A hash of recurring timer keys and their intervals in milliseconds
+//
+// timers:<namespace>:after
+//
+//	A hash of timer keys and the timers that are created once they fire, see
+//	CreateAfter
+//
+// timers:<namespace>:labels
+//
+//	A hash of timer keys and their labels, see CreateOptions
+//
+// timers:<namespace>:values
+//
+//	A hash of timer keys and their values, see CreateOptions
+//
+// timers:<namespace>:warnings
+//
+//	A hash of timer keys and the offsets of their warnings, see CreateOptions
+//
+// timers:<namespace>:fields
+//
+//	A hash of timer keys and their fields, see CreateOptions
+//
+// timers:<namespace>:lazy
+//
+//	A set-like hash of the keys of the timers whose values are resolved
+//	lazily, see CreateOptions
+//
+// timers:<namespace>:metadata
+//
+//	A hash of the metadata of the timers that have any, see CreateOptions
+//
+// timers:<namespace>:guards
+//
+//	A hash of the guard keys of the timers that have one, see CreateOptions
+//
+// timers:<namespace>:replays
+//
+//	A hash of the number of times that timers were replayed from the dead
+//	letter queue, see ReplayDeadLetters
+//
+// timers:<namespace>:valued
+//
+//	A set-like hash of the keys of the timers that were created with a value,
+//	so that a value that went missing can be told apart from none, see
+//	IgnoreMissingValues
+//
+// timers:<namespace>:tags
+//
+//	A hash of timer keys and the tag indexes that they're in, see
+//	CreateOptions
+//
+// timers:<namespace>:idx:<tag>:<value>
+//
+//	A set of the keys of the timers with the given tag value, see FindByTag
+//
+// timers:<namespace>:token
+//
+//	A counter that the fencing token of each fired timer is taken from
+//
+// timers:<namespace>:tokens
+//
+//	A hash of the fencing tokens of the timers waiting in the queue
 type Client struct {
 	r      *redis.Client
 	Prefix string
+
+	// KeyBuilder builds the redis keys from their parts. Defaults to joining
+	// the parts with ":", see Migrate for changing the KeyBuilder of existing
+	// timers.
+	KeyBuilder KeyBuilder
+
+	// DefaultNamespace is the namespace used by the Create, Poll and Next
+	// methods on the client itself. Defaults to "default".
+	DefaultNamespace string
+
+	// Rand is the source of randomness for the suffixes of temporary keys and
+	// for jitter. It's only used while holding a lock, so it doesn't need to be
+	// safe for concurrent use. Defaults to a source seeded for each client, so
+	// clients don't contend on the global source. Set it to a source with a
+	// fixed seed for reproducible tests.
+	Rand   rand.Source
+	randMu sync.Mutex
+
+	// DefaultTimeout bounds each call to a method that doesn't wait for timers
+	// to fire, such as Create and Poll, when the caller's context doesn't have
+	// a deadline, so that a dropped connection can't hang the call forever. A
+	// deadline on the caller's context always takes precedence, and calls that
+	// create or cancel many timers at once should be given one that's long
+	// enough. Next and the other methods that block until a timer is available
+	// aren't bounded. Zero means no timeout.
+	DefaultTimeout time.Duration
+
+	// SyncClock makes CreateAt measure fire times against the Redis server's
+	// clock rather than this machine's, so that absolute fire times are
+	// honored across machines whose clocks are skewed. The offset between the
+	// clocks is measured with the TIME command and cached for ClockRefresh.
+	SyncClock bool
+
+	// ClockRefresh is how often the offset between the clocks is measured
+	// again when SyncClock is set. Defaults to one minute.
+	ClockRefresh time.Duration
+
+	// ResolveAliases makes Namespace resolve names that are aliases of other
+	// namespaces, see AliasNamespace. Each name is looked up in Redis the first
+	// time it's used, and cached for the lifetime of the client.
+	ResolveAliases bool
+
+	aliasMu sync.Mutex
+	aliases map[string]string
+
+	// Codec encodes the values of timers before they're stored, for example to
+	// compress them, and decodes them when they're read. Defaults to nil,
+	// which stores values as they are.
+	Codec Codec
+
+	// Codecs are the codecs that values may have been stored with besides
+	// Codec, so that values stored before Codec was changed can still be read.
+	// Values stored without a codec can always be read.
+	Codecs []Codec
+
+	// PollerID identifies this client in the names of the temporary sets that
+	// its Polls create, so that a set left behind by a stuck or interrupted
+	// Poll can be traced back to the poller that created it, see TempSets. Set
+	// it to something like the hostname and process ID. It shouldn't contain
+	// glob characters. Defaults to "", which leaves it out of the names.
+	PollerID string
+
+	clockMu       sync.Mutex
+	clockOffset   time.Duration
+	clockSyncedAt time.Time
+
+	// consumerID identifies this client in the presence keys of the
+	// namespaces it consumes, and presence tracks those keys, see present.
+	consumerOnce    sync.Once
+	consumerID      string
+	presenceMu      sync.Mutex
+	presence        map[string]*presenceState
+	presenceRunning bool
+
+	// lmpop is whether the server supports LMPOP, and lmpopKnown is whether
+	// the server's version has been checked yet, see supportsLMPop.
+	lmpopMu    sync.Mutex
+	lmpop      bool
+	lmpopKnown bool
 }
 
 // New creates a new rimer client that uses the given redis client.
 func New(client *redis.Client) *Client {
 	return &Client{
-		r:      client,
-		Prefix: defaultPrefix,
+		r:                client,
+		Prefix:           defaultPrefix,
+		KeyBuilder:       defaultKeyBuilder,
+		DefaultNamespace: defaultNamespace,
+		Rand:             rand.NewSource(time.Now().UnixNano()),
+	}
+}
+
+// Redis returns the redis client that the client uses. Hooks added to it with
+// AddHook are run for every command that rimer sends, which is useful for
+// instrumentation and for injecting failures in tests, see rimertest.Fail.
+func (c *Client) Redis() *redis.Client {
+	return c.r
+}
+
+// withDefaultTimeout bounds the context by DefaultTimeout if it's set and the
+// context doesn't already have a deadline.
+func (c *Client) withDefaultTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.DefaultTimeout <= 0 {
+		return ctx, func() {}
 	}
+	return context.WithTimeout(ctx, c.DefaultTimeout)
+}
+
+// random returns a non-negative random number from the client's source.
+func (c *Client) random() int64 {
+	c.randMu.Lock()
+	defer c.randMu.Unlock()
+	return c.Rand.Int63()
+}
+
+// Create creates a new timer in the default namespace. See Namespace.Create.
+func (c *Client) Create(ctx context.Context, key string, duration time.Duration) error {
+	return c.Namespace(c.DefaultNamespace).Create(ctx, key, duration)
+}
+
+// Poll polls the timers in the default namespace. See Namespace.Poll.
+func (c *Client) Poll(ctx context.Context) error {
+	return c.Namespace(c.DefaultNamespace).Poll(ctx)
+}
+
+// Next returns the next timer that fired in the default namespace. See Namespace.Next.
+func (c *Client) Next(ctx context.Context) (string, error) {
+	return c.Namespace(c.DefaultNamespace).Next(ctx)
+}
+
+// NextAny returns the next timer that fired in any of the given namespaces,
+// along with the namespace that it fired in. If there are no timers available,
+// this will block until one is available. This lets a single consumer serve
+// many namespaces without a separate Next call for each of them. Timers are
+// always returned in OrderFIFO. Like Next, a timer that was taken from a queue
+// is returned even if there's an error.
+func (c *Client) NextAny(ctx context.Context, namespaces ...string) (ns string, key string, err error) {
+	if len(namespaces) == 0 {
+		return "", "", fmt.Errorf("at least one namespace is required")
+	}
+	queues := make(map[string]*Namespace, len(namespaces))
+	keys := make([]string, 0, len(namespaces))
+	for _, name := range namespaces {
+		n := c.Namespace(name)
+		for _, k := range n.queueKeys() {
+			keys = append(keys, k)
+			queues[k] = n
+		}
+	}
+	var stops []func()
+	for _, n := range queues {
+		stops = append(stops, n.present(ctx))
+	}
+	res, err := c.r.BRPop(ctx, 0, keys...).Result()
+	for _, stop := range stops {
+		stop()
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if len(res) != 2 {
+		return "", "", fmt.Errorf("expected 2 keys, got %d", len(res))
+	}
+	n, ok := queues[res[0]]
+	if !ok {
+		return "", "", fmt.Errorf("timer popped from unexpected queue %s", res[0])
+	}
+	t := FiredTimer{Key: res[1]}
+	err = n.rearm(ctx, &t)
+	return n.name, t.Key, err
 }
 
 // Namespace allows callers to scope timers to a particular namespace. This means
 // that timers in this namespace will have the namespace's prefix in Redis, they'll
 // also be independent of timers in other namespaces. Polling timers in one namespace
 // will not affect timers in another namespace.
+//
+// The name is used as is, see NamespaceChecked for the rules that a name should
+// follow so that it can't collide with other namespaces. If ResolveAliases is
+// set and the name is an alias, the returned namespace is the one that the
+// alias points to, see AliasNamespace.
 func (c *Client) Namespace(ns string) *Namespace {
+	if c.ResolveAliases {
+		ns = c.resolveAlias(ns)
+	}
+	return c.namespace(ns)
+}
+
+// namespace returns the namespace with the given name without resolving it.
+func (c *Client) namespace(ns string) *Namespace {
 	return &Namespace{
 		name:   ns,
 		client: c,
 	}
 }
 
+// Name returns the name of the namespace.
+func (n *Namespace) Name() string {
+	return n.name
+}
+
+// NamespaceChecked is like Namespace, but returns ErrInvalidNamespace if the
+// name isn't safe to use in Redis keys. A name must not be empty, must not
+// contain the client's key separator, which would change the structure of the
+// keys and could make namespaces collide, and must not contain any of the glob
+// characters '*', '?', '[', ']' or '\', which would make rimer's scans match
+// keys in other namespaces. For the same reason, every name is rejected if the
+// client's prefix or key separator contains glob characters.
+func (c *Client) NamespaceChecked(ns string) (*Namespace, error) {
+	if ns == "" {
+		return nil, fmt.Errorf("%w: name is empty", ErrInvalidNamespace)
+	}
+	if strings.ContainsAny(ns, `*?[]\`) {
+		return nil, fmt.Errorf("%w: %q contains glob characters", ErrInvalidNamespace, ns)
+	}
+	parts := []string{c.Prefix, ns, "timer", "key"}
+	key := c.KeyBuilder.Join(parts...)
+	if strings.ContainsAny(key, `*?[]\`) {
+		return nil, fmt.Errorf("%w: the client's prefix or key separator contains glob characters", ErrInvalidNamespace)
+	}
+	split := c.KeyBuilder.Split(key, len(parts)+1)
+	if len(split) != len(parts) || split[1] != ns {
+		return nil, fmt.Errorf("%w: %q contains the key separator", ErrInvalidNamespace, ns)
+	}
+	return c.Namespace(ns), nil
+}
+
 type Namespace struct {
 	name   string
 	client *Client
+
+	// scheduled holds the functions passed to Schedule by the key of their
+	// timer in the schedule namespace, and scheduling is true while
+	// runScheduled is running.
+	scheduleMu sync.Mutex
+	scheduled  map[string]func()
+	schedule   *Namespace
+	scheduling bool
+
+	// Representation is the data structure used to keep track of registered
+	// timers in Redis. Defaults to RepresentationSet.
+	Representation Representation
+
+	// Queue is the data structure that fired timers are delivered to.
+	// Defaults to QueueList.
+	Queue Queue
+
+	// Order is the order in which Next returns the timers waiting in the queue
+	// when using QueueList. Defaults to OrderFIFO.
+	Order Order
+
+	// NextIdleBackoff makes Next and NextTimer poll the queue instead of
+	// holding a connection in a blocking pop while it's empty. They sleep for
+	// NextIdleBackoff after finding the queue empty, doubling the sleep each
+	// time up to NextIdleBackoffMax, and reset it as soon as a timer is
+	// available. This suits many mostly idle namespaces consumed by a shared
+	// pool of connections, at the cost of picking up timers later. Zero means
+	// Next blocks in Redis, which is the default.
+	NextIdleBackoff time.Duration
+
+	// NextIdleBackoffMax caps the sleep between checks of an empty queue, see
+	// NextIdleBackoff. Defaults to 5 seconds.
+	NextIdleBackoffMax time.Duration
+
+	// QueueShards spreads fired timers across this many queues when using
+	// QueueList, by hashing their keys, so that many consumers can pop timers
+	// without contending on a single list. Next pops from every shard, while
+	// NextShard pops from a single one so that each consumer can be assigned
+	// its own. Timers are only ordered within a shard. Changing the number of
+	// shards strands the timers that are waiting in the queues, so drain them
+	// first. Zero or one means a single queue, which is the default.
+	QueueShards int
+
+	// StreamMaxLen approximately caps the length of the stream when using
+	// QueueStream. Entries stay in the stream after they're acknowledged, so
+	// without a cap the stream grows forever. Zero means no cap.
+	StreamMaxLen int64
+
+	// IdleTTL is a safety net for namespaces that are abandoned. When it's set,
+	// the queue and the keys that timers are registered in expire once nothing
+	// has been written to them for this long, as every write refreshes their
+	// TTL. It must be much longer than any timer's duration and than the time
+	// between Polls, otherwise active timers are lost. Zero means no TTL.
+	IdleTTL time.Duration
+
+	// PollTimeout bounds each Redis command that Poll runs, regardless of the
+	// context's deadline. Zero means no timeout.
+	PollTimeout time.Duration
+
+	// PollLock makes Poll take a lock in Redis that expires after PollLock
+	// before it looks for expired timers, and return ErrPollSkipped without
+	// doing anything if another poller already holds it, so that only one of
+	// many pollers does the work in each period. The lock isn't released when
+	// Poll returns, so set it a little shorter than the interval between Polls,
+	// so that the lock is free again by the next one, but longer than a Poll
+	// takes, so that two Polls never run at the same time. PollLoop doesn't
+	// treat skipped Polls as errors. Zero means no lock.
+	PollLock time.Duration
+
+	// PollMaxFire caps the number of timers that each Poll fires. The rest
+	// stay registered and are fired by later Polls, so a large backlog takes
+	// several Polls to drain, but a single Poll can't keep Redis busy for long.
+	// Zero means no cap.
+	PollMaxFire int
+
+	// MaxPollDuration bounds how long each Poll spends firing timers. Once it
+	// has passed, Poll stops after the timer it's firing and reports the Poll
+	// as Truncated in its PollResult, leaving the rest of the expired timers
+	// registered for the next Poll. Timers are fired atomically one at a time,
+	// so a truncated Poll never loses a timer or fires one twice, and at least
+	// one timer is fired by each Poll so that a backlog always drains. PollLoop
+	// polls again right away after a truncated Poll. Zero means no limit.
+	//
+	// Only firing is bounded. Finding the expired timers takes a few commands
+	// that each go over every registered timer, such as KEYS and SDIFF or
+	// ZRANGEBYSCORE depending on the Representation, and these aren't cut
+	// short, so a Poll of a namespace with many registered timers can take
+	// longer than MaxPollDuration before it fires anything. The time they
+	// take still counts towards the limit.
+	MaxPollDuration time.Duration
+
+	// PollYield is how long Poll pauses after firing each batch of
+	// pollYieldBatch timers, which gives other Redis clients a chance to run
+	// while a large backlog is being fired, at the cost of a slower Poll.
+	// Zero means no pause.
+	PollYield time.Duration
+
+	// StrictExpiry makes Poll check the PTTL of each timer it's about to fire
+	// when using RepresentationSet or RepresentationBucketed, at the cost of an
+	// extra round trip per Poll. By default, a Poll that finds no timer keys at
+	// all treats every registered timer as expired, so a timer that's created
+	// while the Poll is running can fire right away. With StrictExpiry, only
+	// timers whose keys no longer exist are fired. Note that timers whose keys
+	// were evicted still fire early either way. RepresentationSortedSet always
+	// checks.
+	StrictExpiry bool
+
+	// MaxDuration caps the duration of the timers created in this namespace,
+	// as a guardrail against code that computes durations far in the future
+	// by mistake. Creating a longer timer fails with ErrDurationTooLong, or
+	// creates it with MaxDuration instead if ClampDuration is set. Zero means
+	// no limit.
+	MaxDuration time.Duration
+
+	// ClampDuration makes creating a timer longer than MaxDuration shorten it
+	// to MaxDuration instead of failing.
+	ClampDuration bool
+
+	// TimeScale multiplies the duration of every timer created in this
+	// namespace, so 0.1 makes timers fire ten times sooner. It's meant for
+	// load tests and staging environments that want to run through
+	// timer-heavy flows quickly without changing the durations used by the
+	// application, and shouldn't be set in production. It only applies when
+	// a timer is created, including by CreateAt, Upsert and Heartbeat, and
+	// is applied before MaxDuration. The intervals of recurring timers and
+	// the offsets of warnings are scaled too, so a warning keeps its place
+	// relative to its timer, but cron schedules and timers restored by Import
+	// aren't.
+	// Timers that already exist, and methods like ExtendLater that change
+	// them, aren't affected. Zero or one means no scaling.
+	TimeScale float64
+
+	// PollJitter randomly shortens or lengthens the time between Polls in
+	// PollLoop by up to this fraction of it, so that many pollers started at
+	// the same time don't all hit Redis at once. Zero means no jitter.
+	PollJitter float64
+
+	// PollBackoffMax caps the time between Polls in PollLoop while Poll keeps
+	// failing. Defaults to one minute.
+	PollBackoffMax time.Duration
+
+	// PollBackoffMultiplier is how much PollLoop increases the time between
+	// Polls after each consecutive failure. Defaults to 2.
+	PollBackoffMultiplier float64
+
+	// OnPollError is called by PollLoop with every error returned by Poll.
+	OnPollError func(err error)
+
+	// CancelRetention makes Cancel, CancelWithReason and CancelMatching leave
+	// a tombstone for each timer that they cancel, recording when it was
+	// cancelled and why, which is kept for CancelRetention so that recent
+	// cancellations can be inspected with ListCancelled. Zero means no
+	// tombstones are recorded.
+	CancelRetention time.Duration
+
+	// FireDependentsOnCancel makes cancelling a timer create the timers that
+	// depend on it, see CreateAfter, as if it had fired. By default they're
+	// cancelled along with it.
+	FireDependentsOnCancel bool
+
+	// IgnoreMissingValues makes Next and the other methods that consume timers
+	// return a nil value when a timer that was created with a value no longer
+	// has it, for example because it was removed by a race with Cancel or
+	// evicted by Redis. By default they return the timer along with
+	// ErrPayloadMissing instead, so that consumers can tell a lost value apart
+	// from a timer that was created without one, which always has a nil value.
+	// An empty value is still a value. The timer is consumed either way.
+	IgnoreMissingValues bool
+
+	// ResolveValue computes the value of a timer that was created with
+	// CreateOptions.LazyValue when it's consumed, and its result is returned
+	// as the timer's value. It's called synchronously by Next and the other
+	// methods that consume timers, after the timer has been removed from the
+	// queue, so if it fails, the timer is returned along with the error and
+	// isn't delivered again. If it's nil, lazy timers are returned without a
+	// value for the consumer to resolve itself.
+	ResolveValue func(ctx context.Context, key string) ([]byte, error)
+
+	// OnCreate is called with the key and duration of each timer right after
+	// it has been created by any of the Create methods, Upsert or Import, or
+	// because a timer it depends on fired, see CreateAfter. It's not called
+	// for timers that weren't created because of deduplication, or for
+	// warnings. It's called synchronously, so it shouldn't block. Use it for
+	// auditing or metrics.
+	OnCreate func(key string, duration time.Duration)
+
+	// OnFire is called by Poll with the key of each timer right after it has
+	// been enqueued. It's called synchronously, so a slow callback slows down
+	// the whole Poll. Use it for auditing or metrics, and leave the actual
+	// work to consumers of Next.
+	OnFire func(key string)
+
+	// BeforeFire is called by Poll with the key of each expired timer right
+	// before it's enqueued, and can veto the timer by returning false, for
+	// example because the entity that it acts on was deleted. A vetoed timer
+	// is removed entirely, as if it was cancelled, and counted as dropped in
+	// the PollResult. Like GuardKey, but for conditions that only the
+	// application can check. It's called synchronously, so a slow callback
+	// slows down the whole Poll, and it should be fast.
+	BeforeFire func(key string) bool
+
+	// OnPoll is called at the end of every Poll with what it did, how long it
+	// took and the error it returned, if any. Like OnFire, it's called
+	// synchronously. Use it for metrics.
+	OnPoll func(r PollResult, duration time.Duration, err error)
+
+	// RecordFireTime makes Poll record the time that each timer fired along
+	// with its fencing token, so that consumers can measure the delivery
+	// latency from FiredTimer.FiredAt without an extra lookup. Timers that
+	// fired while it wasn't set, or that were requeued, have no fire time.
+	RecordFireTime bool
+
+	// MaxReplays is the number of times that a timer can be replayed from the
+	// dead letter queue by ReplayDeadLetters. Defaults to 3.
+	MaxReplays int
+
+	// CoalesceQueue makes Poll skip pushing a timer onto the queue when it's
+	// already waiting there, as a safety net against consumers handling the
+	// same timer twice, for example when a timer is re-created and fires again
+	// before its previous firing was consumed. The queue is searched as each
+	// timer fires, which takes time proportional to the length of the queue,
+	// so it's best suited to queues that are kept short. It has no effect with
+	// QueueStream.
+	CoalesceQueue bool
 }
 
 // Poll iterates over all available timers and executes them if they are ready.
+// Timers found by different Polls are queued in the order of the Polls, while
+// the order within a single Poll depends on the namespace's Representation.
+//
+// If PollTimeout is set, each Redis command that Poll runs is bounded by it and
+// ErrPollTimeout is returned if one of them takes too long. Timers are fired
+// one at a time, so any timers that were fired before the timeout stay queued
+// and the rest are picked up by the next Poll.
 func (n *Namespace) Poll(ctx context.Context) error {
-	_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
-		s1 := n.registeredKey()
-		s2, err := n.getRegisteredTempSet(ctx, p)
+	_, err := n.PollWithResult(ctx)
+	return err
+}
+
+// PollResult describes what a single Poll did.
+type PollResult struct {
+	// Expired is the number of registered timers that had expired.
+	Expired int
+	// Fired is the number of expired timers that were fired. It's lower than
+	// Expired if PollMaxFire is set or timers were dropped or coalesced, and
+	// should never be higher.
+	Fired int
+	// Dropped is the number of expired timers that were dropped instead of
+	// being fired because their guard key no longer exists, see CreateOptions,
+	// or because BeforeFire vetoed them.
+	Dropped int
+	// Coalesced is the number of expired timers that weren't pushed onto the
+	// queue because they were already in it, see CoalesceQueue.
+	Coalesced int
+	// Skipped is the number of registered timers that hadn't expired yet. It's
+	// counted separately from the other numbers, so timers that are created
+	// or expire while polling may make it slightly off.
+	Skipped int
+	// Truncated is whether Poll stopped firing timers because it took longer
+	// than MaxPollDuration, so that expired timers are left for the next Poll.
+	Truncated bool
+}
+
+// PollWithResult is like Poll, but also reports how many timers it fired and
+// skipped. A namespace with many skipped timers and no fired ones is healthy,
+// while firing more timers than had expired indicates that timers are firing
+// early, for example because Redis evicted their keys.
+func (n *Namespace) PollWithResult(ctx context.Context) (r PollResult, err error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	deadline := n.pollDeadline()
+	if n.OnPoll != nil {
+		start := time.Now()
+		defer func() {
+			n.OnPoll(r, time.Since(start), err)
+		}()
+	}
+	defer func() {
+		err = readOnlyError(err)
+	}()
+	err = n.pollOp(ctx, n.checkMeta)
+	if err != nil {
+		return
+	}
+	if n.PollLock > 0 {
+		err = n.pollOp(ctx, n.lockPoll)
 		if err != nil {
-			return err
+			return
 		}
+	}
+	keys, err := n.expired(ctx)
+	if err != nil {
+		return
+	}
+	r.Expired = len(keys)
+	var registered int
+	err = n.pollOp(ctx, func(ctx context.Context) (err error) {
+		registered, err = n.registeredCount(ctx)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	if registered > r.Expired {
+		r.Skipped = registered - r.Expired
+	}
+	err = n.fire(ctx, keys, deadline, &r)
+	return
+}
 
-		// Figure out which timers need to be fired. We do this by finding
-		// all the unexpired keys, then adding them to a temporary set, and
-		// performing a set difference between the temporary set and the set
-		// of registered timers. The result is the set of timers that need
-		// to be fired.
-		keys, err := n.client.r.Keys(ctx, n.timerKey("*")).Result()
-		if err != nil {
-			return err
+// pollDeadline returns the time by which a Poll that starts now should stop
+// firing timers, or the zero time if MaxPollDuration isn't set.
+func (n *Namespace) pollDeadline() time.Time {
+	if n.MaxPollDuration <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(n.MaxPollDuration)
+}
+
+// lockPoll takes the poll lock for PollLock, and returns ErrPollSkipped if
+// another poller holds it.
+func (n *Namespace) lockPoll(ctx context.Context) error {
+	ok, err := n.client.r.SetNX(ctx, n.pollLockKey(), strconv.FormatInt(n.client.random(), 36), n.PollLock).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrPollSkipped
+	}
+	return nil
+}
+
+// verifyExpired returns the given timers whose keys no longer exist, see
+// StrictExpiry.
+func (n *Namespace) verifyExpired(ctx context.Context, keys []string) ([]string, error) {
+	cmds, err := n.pttl(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	expired := keys[:0]
+	for i, cmd := range cmds {
+		if cmd.Val() == -2 {
+			expired = append(expired, keys[i])
 		}
-		if len(keys) > 0 {
-			err = n.client.r.SAdd(ctx, s2, toAny(keys)...).Err()
-			if err != nil {
-				return err
-			}
+	}
+	return expired, nil
+}
+
+// expiredSets returns the registered timers that have expired when using
+// RepresentationSet or RepresentationBucketed.
+func (n *Namespace) expiredSets(ctx context.Context) (expired []string, err error) {
+	expired, err = n.diffRegistered(ctx)
+	if err != nil || !n.StrictExpiry || len(expired) == 0 {
+		return expired, err
+	}
+	err = n.pollOp(ctx, func(ctx context.Context) error {
+		expired, err = n.verifyExpired(ctx, expired)
+		return err
+	})
+	return expired, err
+}
+
+// diffRegistered returns the registered timers whose keys don't exist when
+// using RepresentationSet or RepresentationBucketed.
+func (n *Namespace) diffRegistered(ctx context.Context) (expired []string, err error) {
+	var s2 string
+	err = n.pollOp(ctx, func(ctx context.Context) error {
+		s2, err = n.getRegisteredTempSet(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Figure out which timers need to be fired. We do this by finding
+	// all the unexpired keys, then adding them to a temporary set, and
+	// performing a set difference between the temporary set and the set
+	// of registered timers. The result is the set of timers that need
+	// to be fired.
+	var keys []string
+	err = n.pollOp(ctx, func(ctx context.Context) error {
+		keys, err = n.client.r.Keys(ctx, n.timerKey("*")).Result()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	prefix := n.timerKey("")
+	for i, k := range keys {
+		keys[i] = strings.TrimPrefix(k, prefix)
+	}
+	if len(keys) == 0 {
+		// If all the timers are expired, then the diff is going to
+		// just be any registered keys.
+		err = n.pollOp(ctx, func(ctx context.Context) error {
+			expired, err = n.registeredMembers(ctx)
+			return err
+		})
+		return expired, err
+	}
+	defer n.client.r.Del(ctx, s2)
+	err = n.pollOp(ctx, func(ctx context.Context) error {
+		// The temporary set expires on its own in case we never get to
+		// delete it, see Reconcile.
+		_, err := n.client.r.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.SAdd(ctx, s2, toAny(keys)...)
+			p.PExpire(ctx, s2, registeredTempTTL)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, s1 := range n.registeredKeys() {
+		err = n.pollOp(ctx, func(ctx context.Context) error {
 			keys, err = n.client.r.SDiff(ctx, s1, s2).Result()
-			if err != nil {
-				return err
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, keys...)
+	}
+	return expired, nil
+}
+
+// fireScript moves a timer from the registered timers onto the queue, which is
+// either a list or a stream depending on ARGV[3], and publishes its key to the
+// ARGV[2] channel. Each fire takes the next fencing token from the KEYS[3]
+// counter, which is stored in the KEYS[4] hash until the timer is consumed from
+// a list, or added to the entry in a stream. The fire is counted for the
+// current second in the KEYS[5] ring buffer of ARGV[5] seconds, see FireRate. If the timer has a guard key in the KEYS[6] hash that no longer
+// exists, the timer is dropped and forgotten using the companion hashes from
+// KEYS[7] onwards instead. If ARGV[6] is "1" and the timer is already in the
+// list, it's unregistered without being pushed again, see CoalesceQueue.
+// If ARGV[7] is "1", the time that the timer fired is recorded along with its
+// fencing token, as "<token>:<milliseconds>" in the hash or as the fired field
+// of the stream entry, see RecordFireTime. Returns the fencing token, 0 if the
+// timer was dropped, or -1 if it was coalesced.
+var fireScript = newNamespaceScript(`
+local guard = redis.call('HGET', KEYS[6], ARGV[1])
+if guard and redis.call('EXISTS', guard) == 0 then
+	unregister(KEYS[2], ARGV[1])
+	forget(7, ARGV[1])
+	return 0
+end
+if ARGV[6] == '1' and redis.call('LPOS', KEYS[1], ARGV[1]) then
+	unregister(KEYS[2], ARGV[1])
+	return -1
+end
+local token = redis.call('INCR', KEYS[3])
+if ARGV[3] == 'stream' then
+	local entry = {'key', ARGV[1], 'token', token}
+	if ARGV[7] == '1' then
+		table.insert(entry, 'fired')
+		table.insert(entry, now)
+	end
+	if ARGV[4] ~= '0' then
+		redis.call('XADD', KEYS[1], 'MAXLEN', '~', ARGV[4], '*', unpack(entry))
+	else
+		redis.call('XADD', KEYS[1], '*', unpack(entry))
+	end
+else
+	if ARGV[7] == '1' then
+		redis.call('HSET', KEYS[4], ARGV[1], token .. ':' .. now)
+	else
+		redis.call('HSET', KEYS[4], ARGV[1], token)
+	end
+	redis.call('LPUSH', KEYS[1], ARGV[1])
+end
+touch(KEYS[1])
+unregister(KEYS[2], ARGV[1])
+local second = math.floor(now / 1000)
+local slot = second % tonumber(ARGV[5])
+local fires = 1
+local counted = redis.call('HGET', KEYS[5], slot)
+if counted then
+	local at, count = string.match(counted, '^(%d+):(%d+)$')
+	if tonumber(at) == second then
+		fires = tonumber(count) + 1
+	end
+end
+redis.call('HSET', KEYS[5], slot, string.format('%d:%d', second, fires))
+redis.call('EXPIRE', KEYS[5], ARGV[5])
+redis.call('PUBLISH', ARGV[2], ARGV[1])
+return token
+`)
+
+// pollYieldBatch is the number of timers that Poll fires between the pauses
+// set by PollYield.
+const pollYieldBatch = 100
+
+// fire moves the given timers from the registered timers onto the queue. Each
+// timer is fired atomically on its own so that it's either queued and no
+// longer registered, or left as-is to be fired by the next Poll. Timers whose
+// guard key no longer exists are dropped instead, see CreateOptions, and
+// timers that are already in the queue are coalesced, see CoalesceQueue. The
+// number of timers handled is capped by PollMaxFire, and fire pauses for
+// PollYield between batches. If deadline isn't zero, fire stops once it has
+// passed and marks r as Truncated. It counts the timers that were fired,
+// dropped and coalesced in r.
+func (n *Namespace) fire(ctx context.Context, keys []string, deadline time.Time, r *PollResult) error {
+	if n.PollMaxFire > 0 && len(keys) > n.PollMaxFire {
+		keys = keys[:n.PollMaxFire]
+	}
+	coalesce, record := "", ""
+	if n.CoalesceQueue && n.Queue == QueueList {
+		coalesce = "1"
+	}
+	if n.RecordFireTime {
+		record = "1"
+	}
+	companions := n.companionKeys()
+	for i, k := range keys {
+		if i > 0 && !deadline.IsZero() && time.Now().After(deadline) {
+			r.Truncated = true
+			return nil
+		}
+		if n.PollYield > 0 && i > 0 && i%pollYieldBatch == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(n.PollYield):
 			}
-			err = n.client.r.Del(ctx, s2).Err()
-			if err != nil {
+		}
+		if n.BeforeFire != nil && !n.BeforeFire(k) {
+			err := n.pollOp(ctx, func(ctx context.Context) error {
+				_, err := n.cancel(ctx, []string{k}, "vetoed by BeforeFire")
 				return err
-			}
-		} else {
-			// If all the timers are expired, then the diff is going to
-			// just be any keys in s1.
-			keys, err = n.client.r.SMembers(ctx, s1).Result()
+			})
 			if err != nil {
 				return err
 			}
+			r.Dropped++
+			continue
 		}
-
-		for _, k := range keys {
-			err = n.client.r.LPush(ctx, n.queueKey(), k).Err()
+		queue := n.queueKeyFor(k)
+		if n.Queue == QueueStream {
+			queue = n.streamKey()
+		}
+		var token int64
+		err := n.pollOp(ctx, func(ctx context.Context) (err error) {
+			token, err = n.runScript(ctx, fireScript,
+				append([]string{queue, n.registeredKeyFor(k), n.tokenKey(), n.tokensKey(), n.firesKey(), n.guardsKey()}, companions[:]...),
+				k, n.firedChannel(), n.Queue.String(), n.StreamMaxLen, int64(fireRateRetention/time.Second), coalesce, record).Int64()
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		switch {
+		case token == 0:
+			r.Dropped++
+			err = n.releaseDependents(ctx, []string{k}, false)
 			if err != nil {
 				return err
 			}
-			err = n.client.r.SRem(ctx, s1, k).Err()
-			if err != nil {
-				return err
+		case token < 0:
+			r.Coalesced++
+		default:
+			r.Fired++
+			if n.OnFire != nil {
+				n.OnFire(k)
 			}
 		}
-		return nil
-	})
+	}
+	return nil
+}
+
+// pollOp runs a single Redis operation for Poll, bounded by PollTimeout if
+// it's set.
+func (n *Namespace) pollOp(ctx context.Context, fn func(ctx context.Context) error) error {
+	if n.PollTimeout <= 0 {
+		return fn(ctx)
+	}
+	opCtx, cancel := context.WithTimeout(ctx, n.PollTimeout)
+	defer cancel()
+	err := fn(opCtx)
+	if err != nil && opCtx.Err() != nil && ctx.Err() == nil {
+		return fmt.Errorf("%w: %v", ErrPollTimeout, err)
+	}
 	return err
 }
 
 // Next returns the next timer that needs to be fired. If there are no timers
-// available, this will block until one is available.
+// available, this will block until one is available. Timers are returned in
+// the order they fired, unless the namespace's Order is set to OrderLIFO.
+// A timer that was taken from the queue is returned even if there's an error,
+// such as ErrPayloadMissing, so that it isn't lost.
 func (n *Namespace) Next(ctx context.Context) (key string, err error) {
-	_, err = n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
-		var keys []string
-		keys, err = n.client.r.BRPop(ctx, 0, n.queueKey()).Result()
-		if err != nil {
-			return err
-		}
-		if len(keys) != 2 {
-			return fmt.Errorf("expected 2 keys, got %d", len(keys))
-		}
-		key = keys[1]
-		return err
-	})
+	t, err := n.NextTimer(ctx)
+	return t.Key, err
+}
+
+// NextTimer is like Next, but returns a FiredTimer with additional details
+// about the timer that fired. If the timer is recurring, it is re-armed before
+// it is returned.
+//
+// NextTimer returns ErrQueueMismatch if the namespace uses QueueStream, use
+// NextGroup instead.
+func (n *Namespace) NextTimer(ctx context.Context) (FiredTimer, error) {
+	return n.nextTimer(ctx, 0)
+}
+
+// NextWithTimeout is like Next, but waits at most timeout for a timer to be
+// available, and returns ErrNoTimers if there isn't one. Redis only supports
+// timeouts with second precision, so the timeout is rounded up to a whole
+// number of seconds.
+func (n *Namespace) NextWithTimeout(ctx context.Context, timeout time.Duration) (string, error) {
+	t, err := n.NextTimerWithTimeout(ctx, timeout)
+	return t.Key, err
+}
+
+// NextTimerWithTimeout is like NextTimer, but waits at most timeout for a timer
+// to be available, and returns ErrNoTimers if there isn't one.
+func (n *Namespace) NextTimerWithTimeout(ctx context.Context, timeout time.Duration) (FiredTimer, error) {
+	if timeout <= 0 {
+		return FiredTimer{}, ErrInvalidDuration
+	}
+	return n.nextTimer(ctx, timeout)
+}
+
+// nextTimer pops the next timer from the queue, waiting at most timeout for
+// one to be available, forever if timeout is zero, or not at all if it's
+// negative.
+func (n *Namespace) nextTimer(ctx context.Context, timeout time.Duration) (FiredTimer, error) {
+	return n.nextTimerFrom(ctx, n.queueKeys(), timeout)
+}
+
+// nextTimerFrom is like nextTimer, but pops from the given queues.
+func (n *Namespace) nextTimerFrom(ctx context.Context, queues []string, timeout time.Duration) (t FiredTimer, err error) {
+	if n.Queue != QueueList {
+		return t, ErrQueueMismatch
+	}
+	stop := n.present(ctx)
+	keys, err := n.pop(ctx, queues, timeout)
+	stop()
+	if err == redis.Nil {
+		return t, ErrNoTimers
+	}
+	if err != nil {
+		return t, readOnlyError(err)
+	}
+	if len(keys) != 2 {
+		return t, fmt.Errorf("expected 2 keys, got %d", len(keys))
+	}
+	t.Key = keys[1]
+	t.Shard = n.queueShard(keys[0])
+	err = n.rearm(ctx, &t)
 	return
 }
 
+// Peek returns the key of the timer that the next call to Next will return,
+// without removing it from the queue, and ErrNoTimers if the queue is empty.
+// Another consumer may pop the timer before this one gets to it. With
+// QueueShards, it returns the timer that Next would pop from the first shard
+// that isn't empty.
+func (n *Namespace) Peek(ctx context.Context) (string, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList {
+		return "", ErrQueueMismatch
+	}
+	for _, queue := range n.queueKeys() {
+		key, err := n.client.r.LIndex(ctx, queue, n.peekIndex()).Result()
+		if err != redis.Nil {
+			return key, err
+		}
+	}
+	return "", ErrNoTimers
+}
+
+// requeueScript pushes a timer back onto the KEYS[1] queue with the ARGV[2]
+// command, and gives it a new fencing token. If ARGV[3] isn't empty, the timer
+// keeps it as its delivery ID, along with the ARGV[4] time that it fired.
+var requeueScript = newNamespaceScript(`
+local token = redis.call('INCR', KEYS[2])
+if ARGV[3] == '' then
+	redis.call('HSET', KEYS[3], ARGV[1], token)
+else
+	redis.call('HSET', KEYS[3], ARGV[1], token .. ':' .. ARGV[4] .. ':' .. ARGV[3])
+end
+redis.call(ARGV[2], KEYS[1], ARGV[1])
+touch(KEYS[1])
+return token
+`)
+
+// Requeue pushes a timer that was returned by Next back onto the queue, so it
+// can be retried by a consumer that failed to handle it. The timer goes to the
+// back of the queue, so with OrderFIFO it's returned after any timers that fired
+// in the meantime, and with OrderLIFO after every timer that's waiting. Either
+// way it may be handled out of order. Requeue doesn't check whether the timer
+// was already handled, so requeueing a timer more than once leads to duplicate
+// processing. The requeued timer is given a new fencing token and delivery
+// ID, as if it fired again.
+//
+// Requeue returns ErrQueueMismatch if the namespace uses QueueStream, where
+// unacknowledged timers are recovered with ClaimPending instead.
+func (n *Namespace) Requeue(ctx context.Context, key string) error {
+	return n.requeue(ctx, FiredTimer{Key: key})
+}
+
+// RequeueTimer is like Requeue, but the timer keeps its delivery ID and the
+// time that it fired, so that it's recognized as the same delivery when it's
+// consumed again, see FiredTimer.DeliveryID. It still gets a new fencing token.
+func (n *Namespace) RequeueTimer(ctx context.Context, t FiredTimer) error {
+	return n.requeue(ctx, t)
+}
+
+// requeue implements Requeue and RequeueTimer.
+func (n *Namespace) requeue(ctx context.Context, t FiredTimer) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList {
+		return ErrQueueMismatch
+	}
+	key := t.Key
+	if t.Warning > 0 {
+		key = warningKey(t.Key, t.Warning)
+	}
+	var fired int64
+	if !t.FiredAt.IsZero() {
+		fired = t.FiredAt.UnixMilli()
+	}
+	return n.runScript(ctx, requeueScript,
+		[]string{n.queueKeyFor(key), n.tokenKey(), n.tokensKey()},
+		key, n.pushBackCommand(), t.DeliveryID, fired).Err()
+}
+
+// createScript arms a timer and registers it in a single atomic step. Both
+// types are checked before anything is written, so that a failure can't leave
+// an armed timer that isn't registered (and would therefore never fire). The
+// optional parameters ARGV[3] to ARGV[5] and ARGV[7] are empty when they're not
+// set, see createParams, ARGV[8] is "1" if the timer has the value in ARGV[9],
+// ARGV[10], ARGV[11], ARGV[13] and ARGV[14] are empty or the timer's warnings,
+// fields, metadata and guard key, ARGV[12] is "1" if the timer's value is
+// resolved lazily, and ARGV[6] is "1" to leave the timer's recurrence, label
+// and the rest alone. Otherwise the number of times the timer was replayed from
+// the KEYS[13] hash is reset, and timers with a value are marked in the
+// KEYS[14] hash. If ARGV[15] is "1", nothing is written if the timer already
+// exists, and if ARGV[16] isn't empty, nothing is written unless it's the
+// timer's version in the KEYS[15] hash. The version is incremented whenever the
+// timer is written. Returns 1 if the timer was created, 0 if it wasn't because
+// of deduplication, -1 if it wasn't because it already exists, and -2 if it
+// wasn't because of a version conflict.
+var createScript = newNamespaceScript(`
+local registered = redis.call('TYPE', KEYS[2]).ok
+local recurring = redis.call('TYPE', KEYS[3]).ok
+if (registered ~= 'none' and registered ~= rep) or (recurring ~= 'none' and recurring ~= 'hash') then
+	return redis.error_reply('WRONGTYPE Operation against a key holding the wrong kind of value')
+end
+local err = checkMeta()
+if err then
+	return redis.error_reply(err)
+end
+if ARGV[15] == '1' and (redis.call('EXISTS', KEYS[1]) == 1 or isRegistered(KEYS[2], ARGV[1])) then
+	return -1
+end
+if ARGV[16] ~= '' and tonumber(redis.call('HGET', KEYS[15], ARGV[1]) or 0) ~= tonumber(ARGV[16]) then
+	return -2
+end
+if ARGV[4] ~= '' and not redis.call('SET', KEYS[4], '', 'PX', ARGV[4], 'NX') then
+	return 0
+end
+writeMeta()
+redis.call('HINCRBY', KEYS[15], ARGV[1], 1)
+redis.call('SET', KEYS[1], '', 'PX', ARGV[2])
+register(KEYS[2], ARGV[1], ARGV[2])
+if ARGV[6] == '1' then
+	return 1
+end
+if ARGV[3] == '' then
+	redis.call('HDEL', KEYS[3], ARGV[1])
+else
+	redis.call('HSET', KEYS[3], ARGV[1], ARGV[3])
+end
+if ARGV[5] == '' then
+	redis.call('HDEL', KEYS[5], ARGV[1])
+else
+	redis.call('HSET', KEYS[5], ARGV[1], ARGV[5])
+end
+untag(KEYS[6], ARGV[1])
+if ARGV[7] == '' then
+	redis.call('HDEL', KEYS[6], ARGV[1])
+else
+	redis.call('HSET', KEYS[6], ARGV[1], ARGV[7])
+	for _, index in ipairs(cjson.decode(ARGV[7]).indexes) do
+		redis.call('SADD', index, ARGV[1])
+	end
+end
+if ARGV[8] == '' then
+	redis.call('HDEL', KEYS[7], ARGV[1])
+else
+	redis.call('HSET', KEYS[7], ARGV[1], ARGV[9])
+end
+if ARGV[10] == '' then
+	redis.call('HDEL', KEYS[8], ARGV[1])
+else
+	redis.call('HSET', KEYS[8], ARGV[1], ARGV[10])
+end
+if ARGV[11] == '' then
+	redis.call('HDEL', KEYS[9], ARGV[1])
+else
+	redis.call('HSET', KEYS[9], ARGV[1], ARGV[11])
+end
+if ARGV[12] == '' then
+	redis.call('HDEL', KEYS[10], ARGV[1])
+else
+	redis.call('HSET', KEYS[10], ARGV[1], ARGV[12])
+end
+if ARGV[13] == '' then
+	redis.call('HDEL', KEYS[11], ARGV[1])
+else
+	redis.call('HSET', KEYS[11], ARGV[1], ARGV[13])
+end
+if ARGV[14] == '' then
+	redis.call('HDEL', KEYS[12], ARGV[1])
+else
+	redis.call('HSET', KEYS[12], ARGV[1], ARGV[14])
+end
+redis.call('HDEL', KEYS[13], ARGV[1])
+if ARGV[8] == '' then
+	redis.call('HDEL', KEYS[14], ARGV[1])
+else
+	redis.call('HSET', KEYS[14], ARGV[1], '1')
+end
+return 1
+`)
+
+// createParams are the optional parameters for creating a timer.
+type createParams struct {
+	// interval makes the timer recurring, see CreateRecurring.
+	interval time.Duration
+	// cron makes the timer recurring on the schedule of a cron expression,
+	// see CreateCron.
+	cron string
+	// dedupWindow suppresses the timer if it was created within the window,
+	// see CreateDedup.
+	dedupWindow time.Duration
+	// label is stored alongside the timer, see CreateOptions.
+	label string
+	// tags index the timer so that it can be found with FindByTag.
+	tags map[string]string
+	// value is stored alongside the timer, see CreateOptions.
+	value []byte
+	// warnings are the offsets of the timer's warnings, see CreateOptions.
+	warnings []time.Duration
+	// fields are stored alongside the timer, see CreateOptions.
+	fields map[string][]byte
+	// lazyValue marks the timer's value as resolved by ResolveValue.
+	lazyValue bool
+	// metadata is stored alongside the timer, see CreateOptions.
+	metadata Metadata
+	// guardKey is the key that must exist for the timer to fire, see
+	// CreateOptions.
+	guardKey string
+	// onlyIfAbsent leaves an existing timer alone, see CreateOptions.
+	onlyIfAbsent bool
+	// expectedVersion is the version that the timer must have for it to be
+	// written, or 0 to write it regardless, see CreateOptions.
+	expectedVersion int64
+	// unscaled creates the timer without applying TimeScale, for timers that
+	// were already armed or follow the wall clock, or whose duration and
+	// params have already been scaled, see scaleParams.
+	unscaled bool
+	// keep leaves the timer's recurrence, label, tags, value, warnings, fields,
+	// lazy value, metadata and guard key alone instead of replacing them, see
+	// Upsert.
+	keep bool
+}
+
+// CreateOptions are the options for creating a timer with CreateWithOptions.
+type CreateOptions struct {
+	// Label is a human-readable description of the timer, such as "send renewal
+	// reminder for order 123", that's returned by Describe and List for
+	// operator tooling. It's removed once the timer is consumed or cancelled.
+	Label string
+
+	// Tags are attributes of the timer, such as {"user": "42"}, that it's
+	// indexed by so that it can be found with FindByTag. Like the label, the
+	// tags are removed once the timer is consumed or cancelled.
+	Tags map[string]string
+
+	// Value is an arbitrary payload that's delivered along with the timer when
+	// it's consumed, see NextWithValue. A nil Value means the timer has none.
+	// It's removed once a one-shot timer is consumed or cancelled.
+	Value []byte
+
+	// Warnings are offsets before the timer fires at which warning timers
+	// fire, such as 5 minutes before an SLA is breached. Warnings are consumed
+	// like any other timer, with the timer's key and FiredTimer.Warning set to
+	// their offset. Cancelling the timer also cancels its warnings, but
+	// creating it again doesn't, so cancel it first when rescheduling. The
+	// offsets must be positive and shorter than the timer's duration, and the
+	// warnings are created right after the timer, not atomically with it.
+	Warnings []time.Duration
+
+	// Fields are named payloads that are delivered together when the timer is
+	// consumed, for when several distinct actions are due at the same time,
+	// instead of creating a timer for each of them. Like the value, they're
+	// removed once a one-shot timer is consumed or cancelled.
+	Fields map[string][]byte
+
+	// LazyValue marks the timer's value as too expensive to compute up front.
+	// Nothing is stored for it, instead the value is computed by the
+	// namespace's ResolveValue when the timer is consumed, and Value is
+	// ignored. See FiredTimer.LazyValue.
+	LazyValue bool
+
+	// Metadata is context from the code that created the timer, such as a
+	// trace context or a request ID, that's delivered along with the timer
+	// when it's consumed, so that the code handling it can carry on where the
+	// creator left off. Metadata is a TextMapCarrier for OpenTelemetry's
+	// propagators, so a trace context can be injected into it here and
+	// extracted from FiredTimer.Metadata. Like the fields, it's removed once a
+	// one-shot timer is consumed or cancelled.
+	Metadata Metadata
+
+	// GuardKey is a Redis key that must still exist when the timer fires, such
+	// as the key of the entity that the timer acts on. Poll checks the key
+	// atomically as it fires the timer, and if it doesn't exist, the timer is
+	// dropped and forgotten instead, as if it were cancelled, so timers for
+	// deleted entities never reach a consumer. The guard key is owned by the
+	// application and isn't under the client's prefix, rimer never writes it.
+	// A recurring timer is dropped for good the first time its guard key is
+	// missing. Guard keys are accessed without being declared to Redis, so
+	// they aren't supported on Redis Cluster.
+	GuardKey string
+
+	// OnlyIfAbsent only creates the timer if there isn't already a timer with
+	// the same key that's armed or waiting to be polled, and returns
+	// ErrTimerExists otherwise, leaving the existing timer untouched.
+	OnlyIfAbsent bool
+
+	// ExpectedVersion only writes the timer if it currently has this version,
+	// and returns ErrVersionConflict otherwise, leaving it untouched. Every
+	// timer has a version that starts at 1 when it's created and is
+	// incremented every time it's written, by any of the Create methods,
+	// Upsert or Heartbeat, and that's returned by Describe. Producers that
+	// read a timer and then reschedule it based on what they read can pass the
+	// version they read, so that they don't clobber a change that another
+	// producer made in the meantime. A timer that doesn't exist, or that has
+	// been consumed or cancelled, has no version, so the check always fails
+	// for it. Zero, the default, skips the check, see OnlyIfAbsent for creating
+	// timers that don't exist yet.
+	ExpectedVersion int64
+}
+
+// CreateWithOptions is like Create, but with additional options.
+func (n *Namespace) CreateWithOptions(ctx context.Context, key string, duration time.Duration, opts CreateOptions) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	_, err := n.create(ctx, key, duration, opts.params())
+	return err
+}
+
+// params returns the createParams for the options.
+func (o CreateOptions) params() createParams {
+	return createParams{
+		label:           o.Label,
+		tags:            o.Tags,
+		value:           o.Value,
+		warnings:        o.Warnings,
+		fields:          o.Fields,
+		lazyValue:       o.LazyValue,
+		metadata:        o.Metadata,
+		guardKey:        o.GuardKey,
+		onlyIfAbsent:    o.OnlyIfAbsent,
+		expectedVersion: o.ExpectedVersion,
+	}
+}
+
 // Create creates a new timer with the given key and duration. The key can be
 // any string, and the duration is the amount of time before the timer expires.
 // Once the duration has passed, the timer will be returned by Next(...) assuming
-// that someone Polls.
+// that someone Polls. Creating the timer is atomic, either it's armed and
+// registered, or nothing is written at all. If a timer with the same key already
+// exists, it's replaced by a one-shot timer with the new duration.
+//
+// Timers have millisecond precision, the duration is rounded up to the next
+// whole millisecond and must be positive, otherwise ErrInvalidDuration is
+// returned.
 func (n *Namespace) Create(ctx context.Context, key string, duration time.Duration) error {
-	_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
-		err := p.Set(ctx, n.timerKey(key), []byte{}, duration).Err()
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	_, err := n.create(ctx, key, duration, createParams{})
+	return err
+}
+
+// Upsert makes sure that the timer with the given key fires duration from now,
+// whether or not it already exists. Unlike Create, a recurring timer stays
+// recurring and the timer keeps its label and tags, only the time until it next
+// fires changes. Like Create, this is atomic, and timers that have expired but
+// haven't been polled yet are re-armed instead of firing.
+func (n *Namespace) Upsert(ctx context.Context, key string, duration time.Duration) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	_, err := n.create(ctx, key, duration, createParams{keep: true})
+	return err
+}
+
+// Heartbeat renews a lease: it makes the timer with the given key fire lease
+// from now, creating it if it doesn't exist, so that the timer only fires once
+// the heartbeats stop. A process that calls Heartbeat more often than lease
+// signals that it's alive, and the timer firing signals that it died. Like
+// Upsert, this is a single atomic step that keeps the timer's label, tags and
+// other options, and a lease that has expired but hasn't been polled yet is
+// renewed instead of firing.
+func (n *Namespace) Heartbeat(ctx context.Context, key string, lease time.Duration) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	_, err := n.create(ctx, key, lease, createParams{keep: true})
+	return err
+}
+
+// create runs createScript for the given timer, and returns whether the timer
+// was created.
+func (n *Namespace) create(ctx context.Context, key string, duration time.Duration, p createParams) (bool, error) {
+	if !p.unscaled {
+		duration = n.scaleDuration(duration)
+		p = n.scaleParams(p)
+	}
+	duration, err := n.limitDuration(duration)
+	if err != nil {
+		return false, err
+	}
+	keys, args, err := n.createArgs(key, duration, p)
+	if err != nil {
+		return false, err
+	}
+	res, err := n.runScript(ctx, createScript, keys, args...).Int64()
+	if err != nil {
+		return false, err
+	}
+	created, err := createResult(key, res)
+	if err != nil || !created {
+		return created, err
+	}
+	return true, n.created(ctx, key, duration, p)
+}
+
+// createArgs returns the keys and arguments that createScript is run with for
+// the given timer.
+func (n *Namespace) createArgs(key string, duration time.Duration, p createParams) ([]string, []any, error) {
+	ms, err := durationMs(duration)
+	if err != nil {
+		return nil, nil, err
+	}
+	args := []any{key, ms, "", "", p.label, "", "", "", "", "", "", "", "", p.guardKey, "", ""}
+	if len(p.tags) > 0 {
+		if args[6], err = n.encodeTags(p.tags); err != nil {
+			return nil, nil, err
+		}
+	}
+	if p.lazyValue {
+		args[11] = "1"
+	} else if p.value != nil {
+		args[7] = "1"
+		if args[8], err = n.client.encodeValue(p.value); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(p.warnings) > 0 {
+		if args[9], err = encodeWarnings(duration, p.warnings); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(p.fields) > 0 {
+		b, err := json.Marshal(p.fields)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
-		err = p.SAdd(ctx, n.registeredKey(), key).Err()
+		args[10] = string(b)
+	}
+	if len(p.metadata) > 0 {
+		b, err := json.Marshal(p.metadata)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
+		args[12] = string(b)
+	}
+	if p.keep {
+		args[5] = "1"
+	}
+	if p.onlyIfAbsent {
+		args[14] = "1"
+	}
+	if p.expectedVersion > 0 {
+		args[15] = p.expectedVersion
+	}
+	if p.interval > 0 {
+		if args[2], err = durationMs(p.interval); err != nil {
+			return nil, nil, err
+		}
+	} else if p.cron != "" {
+		args[2] = cronPrefix + p.cron
+	}
+	if p.dedupWindow > 0 {
+		if args[3], err = durationMs(p.dedupWindow); err != nil {
+			return nil, nil, err
+		}
+	}
+	keys := []string{n.timerKey(key), n.registeredKeyFor(key), n.recurringKey(), n.dedupKey(key), n.labelsKey(), n.tagsKey(), n.valuesKey(), n.warningsKey(), n.fieldsKey(), n.lazyKey(), n.metadataKey(), n.guardsKey(), n.replaysKey(), n.valuedKey(), n.versionsKey()}
+	return keys, args, nil
+}
+
+// createResult interprets the result of createScript for the given timer, and
+// returns whether the timer was created.
+func createResult(key string, res int64) (bool, error) {
+	switch res {
+	case -1:
+		return false, fmt.Errorf("%w: %s", ErrTimerExists, key)
+	case -2:
+		return false, fmt.Errorf("%w: %s", ErrVersionConflict, key)
+	}
+	return res == 1, nil
+}
+
+// created runs the steps that follow creating a timer with createScript:
+// calling OnCreate and creating the timer's warnings.
+func (n *Namespace) created(ctx context.Context, key string, duration time.Duration, p createParams) error {
+	if n.OnCreate != nil {
+		n.OnCreate(key, duration)
+	}
+	if len(p.warnings) == 0 {
 		return nil
-	})
-	return err
+	}
+	return n.createWarnings(ctx, key, duration, p.warnings)
+}
+
+// createManyScript arms and registers many one-shot timers at once. KEYS starts
+// with the hashes returned by createManyHashes and the versions hash, followed
+// by the timer key and the registered key of each timer, and ARGV holds the key
+// and the duration in milliseconds of each timer. Like createScript, the types
+// are checked before anything is written, and the version of each timer is
+// incremented.
+var createManyScript = newNamespaceScript(`
+local count = nargs / 2
+local hashes = #KEYS - 2 - 2 * count
+local versions = hashes + 1
+local recurring = redis.call('TYPE', KEYS[1]).ok
+if recurring ~= 'none' and recurring ~= 'hash' then
+	return redis.error_reply('WRONGTYPE Operation against a key holding the wrong kind of value')
+end
+for i = 1, count do
+	local registered = redis.call('TYPE', KEYS[versions + 2 * i]).ok
+	if registered ~= 'none' and registered ~= rep then
+		return redis.error_reply('WRONGTYPE Operation against a key holding the wrong kind of value')
+	end
+end
+local err = checkMeta()
+if err then
+	return redis.error_reply(err)
+end
+writeMeta()
+for i = 1, count do
+	local member, ms = ARGV[2 * i - 1], ARGV[2 * i]
+	redis.call('SET', KEYS[versions + 2 * i - 1], '', 'PX', ms)
+	register(KEYS[versions + 2 * i], member, ms)
+	untag(KEYS[hashes], member)
+	for j = 1, hashes do
+		redis.call('HDEL', KEYS[j], member)
+	end
+	redis.call('HINCRBY', KEYS[versions], member, 1)
+end
+return count
+`)
+
+// createManyHashes returns the companion hashes that CreateMany removes the
+// timers from, which are all of them except for the fencing tokens of timers
+// that are still waiting in the queue and the versions, which are incremented
+// instead. The recurring hash comes first and the tags hash comes last.
+func (n *Namespace) createManyHashes() []string {
+	companions := n.companionKeys()
+	hashes := make([]string, 0, len(companions)-2)
+	for _, k := range companions {
+		if k != n.tokensKey() && k != n.versionsKey() {
+			hashes = append(hashes, k)
+		}
+	}
+	return hashes
+}
+
+// createManyBatchSize is the number of timers that CreateMany creates with each
+// script call.
+const createManyBatchSize = 500
+
+// CreateMany creates many timers at once, as if Create was called for each of
+// them. The timers are created in batches with a single round trip to Redis per
+// batch, which makes this much faster than calling Create in a loop when
+// creating a large number of timers. Each batch is created atomically, but if
+// Redis returns an error, the batches before it may have already been created.
+func (n *Namespace) CreateMany(ctx context.Context, timers map[string]time.Duration) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	return n.createMany(ctx, timers, false, n.OnCreate)
+}
+
+// createMany implements CreateMany, calling onCreate for each timer that was
+// created if it's not nil. The durations are scaled with TimeScale unless
+// unscaled is set.
+func (n *Namespace) createMany(ctx context.Context, timers map[string]time.Duration, unscaled bool, onCreate func(key string, duration time.Duration)) error {
+	limit := func(d time.Duration) (time.Duration, error) {
+		if !unscaled {
+			d = n.scaleDuration(d)
+		}
+		return n.limitDuration(d)
+	}
+	size := createManyBatchSize
+	if len(timers) < size {
+		size = len(timers)
+	}
+	keys := make([]string, 0, companionKeyCount+2*size)
+	args := make([]any, 0, 2*size)
+	flush := func() error {
+		if len(args) == 0 {
+			return nil
+		}
+		err := n.runScript(ctx, createManyScript, keys, args...).Err()
+		if err == nil && onCreate != nil {
+			for i := 0; i < len(args); i += 2 {
+				key := args[i].(string)
+				duration, _ := limit(timers[key])
+				onCreate(key, duration)
+			}
+		}
+		keys, args = keys[:0], args[:0]
+		return err
+	}
+	for _, duration := range timers {
+		if duration <= 0 {
+			return ErrInvalidDuration
+		}
+		if _, err := limit(duration); err != nil {
+			return err
+		}
+	}
+	for key, duration := range timers {
+		duration, _ = limit(duration)
+		ms, _ := durationMs(duration)
+		if len(keys) == 0 {
+			keys = append(append(keys, n.createManyHashes()...), n.versionsKey())
+		}
+		keys = append(keys, n.timerKey(key), n.registeredKeyFor(key))
+		args = append(args, key, ms)
+		if len(args) == 2*createManyBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// bumpEarlierScript shortens a timer's TTL to ARGV[1] milliseconds, but only if
+// the timer would otherwise fire later than that. Timers that don't exist are
+// created, unless they've already expired and are just waiting to be polled.
+var bumpEarlierScript = newNamespaceScript(`
+local pttl = redis.call('PTTL', KEYS[1])
+if pttl == -2 then
+	if isRegistered(KEYS[2], ARGV[2]) then
+		return 0
+	end
+elseif pttl >= 0 and pttl <= tonumber(ARGV[1]) then
+	return 0
+end
+local err = checkMeta()
+if err then
+	return redis.error_reply(err)
+end
+writeMeta()
+redis.call('SET', KEYS[1], '', 'PX', ARGV[1])
+register(KEYS[2], ARGV[2], ARGV[1])
+return 1
+`)
+
+// BumpEarlier makes sure that the timer with the given key fires at most duration
+// from now. If the timer is set to fire later than that, it is shortened, and if
+// it's set to fire sooner, it's left alone. If the timer doesn't exist, it is
+// created. This is the classic debounce primitive, and the comparison happens
+// atomically in Redis so concurrent callers can't race each other.
+func (n *Namespace) BumpEarlier(ctx context.Context, key string, duration time.Duration) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	ms, err := durationMs(duration)
+	if err != nil {
+		return err
+	}
+	return n.runScript(ctx, bumpEarlierScript,
+		[]string{n.timerKey(key), n.registeredKeyFor(key)},
+		ms, key).Err()
+}
+
+// extendLaterScript lengthens a timer's TTL to ARGV[1] milliseconds, but only
+// if the timer would otherwise fire sooner than that. Timers that don't exist
+// are created.
+var extendLaterScript = newNamespaceScript(`
+local pttl = redis.call('PTTL', KEYS[1])
+if pttl == -1 or pttl >= tonumber(ARGV[1]) then
+	return 0
+end
+local err = checkMeta()
+if err then
+	return redis.error_reply(err)
+end
+writeMeta()
+redis.call('SET', KEYS[1], '', 'PX', ARGV[1])
+register(KEYS[2], ARGV[2], ARGV[1])
+return 1
+`)
+
+// ExtendLater makes sure that the timer with the given key fires no sooner than
+// duration from now. If the timer is set to fire sooner than that, it is extended,
+// and if it's set to fire later, it's left alone. This is the dual of BumpEarlier
+// and is useful for keepalives and sliding windows.
+//
+// If the timer doesn't exist, it is created with the given duration. This includes
+// timers that have already expired but haven't been picked up by Poll yet, these
+// are re-armed and won't fire.
+func (n *Namespace) ExtendLater(ctx context.Context, key string, duration time.Duration) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	ms, err := durationMs(duration)
+	if err != nil {
+		return err
+	}
+	return n.runScript(ctx, extendLaterScript,
+		[]string{n.timerKey(key), n.registeredKeyFor(key)},
+		ms, key).Err()
+}
+
+// key returns the redis key made up of the given parts in this namespace.
+func (n *Namespace) key(parts ...string) string {
+	return n.client.KeyBuilder.Join(append([]string{n.client.Prefix, n.name}, parts...)...)
 }
 
 // timerKey returns the redis key for a specific timer.
 func (n *Namespace) timerKey(id string) string {
-	return n.client.Prefix + ":" + n.name + ":timer:" + id
+	return n.key("timer", id)
+}
+
+// dedupKey returns the redis key for the deduplication marker of a specific timer.
+func (n *Namespace) dedupKey(id string) string {
+	return n.key("dedup", id)
 }
 
 // queueKey returns the redis key for the queue of timers in this namespace.
 func (n *Namespace) queueKey() string {
-	return n.client.Prefix + ":" + n.name + ":queue"
+	return n.key("queue")
+}
+
+// queueKeyFor returns the redis key for the queue that the given timer is
+// pushed onto, see QueueShards.
+func (n *Namespace) queueKeyFor(key string) string {
+	if n.QueueShards <= 1 {
+		return n.queueKey()
+	}
+	return n.queueShardKey(int(crc32.ChecksumIEEE([]byte(key)) % uint32(n.QueueShards)))
+}
+
+// queueShardKey returns the redis key for one of the queues when using
+// QueueShards.
+func (n *Namespace) queueShardKey(shard int) string {
+	return n.key("queue", strconv.Itoa(shard))
+}
+
+// queueKeys returns all the redis keys that timers are queued in.
+func (n *Namespace) queueKeys() []string {
+	if n.QueueShards <= 1 {
+		return []string{n.queueKey()}
+	}
+	keys := make([]string, n.QueueShards)
+	for i := range keys {
+		keys[i] = n.queueShardKey(i)
+	}
+	return keys
+}
+
+// queueShard returns the shard of the queue with the given key, see
+// QueueShards, or zero if the queue isn't sharded.
+func (n *Namespace) queueShard(queue string) int {
+	if n.QueueShards <= 1 {
+		return 0
+	}
+	for i, k := range n.queueKeys() {
+		if k == queue {
+			return i
+		}
+	}
+	return 0
+}
+
+// streamKey returns the redis key for the stream of fired timers when using
+// QueueStream.
+func (n *Namespace) streamKey() string {
+	return n.key("stream")
+}
+
+// claimedKey returns the redis key for the sorted set of claim tokens scored
+// by their deadlines, see Claim.
+func (n *Namespace) claimedKey() string {
+	return n.key("claimed")
+}
+
+// claimsKey returns the redis key for the hash of the timers that each claim
+// token was issued for, see Claim.
+func (n *Namespace) claimsKey() string {
+	return n.key("claims")
+}
+
+// cancelledKey returns the redis key for the hash of the tombstones of recently
+// cancelled timers, see CancelRetention.
+func (n *Namespace) cancelledKey() string {
+	return n.key("cancelled")
+}
+
+// cancellationsKey returns the redis key for the sorted set of recently
+// cancelled timers, scored by the time that they were cancelled.
+func (n *Namespace) cancellationsKey() string {
+	return n.key("cancellations")
+}
+
+// firesKey returns the redis key for the ring buffer of the number of timers
+// fired in each second in this namespace, see FireRate. It's a hash of the
+// unix second modulo fireRateRetention to "<second>:<count>", so a slot that
+// holds an older second than the one being counted starts again from zero.
+func (n *Namespace) firesKey() string {
+	return n.key("fires")
+}
+
+// firedChannel returns the redis pub/sub channel that fired timers are published
+// to in this namespace.
+func (n *Namespace) firedChannel() string {
+	return n.key("fired")
+}
+
+// afterKey returns the redis key for the hash of dependency records, see
+// CreateAfter.
+func (n *Namespace) afterKey() string {
+	return n.key("after")
+}
+
+// labelsKey returns the redis key for the hash of timer labels in this namespace.
+func (n *Namespace) labelsKey() string {
+	return n.key("labels")
+}
+
+// valuesKey returns the redis key for the hash of timer values in this
+// namespace.
+func (n *Namespace) valuesKey() string {
+	return n.key("values")
+}
+
+// warningsKey returns the redis key for the hash of the offsets of each timer's
+// warnings in this namespace.
+func (n *Namespace) warningsKey() string {
+	return n.key("warnings")
+}
+
+// fieldsKey returns the redis key for the hash of timer fields in this
+// namespace.
+func (n *Namespace) fieldsKey() string {
+	return n.key("fields")
+}
+
+// lazyKey returns the redis key for the hash of the timers whose values are
+// resolved lazily in this namespace.
+func (n *Namespace) lazyKey() string {
+	return n.key("lazy")
+}
+
+// metadataKey returns the redis key for the hash of the metadata of the timers
+// in this namespace.
+func (n *Namespace) metadataKey() string {
+	return n.key("metadata")
+}
+
+// guardsKey returns the redis key for the hash of the guard keys of the timers
+// in this namespace.
+func (n *Namespace) guardsKey() string {
+	return n.key("guards")
+}
+
+// replaysKey returns the redis key for the hash of the number of times that
+// the timers in this namespace were replayed from the dead letter queue.
+func (n *Namespace) replaysKey() string {
+	return n.key("replays")
+}
+
+// deadLettersKey returns the redis key for the dead letter queue of this
+// namespace.
+func (n *Namespace) deadLettersKey() string {
+	return n.key("deadletters")
+}
+
+// deadLetteredKey returns the redis key for the hash of the number of times
+// that each timer in the dead letter queue was already replayed.
+func (n *Namespace) deadLetteredKey() string {
+	return n.key("deadlettered")
+}
+
+// valuedKey returns the redis key for the hash of the timers that were created
+// with a value in this namespace.
+func (n *Namespace) valuedKey() string {
+	return n.key("valued")
+}
+
+// versionsKey returns the redis key for the hash of the version of each timer
+// in this namespace, see CreateOptions.ExpectedVersion.
+func (n *Namespace) versionsKey() string {
+	return n.key("versions")
+}
+
+// tagsKey returns the redis key for the hash of the tag indexes that each timer
+// is in, see CreateOptions.
+func (n *Namespace) tagsKey() string {
+	return n.key("tags")
+}
+
+// indexKey returns the redis key for the set of timers with the given tag value.
+func (n *Namespace) indexKey(tag, value string) string {
+	return n.key("idx", tag, value)
+}
+
+// tokenKey returns the redis key for the counter that fencing tokens are taken
+// from in this namespace.
+func (n *Namespace) tokenKey() string {
+	return n.key("token")
+}
+
+// tokensKey returns the redis key for the hash of the fencing tokens of the
+// timers waiting in the queue in this namespace.
+func (n *Namespace) tokensKey() string {
+	return n.key("tokens")
+}
+
+// recurringKey returns the redis key for the hash of recurring timer intervals
+// in this namespace.
+func (n *Namespace) recurringKey() string {
+	return n.key("recurring")
 }
 
 // registeredKey returns the redis key for the set of registered timers in this namespace.
 func (n *Namespace) registeredKey() string {
-	return n.client.Prefix + ":" + n.name + ":registered"
+	return n.key("registered")
+}
+
+// pollLockKey returns the redis key of the lock that pollers take when using
+// PollLock.
+func (n *Namespace) pollLockKey() string {
+	return n.key("_poll_lock")
+}
+
+// metaKey returns the redis key for the hash of metadata about this namespace.
+func (n *Namespace) metaKey() string {
+	return n.key("_meta")
+}
+
+// registeredBucketKey returns the redis key for one of the sets of registered
+// timers when using RepresentationBucketed.
+func (n *Namespace) registeredBucketKey(bucket int) string {
+	return n.key("registered", strconv.Itoa(bucket))
+}
+
+// scheduledKey returns the redis key for the sorted set of registered timers
+// when using RepresentationSortedSet.
+func (n *Namespace) scheduledKey() string {
+	return n.key("scheduled")
 }
 
 func (n *Namespace) registeredTempKey() string {
-	return n.client.Prefix + ":" + n.name + ":_registered_" + strconv.Itoa(int(time.Now().UnixNano()))
+	suffix := strconv.FormatInt(n.client.random(), 10)
+	if n.client.PollerID != "" {
+		suffix = n.client.PollerID + "_" + suffix
+	}
+	return n.key("_registered_" + suffix)
 }
 
 func (n *Namespace) registeredTempPrefix() string {
-	return n.client.Prefix + ":" + n.name + ":_registered_*"
+	return n.key("_registered_*")
 }
 
-func (n *Namespace) getRegisteredTempSet(ctx context.Context, p redis.Pipeliner) (string, error) {
+func (n *Namespace) getRegisteredTempSet(ctx context.Context) (string, error) {
 	s2 := n.registeredTempKey()
-	exists, err := p.Exists(ctx, s2).Result()
+	exists, err := n.client.r.Exists(ctx, s2).Result()
 	if err != nil {
 		return "", err
 	}
@@ -186,6 +1995,62 @@ func (n *Namespace) getRegisteredTempSet(ctx context.Context, p redis.Pipeliner)
 	return s2, nil
 }
 
+// scaleDuration applies TimeScale to the duration of a timer that's being
+// created.
+func (n *Namespace) scaleDuration(d time.Duration) time.Duration {
+	if n.TimeScale <= 0 || n.TimeScale == 1 || d <= 0 {
+		return d
+	}
+	d = time.Duration(float64(d) * n.TimeScale)
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// scaleParams applies TimeScale to the interval and warning offsets of a timer
+// that's being created, along with its duration, and marks the params as
+// unscaled so that they aren't scaled again.
+func (n *Namespace) scaleParams(p createParams) createParams {
+	if p.unscaled {
+		return p
+	}
+	p.interval = n.scaleDuration(p.interval)
+	if len(p.warnings) > 0 {
+		warnings := make([]time.Duration, len(p.warnings))
+		for i, offset := range p.warnings {
+			warnings[i] = n.scaleDuration(offset)
+		}
+		p.warnings = warnings
+	}
+	p.unscaled = true
+	return p
+}
+
+// limitDuration applies MaxDuration to the duration of a timer that's being
+// created, returning the duration to create it with.
+func (n *Namespace) limitDuration(d time.Duration) (time.Duration, error) {
+	if n.MaxDuration <= 0 || d <= n.MaxDuration {
+		return d, nil
+	}
+	if n.ClampDuration {
+		return n.MaxDuration, nil
+	}
+	return 0, fmt.Errorf("%w: %s is longer than %s", ErrDurationTooLong, d, n.MaxDuration)
+}
+
+// durationMs converts a duration to milliseconds for use with PX and PEXPIRE.
+// Redis expires keys with millisecond precision, so durations are rounded up
+// to the next whole millisecond, which guarantees that a timer never fires
+// before its duration has passed. Durations that aren't positive are rejected
+// with ErrInvalidDuration.
+func durationMs(d time.Duration) (int64, error) {
+	if d <= 0 {
+		return 0, ErrInvalidDuration
+	}
+	return int64((d + time.Millisecond - 1) / time.Millisecond), nil
+}
+
 // toAny converts a slice of T into a slice of any. SAdd accepts a slice of interface{},
 // but passing .SAdd(..., strings...) doesn't work with the type system, so we
 // need to convert it to a slice of interface{} first.
@@ -1,14 +1,25 @@
 package rimer
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -48,6 +59,3542 @@ func TestClient(t *testing.T) {
 	ns.assertRegisteredLen(t, 0)
 }
 
+func TestClient_NextAny(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	foo, bar := c.Namespace("foo"), c.Namespace("bar")
+	assert.NoError(t, bar.Create(ctx, "baz", time.Second))
+	time.Sleep(2 * time.Second)
+	assert.NoError(t, foo.Poll(ctx))
+	assert.NoError(t, bar.Poll(ctx))
+
+	ns, key, err := c.NextAny(ctx, "foo", "bar")
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", ns)
+	assert.Equal(t, "baz", key)
+}
+
+func TestNamespace_Representation(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	for _, r := range []Representation{RepresentationSet, RepresentationSortedSet, RepresentationBucketed} {
+		t.Run(r.String(), func(t *testing.T) {
+			ns := c.Namespace(r.String())
+			ns.Representation = r
+
+			assert.NoError(t, ns.Create(ctx, "foo", time.Second))
+			assert.NoError(t, ns.Create(ctx, "bar", time.Hour))
+			ns.assertRegisteredLen(t, 2)
+
+			// Only the expired timer is fired
+			time.Sleep(2 * time.Second)
+			assert.NoError(t, ns.Poll(ctx))
+			ns.assertQueueLen(t, 1)
+			ns.assertRegisteredLen(t, 1)
+
+			key, err := ns.Next(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, "foo", key)
+		})
+	}
+}
+
+func TestNamespace_ActiveConsumers(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	count, err := ns.ActiveConsumers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Each client counts once, however often it takes timers and however many
+	// Namespace values it uses
+	for i := 0; i < 2; i++ {
+		_, err = c.Namespace("foo").NextWithTimeout(ctx, time.Second)
+		assert.ErrorIs(t, err, ErrNoTimers)
+	}
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	key, err := c.Namespace("foo").Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", key)
+	count, err = ns.ActiveConsumers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Consumers using another client count separately, including NextAny
+	other := New(c.r)
+	done := make(chan error)
+	go func() {
+		_, _, err := other.NextAny(ctx, "foo", "bar")
+		done <- err
+	}()
+	assert.Eventually(t, func() bool {
+		count, err := ns.ActiveConsumers(ctx)
+		return err == nil && count == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	bar := c.Namespace("bar")
+	assert.NoError(t, bar.Create(ctx, "bar", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, bar.Poll(ctx))
+	assert.NoError(t, <-done)
+	count, err = c.Namespace("bar").ActiveConsumers(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// Consumers in other namespaces aren't counted
+	count, err = c.Namespace("baz").ActiveConsumers(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Consumers that stop are forgotten once their presence expires
+	ttl, err := c.r.PTTL(context.Background(), ns.consumerKey(c.consumer())).Result()
+	assert.NoError(t, err)
+	assert.Greater(t, ttl, time.Duration(0))
+	assert.LessOrEqual(t, ttl, consumerPresenceTTL)
+
+	// Consumers that only use the non-blocking variants count too
+	for _, consume := range []func(ns *Namespace) error{
+		func(ns *Namespace) error {
+			_, err := ns.NextWithValue(ctx)
+			return err
+		},
+		func(ns *Namespace) error {
+			_, _, err := ns.Claim(ctx, time.Minute)
+			return err
+		},
+	} {
+		qux := New(c.r).Namespace("qux")
+		before, err := qux.ActiveConsumers(ctx)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, consume(qux), ErrNoTimers)
+		count, err = qux.ActiveConsumers(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, before+1, count)
+	}
+}
+
+func TestNamespace_PollUntil(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	_, err := ns.PollUntil(ctx, time.Now())
+	assert.ErrorIs(t, err, ErrRepresentation)
+
+	ns.Representation = RepresentationSortedSet
+	start := time.Now()
+	assert.NoError(t, ns.Create(ctx, "foo", 500*time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "bar", 1500*time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "baz", time.Hour))
+	time.Sleep(2 * time.Second)
+
+	// Only the timer that was due before the cutoff is fired
+	fired, err := ns.PollUntil(ctx, start.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fired)
+	ns.assertQueueLen(t, 1)
+	ns.assertRegisteredLen(t, 2)
+
+	// Timers that haven't expired are left alone even if they're before the
+	// cutoff
+	fired, err = ns.PollUntil(ctx, start.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fired)
+	ns.assertRegisteredLen(t, 1)
+
+	for _, want := range []string{"foo", "bar"} {
+		key, err := ns.Next(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, want, key)
+	}
+}
+
+func TestNamespace_ExpiryOrder(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.Representation = RepresentationSortedSet
+
+	// The keys sort in the opposite order to their expiry, so they can't be
+	// queued in expiry order by accident
+	assert.NoError(t, ns.Create(ctx, "c", 100*time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "b", 200*time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "a", 300*time.Millisecond))
+	time.Sleep(500 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	for _, want := range []string{"c", "b", "a"} {
+		key, err := ns.NextWithTimeout(ctx, time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, want, key)
+	}
+}
+
+func TestNamespace_MigrateRepresentation(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	assert.NoError(t, ns.Create(ctx, "foo", time.Second))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Hour))
+
+	for _, r := range []Representation{RepresentationBucketed, RepresentationSortedSet, RepresentationSet} {
+		assert.NoError(t, ns.MigrateRepresentation(ctx, r))
+		assert.Equal(t, r, ns.Representation)
+		ns.assertRegisteredLen(t, 2)
+	}
+
+	time.Sleep(2 * time.Second)
+	assert.NoError(t, ns.Poll(ctx))
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", key)
+	ns.assertRegisteredLen(t, 1)
+}
+
+func TestNamespace_PollLock(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	first, second := c.Namespace("foo"), c.Namespace("foo")
+	first.PollLock, second.PollLock = 500*time.Millisecond, 500*time.Millisecond
+	assert.NoError(t, first.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	// Only one of the pollers gets to poll until the lock expires
+	r, err := first.PollWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Fired)
+	assert.ErrorIs(t, second.Poll(ctx), ErrPollSkipped)
+	assert.ErrorIs(t, first.Poll(ctx), ErrPollSkipped)
+
+	time.Sleep(time.Second)
+	assert.NoError(t, second.Poll(ctx))
+
+	// Skipped polls aren't errors for PollLoop
+	var errs []error
+	second.OnPollError = func(err error) {
+		errs = append(errs, err)
+	}
+	loopCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, second.PollLoop(loopCtx, 10*time.Millisecond), context.DeadlineExceeded)
+	assert.Empty(t, errs)
+}
+
+func TestNamespace_PollTimeout(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	assert.NoError(t, ns.Create(ctx, "foo", time.Second))
+	time.Sleep(2 * time.Second)
+
+	ns.PollTimeout = time.Nanosecond
+	assert.ErrorIs(t, ns.Poll(ctx), ErrPollTimeout)
+	ns.assertQueueLen(t, 0)
+	ns.assertRegisteredLen(t, 1)
+
+	ns.PollTimeout = time.Second
+	assert.NoError(t, ns.Poll(ctx))
+	ns.assertQueueLen(t, 1)
+	ns.assertRegisteredLen(t, 0)
+}
+
+func TestNamespace_SchemaVersion(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	version, err := ns.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Minute))
+	version, err = ns.SchemaVersion(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, LatestSchemaVersion, version)
+
+	// Clients must agree on the representation
+	other := c.Namespace("foo")
+	other.Representation = RepresentationSortedSet
+	assert.ErrorIs(t, other.Create(ctx, "bar", time.Minute), ErrRepresentation)
+	assert.ErrorIs(t, other.Poll(ctx), ErrRepresentation)
+
+	// Namespaces written by newer versions are refused
+	require.NoError(t, c.r.HSet(ctx, ns.metaKey(), "version", LatestSchemaVersion+1).Err())
+	assert.ErrorIs(t, ns.Create(ctx, "bar", time.Minute), ErrSchemaVersion)
+	assert.ErrorIs(t, ns.Poll(ctx), ErrSchemaVersion)
+}
+
+func TestClient_Migrate(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	assert.NoError(t, ns.Create(ctx, "a:b", time.Second))
+	assert.NoError(t, ns.Create(ctx, "c", time.Hour))
+
+	to := Separator("/")
+	assert.NoError(t, c.Migrate(ctx, c.KeyBuilder, to))
+	ns.assertKeysLen(t, 0)
+	c.KeyBuilder = to
+	ns.assertKeysLen(t, 2)
+	ns.assertRegisteredLen(t, 2)
+	ns.assertTTLBetween(t, "c", 59*time.Minute, time.Hour)
+
+	// Migrating again is a no-op
+	assert.NoError(t, c.Migrate(ctx, Separator(":"), to))
+	ns.assertKeysLen(t, 2)
+
+	time.Sleep(2 * time.Second)
+	assert.NoError(t, ns.Poll(ctx))
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "a:b", key)
+}
+
+func TestClient_Migrate_Parts(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.QueueShards = 2
+	opts := CreateOptions{Tags: map[string]string{"user": "42:1"}}
+	assert.NoError(t, ns.CreateWithOptions(ctx, "a:b", time.Millisecond, opts))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "c", time.Hour, opts))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	queue := ns.queueKeyFor("a:b")
+	shard := ns.queueShard(queue)
+
+	to := Separator("/")
+	assert.NoError(t, c.Migrate(ctx, c.KeyBuilder, to))
+	c.KeyBuilder = to
+
+	// Every part of the index and shard keys uses the new separator, while
+	// the tag value keeps the old one
+	for _, key := range []string{"timers/foo/idx/user/42:1", fmt.Sprintf("timers/foo/queue/%d", shard), "timers/foo/fires"} {
+		n, err := c.r.Exists(ctx, key).Result()
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), n, key)
+	}
+	keys, err := ns.FindByTag(ctx, "user", "42:1")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a:b", "c"}, keys)
+	timer, err := ns.NextShard(ctx, shard)
+	assert.NoError(t, err)
+	assert.Equal(t, "a:b", timer.Key)
+}
+
+func TestNamespace_CreateDedup(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	created, err := ns.CreateDedup(ctx, "foo", time.Second, 3*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, created)
+
+	// Duplicates are suppressed even after the first timer fires
+	time.Sleep(2 * time.Second)
+	assert.NoError(t, ns.Poll(ctx))
+	created, err = ns.CreateDedup(ctx, "foo", time.Second, 3*time.Second)
+	assert.NoError(t, err)
+	assert.False(t, created)
+	ns.assertKeysLen(t, 0)
+
+	// Once the window has passed the timer can be created again
+	time.Sleep(2 * time.Second)
+	created, err = ns.CreateDedup(ctx, "foo", time.Second, 3*time.Second)
+	assert.NoError(t, err)
+	assert.True(t, created)
+	ns.assertKeysLen(t, 1)
+}
+
+func TestNamespace_pollDelay(t *testing.T) {
+	ns := New(nil).Namespace("foo")
+	ns.PollBackoffMax = 5 * time.Second
+	errFailed := fmt.Errorf("failed")
+
+	delay := time.Second
+	for _, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		delay = ns.pollDelay(delay, time.Second, errFailed)
+		assert.Equal(t, expected, delay)
+	}
+	assert.Equal(t, time.Second, ns.pollDelay(delay, time.Second, nil))
+}
+
+func TestNamespace_BumpEarlier(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// Bumping a timer that doesn't exist creates it
+	assert.NoError(t, ns.BumpEarlier(ctx, "foo", time.Hour))
+	ns.assertKeysLen(t, 1)
+	ns.assertRegisteredLen(t, 1)
+
+	// Bumping to a later time leaves the timer alone
+	assert.NoError(t, ns.BumpEarlier(ctx, "foo", 2*time.Hour))
+	ns.assertTTLBetween(t, "foo", 59*time.Minute, time.Hour)
+
+	// Bumping to an earlier time shortens the timer
+	assert.NoError(t, ns.BumpEarlier(ctx, "foo", time.Minute))
+	ns.assertTTLBetween(t, "foo", 59*time.Second, time.Minute)
+	ns.assertRegisteredLen(t, 1)
+}
+
+func TestNamespace_ExtendLater(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// Extending a timer that doesn't exist creates it
+	assert.NoError(t, ns.ExtendLater(ctx, "foo", time.Minute))
+	ns.assertKeysLen(t, 1)
+	ns.assertRegisteredLen(t, 1)
+
+	// Extending to an earlier time leaves the timer alone
+	assert.NoError(t, ns.ExtendLater(ctx, "foo", time.Second))
+	ns.assertTTLBetween(t, "foo", 59*time.Second, time.Minute)
+
+	// Extending to a later time lengthens the timer
+	assert.NoError(t, ns.ExtendLater(ctx, "foo", time.Hour))
+	ns.assertTTLBetween(t, "foo", 59*time.Minute, time.Hour)
+	ns.assertRegisteredLen(t, 1)
+}
+
+func TestNamespace_OnFire(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	var fired []string
+	ns.OnFire = func(key string) {
+		// The timer is already queued when the callback runs
+		ns.assertQueueLen(t, len(fired)+1)
+		fired = append(fired, key)
+	}
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Hour))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	assert.Equal(t, []string{"foo"}, fired)
+}
+
+func TestNamespace_QueueStream(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.Queue = QueueStream
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	ns.assertQueueLen(t, 0)
+
+	_, err := ns.Next(ctx)
+	assert.ErrorIs(t, err, ErrQueueMismatch)
+
+	// Each consumer in a group gets a different timer
+	t1, err := ns.NextGroup(ctx, "workers", "a")
+	require.NoError(t, err)
+	t2, err := ns.NextGroup(ctx, "workers", "b")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo", "bar"}, []string{t1.Key, t2.Key})
+	assert.NotEmpty(t, t1.ID)
+
+	// Every group gets every timer
+	t3, err := ns.NextGroup(ctx, "audit", "a")
+	require.NoError(t, err)
+	assert.Equal(t, t1, t3)
+
+	// Unacknowledged timers can be claimed by another consumer
+	assert.NoError(t, ns.Ack(ctx, "workers", t1.ID))
+	time.Sleep(10 * time.Millisecond)
+	claimed, err := ns.ClaimPending(ctx, "workers", "c", 5*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, []FiredTimer{t2}, claimed)
+	assert.NoError(t, ns.Ack(ctx, "workers", t2.ID))
+	claimed, err = ns.ClaimPending(ctx, "workers", "c", 0)
+	require.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+func TestNamespace_DeliveryID(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.RecordFireTime = true
+	fire := func(key string) {
+		t.Helper()
+		assert.NoError(t, ns.Create(ctx, key, time.Millisecond))
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, ns.Poll(ctx))
+	}
+
+	// Requeued timers keep their delivery ID with RequeueTimer, but get a
+	// new fencing token
+	fire("foo")
+	first, err := ns.NextTimerWithTimeout(ctx, time.Second)
+	require.NoError(t, err)
+	assert.NotEmpty(t, first.DeliveryID)
+	assert.NoError(t, ns.RequeueTimer(ctx, first))
+	again, err := ns.NextTimerWithTimeout(ctx, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, first.DeliveryID, again.DeliveryID)
+	assert.Equal(t, first.FiredAt, again.FiredAt)
+	assert.Greater(t, again.Token, first.Token)
+
+	assert.NoError(t, ns.Requeue(ctx, "foo"))
+	other, err := ns.NextTimerWithTimeout(ctx, time.Second)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.DeliveryID, other.DeliveryID)
+
+	// Every fire is a new delivery
+	fire("foo")
+	other, err = ns.NextTimerWithTimeout(ctx, time.Second)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.DeliveryID, other.DeliveryID)
+
+	// Timers whose claims expire keep their delivery ID
+	fire("bar")
+	entry, err := c.r.HGet(ctx, ns.tokensKey(), "bar").Result()
+	require.NoError(t, err)
+	delivery, _, _ := strings.Cut(entry, ":")
+	_, _, err = ns.Claim(ctx, time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	n, err := ns.Reclaim(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	reclaimed, err := ns.NextTimerWithTimeout(ctx, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", reclaimed.Key)
+	assert.Equal(t, delivery, reclaimed.DeliveryID)
+	assert.NotEqual(t, delivery, strconv.FormatInt(reclaimed.Token, 10))
+	assert.False(t, reclaimed.FiredAt.IsZero())
+
+	// Stream entries are delivered again as they are
+	ns = c.Namespace("bar")
+	ns.Queue = QueueStream
+	fire("foo")
+	streamed, err := ns.NextGroup(ctx, "group", "consumer")
+	require.NoError(t, err)
+	assert.Equal(t, streamed.ID, streamed.DeliveryID)
+}
+
+func TestNamespace_Requeue(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", key)
+
+	// A requeued timer goes behind the timers that fired in the meantime
+	assert.NoError(t, ns.Poll(ctx))
+	assert.NoError(t, ns.Requeue(ctx, "foo"))
+	ns.assertQueueLen(t, 2)
+	key, err = ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", key)
+	key, err = ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", key)
+}
+
+func TestNamespace_Cancel(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateRecurring(ctx, "foo", time.Hour))
+	cancelled, err := ns.Cancel(ctx, "foo")
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+	ns.assertKeysLen(t, 0)
+	ns.assertRegisteredLen(t, 0)
+
+	cancelled, err = ns.Cancel(ctx, "foo")
+	assert.NoError(t, err)
+	assert.False(t, cancelled)
+}
+
+func TestNamespace_Cancel_Cleanup(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// A queued timer with a token, and a pending recurring timer with a label
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	assert.NoError(t, ns.CreateRecurring(ctx, "bar", time.Hour))
+	assert.NoError(t, ns.Upsert(ctx, "bar", time.Hour))
+	assert.NoError(t, c.r.HSet(ctx, ns.labelsKey(), "bar", "bar").Err())
+
+	for _, key := range []string{"foo", "bar"} {
+		cancelled, err := ns.Cancel(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, cancelled)
+		ns.assertForgotten(t, key)
+	}
+}
+
+func TestNamespace_Next_Cleanup(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{Label: "foo"}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	_, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	ns.assertForgotten(t, "foo")
+}
+
+func TestNamespace_CancelSoonest(t *testing.T) {
+	for _, r := range []Representation{RepresentationSet, RepresentationSortedSet, RepresentationBucketed} {
+		t.Run(r.String(), func(t *testing.T) {
+			c, stop := client(t)
+			defer stop()
+
+			ns := c.Namespace("foo")
+			ns.Representation = r
+
+			cancelled, err := ns.CancelSoonest(ctx, 2)
+			assert.NoError(t, err)
+			assert.Empty(t, cancelled)
+
+			assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+			assert.NoError(t, ns.Create(ctx, "bar", time.Minute))
+			assert.NoError(t, ns.Create(ctx, "baz", 10*time.Minute))
+			assert.NoError(t, ns.Create(ctx, "qux", 2*time.Hour))
+
+			cancelled, err = ns.CancelSoonest(ctx, 2)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"bar", "baz"}, cancelled)
+			ns.assertRegisteredLen(t, 2)
+
+			cancelled, err = ns.CancelSoonest(ctx, 5)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"foo", "qux"}, cancelled)
+			ns.assertRegisteredLen(t, 0)
+		})
+	}
+}
+
+func TestNamespace_CancelMatching(t *testing.T) {
+	for _, r := range []Representation{RepresentationSet, RepresentationSortedSet, RepresentationBucketed} {
+		t.Run(r.String(), func(t *testing.T) {
+			c, stop := client(t)
+			defer stop()
+
+			ns := c.Namespace("foo")
+			ns.Representation = r
+
+			// One tenant's timers are queued, expired but not polled, and pending
+			assert.NoError(t, ns.Create(ctx, "a:queued", time.Millisecond))
+			time.Sleep(10 * time.Millisecond)
+			assert.NoError(t, ns.Poll(ctx))
+			assert.NoError(t, ns.Create(ctx, "a:expired", time.Millisecond))
+			for i := 0; i < 2*cancelBatchSize; i++ {
+				assert.NoError(t, ns.Create(ctx, fmt.Sprintf("a:%d", i), time.Hour))
+			}
+			assert.NoError(t, ns.Create(ctx, "b:pending", time.Hour))
+			time.Sleep(10 * time.Millisecond)
+
+			cancelled, err := ns.CancelMatching(ctx, "a:*")
+			assert.NoError(t, err)
+			assert.Equal(t, 2*cancelBatchSize+2, cancelled)
+			ns.assertKeysLen(t, 1)
+			ns.assertRegisteredLen(t, 1)
+			ns.assertQueueLen(t, 0)
+		})
+	}
+}
+
+func TestNamespace_globMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"*", "", true},
+		{"a:*", "a:1", true},
+		{"a:*", "b:1", false},
+		{"*:1", "a/b:1", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[ab]:1", "b:1", true},
+		{"[^ab]:1", "b:1", false},
+		{"[a-c]", "b", true},
+		{"[a-c]", "d", false},
+		{"a\\*", "a*", true},
+		{"a\\*", "ab", false},
+		{"a**b", "axyb", true},
+	} {
+		assert.Equal(t, tt.match, globMatch(tt.pattern, tt.s), "%q %q", tt.pattern, tt.s)
+	}
+}
+
+func TestNamespace_CreateMany(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	timers := make(map[string]time.Duration)
+	for i := 0; i < createManyBatchSize+1; i++ {
+		timers[strconv.Itoa(i)] = time.Hour
+	}
+	timers["soon"] = time.Millisecond
+	assert.NoError(t, ns.CreateMany(ctx, timers))
+	ns.assertRegisteredLen(t, len(timers))
+	ns.assertTTLBetween(t, "0", 59*time.Minute, time.Hour)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "soon", key)
+
+	assert.ErrorIs(t, ns.CreateMany(ctx, map[string]time.Duration{"bar": 0}), ErrInvalidDuration)
+}
+
+func TestNamespace_Order(t *testing.T) {
+	for order, want := range map[Order][]string{
+		OrderFIFO: {"foo", "bar", "baz"},
+		OrderLIFO: {"bar", "foo", "baz"},
+	} {
+		t.Run(order.String(), func(t *testing.T) {
+			c, stop := client(t)
+			defer stop()
+
+			ns := c.Namespace("foo")
+			ns.Order = order
+
+			assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+			time.Sleep(10 * time.Millisecond)
+			assert.NoError(t, ns.Poll(ctx))
+			assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+			time.Sleep(10 * time.Millisecond)
+			assert.NoError(t, ns.Poll(ctx))
+
+			var got []string
+			key, err := ns.Next(ctx)
+			assert.NoError(t, err)
+			got = append(got, key)
+
+			// Requeued timers go to the back of the queue in either order
+			assert.NoError(t, ns.Requeue(ctx, "baz"))
+			for i := 0; i < 2; i++ {
+				key, err = ns.Next(ctx)
+				assert.NoError(t, err)
+				got = append(got, key)
+			}
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestNamespace_Upsert(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// Upserting a timer that doesn't exist creates it
+	assert.NoError(t, ns.Upsert(ctx, "foo", time.Minute))
+	ns.assertRegisteredLen(t, 1)
+	ns.assertTTLBetween(t, "foo", 59*time.Second, time.Minute)
+
+	// Upserting an existing timer overwrites its duration either way
+	assert.NoError(t, ns.Upsert(ctx, "foo", time.Hour))
+	ns.assertTTLBetween(t, "foo", 59*time.Minute, time.Hour)
+	assert.NoError(t, ns.Upsert(ctx, "foo", time.Second))
+	ns.assertTTLBetween(t, "foo", 0, time.Second)
+	ns.assertRegisteredLen(t, 1)
+
+	// Recurring timers stay recurring
+	assert.NoError(t, ns.CreateRecurring(ctx, "bar", time.Hour))
+	assert.NoError(t, ns.Upsert(ctx, "bar", time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err := ns.NextTimer(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", timer.Key)
+	assert.True(t, timer.Recurring)
+}
+
+func TestClient_Diagnostics(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	assert.NoError(t, c.Namespace("foo").Create(ctx, "foo", time.Hour))
+	assert.NoError(t, c.r.Set(ctx, "other", "", 0).Err())
+
+	d, err := c.Diagnostics(ctx)
+	require.NoError(t, err)
+	assert.Positive(t, d.PingRTT)
+	// The timer, its registered set, its version and the namespace's metadata
+	assert.Equal(t, int64(4), d.Keys)
+	assert.Equal(t, int64(5), d.DBSize)
+	assert.NotNil(t, d.Pool)
+}
+
+func TestNamespace_FencingToken(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	t1, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	t2, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Positive(t, t1.Token)
+	assert.Greater(t, t2.Token, t1.Token)
+
+	// Requeued timers are delivered with a new token
+	assert.NoError(t, ns.Requeue(ctx, t1.Key))
+	t3, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, t1.Key, t3.Key)
+	assert.Greater(t, t3.Token, t2.Token)
+
+	// Streams carry the token in the entry
+	ns.Queue = QueueStream
+	assert.NoError(t, ns.Create(ctx, "baz", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	t4, err := ns.NextGroup(ctx, "workers", "a")
+	require.NoError(t, err)
+	assert.Greater(t, t4.Token, t3.Token)
+}
+
+func TestClient_NamespaceChecked(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns, err := c.NamespaceChecked("tenant-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "tenant-1", ns.name)
+
+	for _, name := range []string{"", "tenant:1", "tenant*", "tenant?", "tenant[1]", `tenant\1`} {
+		_, err = c.NamespaceChecked(name)
+		assert.ErrorIs(t, err, ErrInvalidNamespace, name)
+	}
+
+	// The separator depends on the key builder
+	c.KeyBuilder = Separator("/")
+	_, err = c.NamespaceChecked("tenant:1")
+	assert.NoError(t, err)
+	_, err = c.NamespaceChecked("tenant/1")
+	assert.ErrorIs(t, err, ErrInvalidNamespace)
+
+	// Separators with glob characters would make scans match other namespaces
+	c.KeyBuilder = Separator("*")
+	_, err = c.NamespaceChecked("tenant")
+	assert.ErrorIs(t, err, ErrInvalidNamespace)
+}
+
+func FuzzNamespaceChecked(f *testing.F) {
+	f.Add(":", "tenant-1", "tenant-2", "foo")
+	f.Add(":", "tenant", "tenant-1", "timer:foo")
+	f.Add(":", "a", "a:timer", "*")
+	f.Add("/", "tenant:1", "tenant", "1:timer:foo")
+	f.Add("::", "a:", "a", ":b")
+	f.Fuzz(func(t *testing.T, sep, a, b, key string) {
+		if sep == "" {
+			t.Skip()
+		}
+		c := New(redis.NewClient(&redis.Options{}))
+		c.KeyBuilder = Separator(sep)
+		na, err := c.NamespaceChecked(a)
+		if err != nil {
+			return
+		}
+
+		// Poll, RebuildRegistered and Migrate get the timer's key back
+		timer := na.timerKey(key)
+		assert.True(t, strings.HasPrefix(timer, na.timerKey("")))
+		assert.Equal(t, key, strings.TrimPrefix(timer, na.timerKey("")))
+		assert.Equal(t, []string{c.Prefix, a, "timer", key}, c.KeyBuilder.Split(timer, 4))
+		assert.True(t, globMatch(na.timerKey("*"), timer))
+
+		// No scan of another namespace matches the keys of this one
+		nb, err := c.NamespaceChecked(b)
+		if err != nil || a == b {
+			return
+		}
+		for _, k := range []string{timer, na.dedupKey(key), na.indexKey("tag", key), na.registeredKey(), na.queueKey(), na.metaKey()} {
+			assert.False(t, globMatch(nb.key("*"), k), "%s matches namespace %s", k, b)
+		}
+	})
+}
+
+func FuzzSeparator(f *testing.F) {
+	f.Add(":", "timers:foo:timer:bar", 4)
+	f.Add("::", "a:::::b::c", 3)
+	f.Fuzz(func(t *testing.T, sep, key string, n int) {
+		if sep == "" || n <= 0 {
+			t.Skip()
+		}
+		s := Separator(sep)
+		parts := s.Split(key, n)
+		assert.LessOrEqual(t, len(parts), n)
+		assert.Equal(t, key, s.Join(parts...))
+	})
+}
+
+func TestNamespace_NextFireTime(t *testing.T) {
+	for _, r := range []Representation{RepresentationSet, RepresentationSortedSet, RepresentationBucketed} {
+		t.Run(r.String(), func(t *testing.T) {
+			c, stop := client(t)
+			defer stop()
+
+			ns := c.Namespace("foo")
+			ns.Representation = r
+
+			_, ok, err := ns.NextFireTime(ctx)
+			assert.NoError(t, err)
+			assert.False(t, ok)
+
+			start := time.Now()
+			assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+			assert.NoError(t, ns.Create(ctx, "bar", time.Minute))
+			next, ok, err := ns.NextFireTime(ctx)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.WithinDuration(t, start.Add(time.Minute), next, time.Second)
+		})
+	}
+}
+
+func TestNamespace_FindByTag(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	user := func(id string) CreateOptions {
+		return CreateOptions{Tags: map[string]string{"user": id}}
+	}
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, user("42")))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Hour, user("42")))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "baz", time.Hour, user("43")))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "qux", time.Hour, user("42")))
+
+	keys, err := ns.FindByTag(ctx, "user", "42")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo", "bar", "qux"}, keys)
+
+	// Consuming, cancelling and replacing timers removes them from the index
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	key, err := ns.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", key)
+	_, err = ns.Cancel(ctx, "bar")
+	assert.NoError(t, err)
+	assert.NoError(t, ns.CreateWithOptions(ctx, "qux", time.Hour, user("43")))
+
+	keys, err = ns.FindByTag(ctx, "user", "42")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	keys, err = ns.FindByTag(ctx, "user", "43")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"baz", "qux"}, keys)
+
+	assert.NoError(t, ns.CreateMany(ctx, map[string]time.Duration{"baz": time.Hour}))
+	keys, err = ns.FindByTag(ctx, "user", "43")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"qux"}, keys)
+
+	// The tags are returned along with the timer when it fires
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, user("44")))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"user": "44"}, timer.Tags)
+}
+
+func TestClient_AliasNamespace(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	c.ResolveAliases = true
+	assert.Equal(t, "old", c.Namespace("old").Name())
+
+	assert.NoError(t, c.AliasNamespace(ctx, "old", "new"))
+	ns := c.Namespace("old")
+	assert.Equal(t, "new", ns.Name())
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	c.Namespace("new").assertRegisteredLen(t, 1)
+
+	// Chains of aliases are followed, but can't form a cycle
+	assert.NoError(t, c.AliasNamespace(ctx, "older", "old"))
+	assert.Equal(t, "new", c.Namespace("older").Name())
+	assert.ErrorIs(t, c.AliasNamespace(ctx, "new", "older"), ErrAliasCycle)
+	assert.ErrorIs(t, c.AliasNamespace(ctx, "new", "new"), ErrAliasCycle)
+
+	// Other clients resolve aliases the first time they see them
+	other := New(c.r)
+	assert.Equal(t, "old", other.Namespace("old").Name())
+	other.ResolveAliases = true
+	assert.Equal(t, "new", other.Namespace("older").Name())
+}
+
+func TestClient_Codec(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	fire := func(values map[string][]byte) {
+		for k, v := range values {
+			assert.NoError(t, ns.CreateWithOptions(ctx, k, time.Millisecond, CreateOptions{Value: v}))
+		}
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, ns.Poll(ctx))
+	}
+	next := func() ([]byte, error) {
+		timer, err := ns.NextTimerWithTimeout(ctx, time.Second)
+		return timer.Value, err
+	}
+
+	// Values that look like encoded ones are stored so they read back as is
+	raw := []byte(codecMagic + "\x04gzip")
+	fire(map[string][]byte{"raw": raw})
+	value, err := next()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, value)
+
+	// Values are compressed in Redis, and values stored before the codec was
+	// set can still be read
+	fire(map[string][]byte{"old": []byte("old")})
+	c.Codec = GzipCodec{}
+	large := bytes.Repeat([]byte("rimer"), 1000)
+	assert.NoError(t, ns.CreateWithOptions(ctx, "large", time.Hour, CreateOptions{Value: large}))
+	stored, err := c.r.HGet(ctx, ns.valuesKey(), "large").Bytes()
+	assert.NoError(t, err)
+	assert.Less(t, len(stored), len(large)/10)
+	value, err = next()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("old"), value)
+
+	// Values stored with a codec the client no longer writes with need it in
+	// Codecs
+	fire(map[string][]byte{"new": []byte("new")})
+	c.Codec = nil
+	_, err = next()
+	assert.ErrorIs(t, err, ErrUnknownCodec)
+	c.Codec = GzipCodec{}
+	fire(map[string][]byte{"new": []byte("new")})
+	c.Codec = nil
+	c.Codecs = []Codec{GzipCodec{}}
+	value, err = next()
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("new"), value)
+}
+
+func TestNamespace_NextWithValue(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	_, err := ns.NextWithValue(ctx)
+	assert.ErrorIs(t, err, ErrNoTimers)
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{Value: []byte("foo")}))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Millisecond, CreateOptions{Value: []byte{}}))
+	assert.NoError(t, ns.Create(ctx, "baz", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	values := make(map[string][]byte)
+	for i := 0; i < 2; i++ {
+		timer, err := ns.NextWithValue(ctx)
+		require.NoError(t, err)
+		values[timer.Key] = timer.Value
+	}
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	values[timer.Key] = timer.Value
+	assert.Equal(t, map[string][]byte{"foo": []byte("foo"), "bar": {}, "baz": nil}, values)
+
+	// The value is removed once the timer is consumed or cancelled
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Hour, CreateOptions{Value: []byte("foo")}))
+	_, err = ns.Cancel(ctx, "foo")
+	assert.NoError(t, err)
+	n, err := c.r.HLen(ctx, ns.valuesKey()).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestNamespace_Warnings(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	warnings := CreateOptions{Warnings: []time.Duration{100 * time.Millisecond, 50 * time.Millisecond}}
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", 150*time.Millisecond, warnings))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Hour, warnings))
+
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	ns.assertQueueLen(t, 3)
+	var fired []time.Duration
+	for i := 0; i < 3; i++ {
+		timer, err := ns.NextTimer(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "foo", timer.Key)
+		fired = append(fired, timer.Warning)
+	}
+	assert.ElementsMatch(t, []time.Duration{100 * time.Millisecond, 50 * time.Millisecond, 0}, fired)
+
+	// Cancelling a timer cancels its warnings
+	cancelled, err := ns.Cancel(ctx, "bar")
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+	ns.assertRegisteredLen(t, 0)
+
+	err = ns.CreateWithOptions(ctx, "foo", time.Second, CreateOptions{Warnings: []time.Duration{time.Second}})
+	assert.ErrorIs(t, err, ErrInvalidDuration)
+}
+
+func TestNamespace_Fields(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	fields := map[string][]byte{"email": []byte("alice@example.com"), "sms": []byte("+15555550100")}
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{Fields: fields}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, fields, timer.Fields)
+
+	// The fields are removed once the timer is consumed or cancelled
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Hour, CreateOptions{Fields: fields}))
+	_, err = ns.Cancel(ctx, "bar")
+	assert.NoError(t, err)
+	n, err := c.r.HLen(ctx, ns.fieldsKey()).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestNamespace_LazyValue(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{LazyValue: true}))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Millisecond, CreateOptions{Value: []byte("bar")}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	// Without a resolver the consumer is told to resolve the value itself
+	var timer FiredTimer
+	var err error
+	for i := 0; i < 2; i++ {
+		timer, err = ns.NextTimer(ctx)
+		require.NoError(t, err)
+		if timer.Key == "foo" {
+			assert.True(t, timer.LazyValue)
+			assert.Nil(t, timer.Value)
+		} else {
+			assert.False(t, timer.LazyValue)
+			assert.Equal(t, []byte("bar"), timer.Value)
+		}
+	}
+
+	ns.ResolveValue = func(ctx context.Context, key string) ([]byte, error) {
+		if key == "fail" {
+			return nil, fmt.Errorf("boom")
+		}
+		return []byte("resolved " + key), nil
+	}
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{LazyValue: true}))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "fail", time.Millisecond, CreateOptions{LazyValue: true}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	for i := 0; i < 2; i++ {
+		timer, err = ns.NextTimer(ctx)
+		assert.True(t, timer.LazyValue)
+		if timer.Key == "fail" {
+			assert.ErrorContains(t, err, "boom")
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("resolved foo"), timer.Value)
+		}
+	}
+	ns.assertForgotten(t, "foo")
+	ns.assertForgotten(t, "fail")
+}
+
+func TestRouter(t *testing.T) {
+	a, stopA := client(t)
+	defer stopA()
+	b, stopB := client(t)
+	defer stopB()
+
+	r := NewRouter(a, b)
+	// The ring doesn't depend on the order of the clients
+	reversed := NewRouter(b, a)
+	used := map[*Client]int{}
+	for i := 0; i < 100; i++ {
+		ns := "ns" + strconv.Itoa(i)
+		assert.Same(t, r.Client(ns), r.Client(ns))
+		assert.Same(t, r.Client(ns), reversed.Client(ns))
+		used[r.Client(ns)]++
+	}
+	assert.Len(t, used, 2)
+
+	ns := r.Namespace("foo")
+	owner, other := r.Client("foo"), a
+	if owner == a {
+		other = b
+	}
+	assert.Same(t, owner, ns.client)
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	n, err := other.r.Exists(ctx, other.Namespace("foo").timerKey("foo")).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, r.Namespace("foo").Poll(ctx))
+	key, err := r.Namespace("foo").NextWithTimeout(ctx, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", key)
+}
+
+func TestNamespace_WaitEmpty(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// An empty namespace returns straight away
+	assert.NoError(t, ns.WaitEmpty(ctx))
+
+	assert.NoError(t, ns.Create(ctx, "foo", 50*time.Millisecond))
+	short, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, ns.WaitEmpty(short), context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		assert.NoError(t, ns.Poll(ctx))
+		_, err := ns.Next(ctx)
+		assert.NoError(t, err)
+	}()
+	assert.NoError(t, ns.WaitEmpty(ctx))
+	ns.assertRegisteredLen(t, 0)
+	ns.assertQueueLen(t, 0)
+
+	ns.Queue = QueueStream
+	assert.ErrorIs(t, ns.WaitEmpty(ctx), ErrQueueMismatch)
+}
+
+func TestNamespace_ServeWebhook(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	var mu sync.Mutex
+	var delivered []WebhookPayload
+	var errs []error
+	attempts := map[string]int{}
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Stops serving once one timer was delivered and the other failed
+	finished := func() {
+		if len(delivered) == 1 && len(errs) == 1 {
+			cancel()
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p WebhookPayload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&p))
+		assert.Equal(t, p.Key+":"+p.DeliveryID, r.Header.Get("Idempotency-Key"))
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[r.URL.Path+p.Key]++
+		// The first attempt fails, and the broken endpoint always does
+		if attempts[r.URL.Path+p.Key] == 1 || r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		delivered = append(delivered, p)
+		finished()
+	}))
+	defer server.Close()
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{Value: []byte("bar")}))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Millisecond, CreateOptions{
+		Metadata: Metadata{WebhookURLMetadataKey: server.URL + "/broken"},
+	}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	done := make(chan error)
+	go func() {
+		done <- ns.ServeWebhook(serveCtx, WebhookOptions{
+			URL:          server.URL + "/hook",
+			MaxInFlight:  2,
+			RetryBackoff: time.Millisecond,
+			OnError: func(err error) {
+				mu.Lock()
+				errs = append(errs, err)
+				finished()
+				mu.Unlock()
+			},
+		})
+	}()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the timers to be delivered")
+	}
+
+	// The timer is delivered on the second attempt
+	require.Len(t, delivered, 1)
+	assert.Equal(t, "foo", delivered[0].Key)
+	assert.Equal(t, []byte("bar"), delivered[0].Value)
+	assert.Equal(t, 2, attempts["/hookfoo"])
+
+	// The timer whose webhook keeps failing is dead lettered
+	assert.Equal(t, 3, attempts["/brokenbar"])
+	require.Len(t, errs, 1)
+	assert.ErrorContains(t, errs[0], "503")
+	dead, err := c.r.LRange(ctx, ns.deadLettersKey(), 0, -1).Result()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bar"}, dead)
+}
+
+func TestNamespace_ServeWebhook_Redelivery(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	keys := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get("Idempotency-Key")
+	}))
+	defer server.Close()
+
+	// A timer that's delivered again keeps its idempotency key, even though
+	// it has a new fencing token
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.NoError(t, ns.RequeueTimer(ctx, timer))
+
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- ns.ServeWebhook(serveCtx, WebhookOptions{URL: server.URL})
+	}()
+	select {
+	case key := <-keys:
+		assert.Equal(t, "foo:"+timer.DeliveryID, key)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the timer to be delivered")
+	}
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestNamespace_Consume(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, ns.Create(ctx, strconv.Itoa(i), time.Millisecond))
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	var mu sync.Mutex
+	var running, peak int
+	handled := map[string]bool{}
+	consumeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- ns.Consume(consumeCtx, ConsumeOptions{MaxInFlight: 3}, func(ctx context.Context, timer FiredTimer) {
+			mu.Lock()
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			handled[timer.Key] = true
+			if len(handled) == 10 {
+				cancel()
+			}
+			mu.Unlock()
+		})
+	}()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the timers to be handled")
+	}
+	assert.Len(t, handled, 10)
+	assert.Equal(t, 3, peak)
+	assert.Zero(t, running)
+
+	ns.Queue = QueueStream
+	assert.ErrorIs(t, ns.Consume(ctx, ConsumeOptions{}, nil), ErrQueueMismatch)
+}
+
+func TestNamespace_Consume_PayloadMissing(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{Value: []byte("foo")}))
+	assert.NoError(t, c.r.HDel(ctx, ns.valuesKey(), "foo").Err())
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	// The timer is still handled, and the error is reported
+	var errs []error
+	handled := make(chan FiredTimer, 1)
+	consumeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- ns.Consume(consumeCtx, ConsumeOptions{OnError: func(err error) { errs = append(errs, err) }}, func(ctx context.Context, timer FiredTimer) {
+			handled <- timer
+			cancel()
+		})
+	}()
+	select {
+	case timer := <-handled:
+		assert.Equal(t, "foo", timer.Key)
+		assert.Nil(t, timer.Value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the timer to be handled")
+	}
+	assert.ErrorIs(t, <-done, context.Canceled)
+	require.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], ErrPayloadMissing)
+
+	// Without OnError, Consume returns the error once the timer is handled
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Millisecond, CreateOptions{Value: []byte("bar")}))
+	assert.NoError(t, c.r.HDel(ctx, ns.valuesKey(), "bar").Err())
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	err := ns.Consume(ctx, ConsumeOptions{}, func(ctx context.Context, timer FiredTimer) {
+		handled <- timer
+	})
+	assert.ErrorIs(t, err, ErrPayloadMissing)
+	assert.Equal(t, "bar", (<-handled).Key)
+}
+
+func TestNamespace_ConsumeBatch(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, ns.Create(ctx, strconv.Itoa(i), time.Millisecond))
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	var batches [][]string
+	consumeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		done <- ns.ConsumeBatch(consumeCtx, 3, func(keys []string) error {
+			batches = append(batches, keys)
+			switch len(batches) {
+			case 1:
+				// Only the failed timer is dead lettered
+				return &BatchError{Failed: keys[1:2], Err: errors.New("boom")}
+			default:
+				cancel()
+				return errors.New("boom")
+			}
+		})
+	}()
+	select {
+	case err := <-done:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the timers to be handled")
+	}
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 3)
+	assert.Len(t, batches[1], 2)
+	ns.assertQueueLen(t, 0)
+
+	dead, err := c.r.LRange(ctx, ns.deadLettersKey(), 0, -1).Result()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, append([]string{batches[0][1]}, batches[1]...), dead)
+
+	ns.Queue = QueueStream
+	assert.ErrorIs(t, ns.ConsumeBatch(ctx, 1, nil), ErrQueueMismatch)
+}
+
+func TestNamespace_ConsumeBatch_PayloadMissing(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// The timer with the missing value is queued second, so it's taken while
+	// the batch is being filled
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Millisecond, CreateOptions{Value: []byte("bar")}))
+	assert.NoError(t, c.r.HDel(ctx, ns.valuesKey(), "bar").Err())
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	// The timer with the missing value is still handled before the error is
+	// returned
+	var batches [][]string
+	err := ns.ConsumeBatch(ctx, 10, func(keys []string) error {
+		batches = append(batches, keys)
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrPayloadMissing)
+	require.Len(t, batches, 1)
+	assert.Equal(t, []string{"foo", "bar"}, batches[0])
+	ns.assertQueueLen(t, 0)
+}
+
+func TestNamespace_Metadata(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	metadata := Metadata{}
+	metadata.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	metadata.Set("request-id", "42")
+	assert.ElementsMatch(t, []string{"traceparent", "request-id"}, metadata.Keys())
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{Metadata: metadata}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, metadata, timer.Metadata)
+	assert.Equal(t, "42", timer.Metadata.Get("request-id"))
+	ns.assertForgotten(t, "foo")
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Hour, CreateOptions{Metadata: metadata}))
+	_, err = ns.Cancel(ctx, "bar")
+	assert.NoError(t, err)
+	ns.assertForgotten(t, "bar")
+}
+
+func TestNamespace_ListCancelled(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// Without a retention, nothing is recorded
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	_, err := ns.Cancel(ctx, "foo")
+	assert.NoError(t, err)
+	cancelled, err := ns.ListCancelled(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, cancelled)
+
+	ns.CancelRetention = 200 * time.Millisecond
+	before := time.Now().Add(-time.Second)
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	ok, err := ns.CancelWithReason(ctx, "foo", "order shipped")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	time.Sleep(5 * time.Millisecond)
+	assert.NoError(t, ns.Create(ctx, "bar", time.Hour))
+	_, err = ns.CancelMatching(ctx, "b*")
+	assert.NoError(t, err)
+	// Timers that don't exist leave no tombstone
+	ok, err = ns.CancelWithReason(ctx, "baz", "nothing to cancel")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	cancelled, err = ns.ListCancelled(ctx)
+	require.NoError(t, err)
+	require.Len(t, cancelled, 2)
+	assert.Equal(t, "bar", cancelled[0].Key)
+	assert.Equal(t, "", cancelled[0].Reason)
+	assert.Equal(t, "foo", cancelled[1].Key)
+	assert.Equal(t, "order shipped", cancelled[1].Reason)
+	assert.True(t, cancelled[1].CancelledAt.After(before))
+
+	// Tombstones are pruned once they're older than the retention
+	time.Sleep(250 * time.Millisecond)
+	assert.NoError(t, ns.Create(ctx, "qux", time.Hour))
+	_, err = ns.Cancel(ctx, "qux")
+	assert.NoError(t, err)
+	cancelled, err = ns.ListCancelled(ctx)
+	require.NoError(t, err)
+	require.Len(t, cancelled, 1)
+	assert.Equal(t, "qux", cancelled[0].Key)
+	n, err := c.r.HLen(ctx, ns.cancelledKey()).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestNamespace_FireRate(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	rate, err := ns.FireRate(ctx, time.Minute)
+	assert.NoError(t, err)
+	assert.Zero(t, rate)
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, ns.Create(ctx, strconv.Itoa(i), time.Millisecond))
+	}
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	rate, err = ns.FireRate(ctx, 10*time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, rate)
+
+	// The fires are counted in a single hash, and slots that were last
+	// counted an hour ago don't count
+	keys, err := c.r.Keys(ctx, ns.key("fires*")).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{ns.firesKey()}, keys)
+	now := time.Now().Unix()
+	for i := int64(1); i <= 5; i++ {
+		slot := strconv.FormatInt((now-i)%3600, 10)
+		assert.NoError(t, c.r.HSet(ctx, ns.firesKey(), slot, fmt.Sprintf("%d:100", now-i-3600)).Err())
+	}
+	rate, err = ns.FireRate(ctx, 10*time.Second)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, rate, 1.0)
+
+	_, err = ns.FireRate(ctx, 0)
+	assert.ErrorIs(t, err, ErrInvalidDuration)
+	_, err = ns.FireRate(ctx, 2*time.Hour)
+	assert.Error(t, err)
+}
+
+func TestNamespace_BeforeFire(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.CancelRetention = time.Hour
+	var asked []string
+	ns.BeforeFire = func(key string) bool {
+		asked = append(asked, key)
+		return key == "foo"
+	}
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+	assert.NoError(t, ns.CreateRecurring(ctx, "baz", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	r, err := ns.PollWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Fired)
+	assert.Equal(t, 2, r.Dropped)
+	assert.ElementsMatch(t, []string{"foo", "bar", "baz"}, asked)
+	ns.assertRegisteredLen(t, 0)
+	ns.assertQueueLen(t, 1)
+
+	// Vetoed timers are removed entirely, even if they're recurring
+	exists, err := c.r.HExists(ctx, ns.recurringKey(), "baz").Result()
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	cancelled, err := ns.ListCancelled(ctx)
+	assert.NoError(t, err)
+	require.Len(t, cancelled, 2)
+	assert.Equal(t, "vetoed by BeforeFire", cancelled[0].Reason)
+}
+
+func TestNamespace_GuardKey(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, c.r.Set(ctx, "order:1", "", 0).Err())
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{GuardKey: "order:1"}))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Millisecond, CreateOptions{
+		GuardKey: "order:2",
+		Label:    "deleted order",
+	}))
+	assert.NoError(t, ns.CreateAfter(ctx, "baz", "bar", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	r, err := ns.PollWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Fired)
+	assert.Equal(t, 1, r.Dropped)
+	ns.assertQueueLen(t, 1)
+	ns.assertForgotten(t, "bar")
+
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", key)
+	ns.assertForgotten(t, "foo")
+
+	// Dependents of a dropped timer are cancelled along with it
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	ns.assertQueueLen(t, 0)
+}
+
+func TestNamespace_CoalesceQueue(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	fire := func() PollResult {
+		assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+		time.Sleep(10 * time.Millisecond)
+		r, err := ns.PollWithResult(ctx)
+		assert.NoError(t, err)
+		return r
+	}
+
+	// By default the timer is queued every time it fires
+	fire()
+	fire()
+	ns.assertQueueLen(t, 2)
+	for i := 0; i < 2; i++ {
+		_, err := ns.Next(ctx)
+		assert.NoError(t, err)
+	}
+
+	ns.CoalesceQueue = true
+	assert.Equal(t, 1, fire().Fired)
+	r := fire()
+	assert.Zero(t, r.Fired)
+	assert.Equal(t, 1, r.Coalesced)
+	ns.assertQueueLen(t, 1)
+	ns.assertRegisteredLen(t, 0)
+	_, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	ns.assertForgotten(t, "foo")
+}
+
+func TestNamespace_Schedule(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	other := c.Namespace("foo")
+
+	// The namespace's own timers aren't touched by Schedule
+	assert.NoError(t, ns.Create(ctx, "timer", time.Millisecond))
+
+	called := make(chan string, 3)
+	_, err := ns.Schedule(ctx, 20*time.Millisecond, func() { called <- "foo" })
+	assert.NoError(t, err)
+	cancel, err := ns.Schedule(ctx, 20*time.Millisecond, func() { called <- "bar" })
+	assert.NoError(t, err)
+	cancel()
+	_, err = other.Schedule(ctx, 200*time.Millisecond, func() { called <- "baz" })
+	assert.NoError(t, err)
+
+	select {
+	case v := <-called:
+		assert.Equal(t, "foo", v)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the scheduled function")
+	}
+	select {
+	case v := <-called:
+		t.Fatalf("function %s was called", v)
+	case <-time.After(100 * time.Millisecond):
+	}
+	select {
+	case v := <-called:
+		assert.Equal(t, "baz", v)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for the other scheduled function")
+	}
+	assert.NotEqual(t, ns.schedule.Name(), other.schedule.Name())
+	ns.schedule.assertRegisteredLen(t, 0)
+	ns.schedule.assertQueueLen(t, 0)
+	ns.assertRegisteredLen(t, 1)
+	ns.assertQueueLen(t, 0)
+
+	// The background consumer stops once there's nothing left to call
+	ns.scheduleMu.Lock()
+	assert.False(t, ns.scheduling)
+	ns.scheduleMu.Unlock()
+
+	_, err = ns.Schedule(ctx, 0, func() {})
+	assert.ErrorIs(t, err, ErrInvalidDuration)
+}
+
+func TestNamespace_ReplayDeadLetters(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.MaxReplays = 2
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, timer.Replays)
+	assert.NoError(t, ns.DeadLetter(ctx, timer))
+
+	// Replaying without a delay fires the timer straight away
+	n, err := ns.ReplayDeadLetters(ctx, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	timer, err = ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", timer.Key)
+	assert.Equal(t, 1, timer.Replays)
+	assert.NoError(t, ns.DeadLetter(ctx, timer))
+
+	// Replaying with a delay re-arms the timer
+	n, err = ns.ReplayDeadLetters(ctx, 10, 20*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	ns.assertQueueLen(t, 0)
+	ns.assertRegisteredLen(t, 1)
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err = ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, timer.Replays)
+	assert.NoError(t, ns.DeadLetter(ctx, timer))
+
+	// Once its replays are used up, the timer stays dead lettered
+	n, err = ns.ReplayDeadLetters(ctx, 10, 0)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+	dead, err := c.r.LRange(ctx, ns.deadLettersKey(), 0, -1).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, dead)
+
+	// max caps the number of timers replayed, oldest first
+	for _, k := range []string{"bar", "baz"} {
+		assert.NoError(t, ns.DeadLetter(ctx, FiredTimer{Key: k}))
+	}
+	n, err = ns.ReplayDeadLetters(ctx, 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", key)
+}
+
+func TestNamespace_IsQueued(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	assertQueued := func(want bool) {
+		t.Helper()
+		queued, err := ns.IsQueued(ctx, "foo")
+		assert.NoError(t, err)
+		assert.Equal(t, want, queued)
+	}
+	assertQueued(false)
+
+	// Armed and expired timers aren't queued until they're polled
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	assertQueued(false)
+	time.Sleep(10 * time.Millisecond)
+	assertQueued(false)
+
+	assert.NoError(t, ns.Poll(ctx))
+	assertQueued(true)
+
+	_, err := ns.NextWithTimeout(ctx, time.Second)
+	assert.NoError(t, err)
+	assertQueued(false)
+
+	ns.Queue = QueueStream
+	_, err = ns.IsQueued(ctx, "foo")
+	assert.ErrorIs(t, err, ErrQueueMismatch)
+}
+
+func TestNamespace_QueueContents(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	keys, err := ns.QueueContents(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	for _, k := range []string{"foo", "bar", "baz"} {
+		assert.NoError(t, ns.Create(ctx, k, time.Millisecond))
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, ns.Poll(ctx))
+	}
+	keys, err = ns.QueueContents(ctx, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar", "baz"}, keys)
+	keys, err = ns.QueueContents(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo", "bar"}, keys)
+	ns.assertQueueLen(t, 3)
+
+	_, err = ns.QueueContents(ctx, 0)
+	assert.Error(t, err)
+}
+
+func TestNamespace_IgnoreMissingValues(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	next := func(key string, opts CreateOptions, lose bool) (FiredTimer, error) {
+		assert.NoError(t, ns.CreateWithOptions(ctx, key, time.Millisecond, opts))
+		if lose {
+			assert.NoError(t, c.r.HDel(ctx, ns.valuesKey(), key).Err())
+		}
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, ns.Poll(ctx))
+		return ns.NextTimer(ctx)
+	}
+
+	// A timer created without a value has none
+	timer, err := next("foo", CreateOptions{}, false)
+	assert.NoError(t, err)
+	assert.Nil(t, timer.Value)
+
+	// An empty value is still a value
+	timer, err = next("foo", CreateOptions{Value: []byte{}}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{}, timer.Value)
+
+	// A value that went missing is reported
+	timer, err = next("foo", CreateOptions{Value: []byte("foo")}, true)
+	assert.ErrorIs(t, err, ErrPayloadMissing)
+	assert.Equal(t, "foo", timer.Key)
+	assert.Nil(t, timer.Value)
+	ns.assertForgotten(t, "foo")
+
+	ns.IgnoreMissingValues = true
+	timer, err = next("foo", CreateOptions{Value: []byte("foo")}, true)
+	assert.NoError(t, err)
+	assert.Nil(t, timer.Value)
+}
+
+func TestNamespace_PayloadMissing_Key(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	lose := func(key string) {
+		assert.NoError(t, ns.CreateWithOptions(ctx, key, time.Millisecond, CreateOptions{Value: []byte(key)}))
+		assert.NoError(t, c.r.HDel(ctx, ns.valuesKey(), key).Err())
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, ns.Poll(ctx))
+	}
+
+	// The methods that only return the key still return it with the error,
+	// as the timer has already been taken from the queue
+	lose("next")
+	key, err := ns.Next(ctx)
+	assert.ErrorIs(t, err, ErrPayloadMissing)
+	assert.Equal(t, "next", key)
+
+	lose("timeout")
+	key, err = ns.NextWithTimeout(ctx, time.Second)
+	assert.ErrorIs(t, err, ErrPayloadMissing)
+	assert.Equal(t, "timeout", key)
+
+	lose("any")
+	name, key, err := c.NextAny(ctx, "bar", "foo")
+	assert.ErrorIs(t, err, ErrPayloadMissing)
+	assert.Equal(t, "foo", name)
+	assert.Equal(t, "any", key)
+
+	lose("claim")
+	key, token, err := ns.Claim(ctx, time.Minute)
+	assert.ErrorIs(t, err, ErrPayloadMissing)
+	assert.Equal(t, "claim", key)
+	assert.NoError(t, ns.Complete(ctx, token))
+	ns.assertQueueLen(t, 0)
+}
+
+func TestNamespace_Heartbeat(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "worker-1", 50*time.Millisecond, CreateOptions{Label: "worker 1 died"}))
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		assert.NoError(t, ns.Heartbeat(ctx, "worker-1", 50*time.Millisecond))
+		assert.NoError(t, ns.Poll(ctx))
+		ns.assertQueueLen(t, 0)
+	}
+
+	// The lease expires once the heartbeats stop
+	time.Sleep(70 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "worker-1", timer.Key)
+	assert.Equal(t, "worker 1 died", timer.Label)
+
+	// A heartbeat creates the lease if it doesn't exist
+	assert.NoError(t, ns.Heartbeat(ctx, "worker-2", time.Hour))
+	ns.assertRegisteredLen(t, 1)
+}
+
+func TestNamespace_RecordFireTime(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.RecordFireTime = true
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	before := time.Now().Add(-time.Second)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", timer.Key)
+	assert.Equal(t, int64(1), timer.Token)
+	assert.True(t, timer.FiredAt.After(before))
+	assert.False(t, timer.FiredAt.After(time.Now()))
+
+	// Timers that fired without the option have no fire time
+	ns.RecordFireTime = false
+	assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err = ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", timer.Key)
+	assert.True(t, timer.FiredAt.IsZero())
+
+	// Streams record it in the entry
+	ns = c.Namespace("bar")
+	ns.Queue = QueueStream
+	ns.RecordFireTime = true
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err = ns.NextGroup(ctx, "workers", "a")
+	require.NoError(t, err)
+	assert.True(t, timer.FiredAt.After(before))
+}
+
+func TestNamespace_ExpectedVersion(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	info, ok, err := ns.Describe(ctx, "foo")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, int64(1), info.Version)
+
+	// Only the producer with the current version gets to reschedule the timer
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", 2*time.Hour, CreateOptions{ExpectedVersion: 1}))
+	err = ns.CreateWithOptions(ctx, "foo", time.Minute, CreateOptions{ExpectedVersion: 1})
+	assert.ErrorIs(t, err, ErrVersionConflict)
+	info, _, err = ns.Describe(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), info.Version)
+	assert.Greater(t, info.Remaining, time.Hour)
+
+	// Every write increments the version
+	assert.NoError(t, ns.Upsert(ctx, "foo", time.Hour))
+	assert.NoError(t, ns.CreateMany(ctx, map[string]time.Duration{"foo": time.Hour}))
+	info, _, err = ns.Describe(ctx, "foo")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Version)
+
+	// Timers that don't exist have no version
+	err = ns.CreateWithOptions(ctx, "bar", time.Hour, CreateOptions{ExpectedVersion: 1})
+	assert.ErrorIs(t, err, ErrVersionConflict)
+	ns.assertRegisteredLen(t, 1)
+
+	// The version is forgotten along with the timer
+	_, err = ns.Cancel(ctx, "foo")
+	assert.NoError(t, err)
+	ns.assertForgotten(t, "foo")
+}
+
+func TestClient_WithTx(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// The domain record and its timer are written together
+	err := c.WithTx(ctx, func(tx *NamespaceTx) error {
+		tx.Pipe.Set(ctx, "order:1", "pending", 0)
+		return tx.CreateWithOptions(ns, "order:1", time.Hour, CreateOptions{Label: "expire order 1"})
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "pending", c.r.Get(ctx, "order:1").Val())
+	info, ok, err := ns.Describe(ctx, "order:1")
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "expire order 1", info.Label)
+
+	// Nothing is written if fn fails
+	err = c.WithTx(ctx, func(tx *NamespaceTx) error {
+		tx.Pipe.Set(ctx, "order:2", "pending", 0)
+		assert.NoError(t, tx.Create(ns, "order:2", time.Hour))
+		return fmt.Errorf("oops")
+	})
+	assert.EqualError(t, err, "oops")
+	assert.Zero(t, c.r.Exists(ctx, "order:2").Val())
+	ns.assertRegisteredLen(t, 1)
+
+	// Nothing is written if a watched key changes
+	err = c.WithTx(ctx, func(tx *NamespaceTx) error {
+		status, err := tx.Tx.Get(ctx, "order:1").Result()
+		require.NoError(t, err)
+		assert.Equal(t, "pending", status)
+		assert.NoError(t, c.r.Set(ctx, "order:1", "paid", 0).Err())
+		tx.Pipe.Set(ctx, "order:1", "cancelled", 0)
+		return tx.Cancel(ns, "order:1")
+	}, "order:1")
+	assert.ErrorIs(t, err, redis.TxFailedErr)
+	assert.Equal(t, "paid", c.r.Get(ctx, "order:1").Val())
+	ns.assertRegisteredLen(t, 1)
+
+	// Cancelling in a transaction
+	err = c.WithTx(ctx, func(tx *NamespaceTx) error {
+		tx.Pipe.Del(ctx, "order:1")
+		return tx.Cancel(ns, "order:1")
+	}, "order:1")
+	require.NoError(t, err)
+	ns.assertForgotten(t, "order:1")
+
+	// Conditions are only checked when the transaction is executed
+	assert.NoError(t, ns.Create(ctx, "order:3", time.Hour))
+	err = c.WithTx(ctx, func(tx *NamespaceTx) error {
+		tx.Pipe.Set(ctx, "order:3", "pending", 0)
+		return tx.CreateWithOptions(ns, "order:3", time.Hour, CreateOptions{OnlyIfAbsent: true})
+	})
+	assert.ErrorIs(t, err, ErrTimerExists)
+	assert.Equal(t, "pending", c.r.Get(ctx, "order:3").Val())
+
+	// Namespaces of other clients can't be used
+	other, stopOther := client(t)
+	defer stopOther()
+	err = c.WithTx(ctx, func(tx *NamespaceTx) error {
+		return tx.Create(other.Namespace("foo"), "bar", time.Hour)
+	})
+	assert.Error(t, err)
+}
+
+func TestNamespace_CreateMap(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.MaxDuration = time.Hour
+	err := ns.CreateMap(ctx, map[string]time.Duration{
+		"foo": time.Minute,
+		"bar": 2 * time.Hour,
+		"baz": time.Second,
+		"qux": -time.Second,
+	})
+	var merr *CreateMapError
+	require.ErrorAs(t, err, &merr)
+	assert.Len(t, merr.Failed, 2)
+	assert.ErrorIs(t, merr.Failed["bar"], ErrDurationTooLong)
+	assert.ErrorIs(t, merr.Failed["qux"], ErrInvalidDuration)
+	assert.ErrorIs(t, err, ErrDurationTooLong)
+	ns.assertRegisteredLen(t, 2)
+
+	remaining, ok, err := ns.Remaining(ctx, "foo")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, time.Minute, remaining, float64(time.Second))
+
+	assert.NoError(t, ns.CreateMap(ctx, map[string]time.Duration{"bar": time.Hour}))
+	ns.assertRegisteredLen(t, 3)
+}
+
+func TestNamespace_MaxDuration(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.MaxDuration = time.Hour
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	assert.ErrorIs(t, ns.Create(ctx, "bar", 2*time.Hour), ErrDurationTooLong)
+	assert.ErrorIs(t, ns.CreateMany(ctx, map[string]time.Duration{"bar": 2 * time.Hour}), ErrDurationTooLong)
+	results := ns.CreateBatch(ctx, []BatchTimer{{Key: "bar", Duration: 2 * time.Hour}})
+	assert.ErrorIs(t, results[0].Err, ErrDurationTooLong)
+	ns.assertRegisteredLen(t, 1)
+
+	var created time.Duration
+	ns.OnCreate = func(key string, duration time.Duration) {
+		created = duration
+	}
+	ns.ClampDuration = true
+	assert.NoError(t, ns.Create(ctx, "bar", 2*time.Hour))
+	assert.Equal(t, time.Hour, created)
+	remaining, ok, err := ns.Remaining(ctx, "bar")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour, remaining, float64(time.Second))
+}
+
+func TestNamespace_TimeScale(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	assert.NoError(t, ns.Create(ctx, "before", time.Hour))
+	ns.TimeScale = 0.1
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	assert.NoError(t, ns.CreateAt(ctx, "bar", time.Now().Add(time.Hour)))
+	assert.NoError(t, ns.CreateRecurring(ctx, "baz", time.Hour))
+	results := ns.CreateBatch(ctx, []BatchTimer{{Key: "qux", Duration: time.Hour}})
+	assert.NoError(t, results[0].Err)
+
+	for key, want := range map[string]time.Duration{
+		"before": time.Hour,
+		"foo":    6 * time.Minute,
+		"bar":    6 * time.Minute,
+		"baz":    6 * time.Minute,
+		"qux":    6 * time.Minute,
+	} {
+		remaining, ok, err := ns.Remaining(ctx, key)
+		assert.NoError(t, err)
+		assert.True(t, ok, key)
+		assert.InDelta(t, want, remaining, float64(time.Second), key)
+	}
+	interval, err := c.r.HGet(ctx, ns.recurringKey(), "baz").Int64()
+	assert.NoError(t, err)
+	assert.Equal(t, (6 * time.Minute).Milliseconds(), interval)
+
+	ns.MaxDuration = time.Hour
+	assert.NoError(t, ns.Create(ctx, "long", 2*time.Hour))
+
+	// Warnings are scaled once, along with the timer
+	warned := CreateOptions{Warnings: []time.Duration{5 * time.Minute, 30 * time.Second}}
+	assert.NoError(t, ns.CreateWithOptions(ctx, "warned", 10*time.Minute, warned))
+	results = ns.CreateBatch(ctx, []BatchTimer{{Key: "batched", Duration: 10 * time.Minute, Options: warned}})
+	assert.NoError(t, results[0].Err)
+	for _, key := range []string{"warned", "batched"} {
+		ns.assertTTLBetween(t, warningKey(key, 3*time.Second), 56*time.Second, 57*time.Second)
+		ns.assertTTLBetween(t, warningKey(key, 30*time.Second), 29*time.Second, 30*time.Second)
+	}
+}
+
+func TestNamespace_CreateBatch(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.Create(ctx, "bar", time.Hour))
+	results := ns.CreateBatch(ctx, []BatchTimer{
+		{Key: "foo", Duration: time.Hour, Options: CreateOptions{Label: "foo"}},
+		{Key: "bar", Duration: time.Minute, Options: CreateOptions{OnlyIfAbsent: true}},
+		{Key: "baz", Duration: -time.Second},
+		{Key: "qux", Duration: time.Hour, Options: CreateOptions{OnlyIfAbsent: true}},
+	})
+	require.Len(t, results, 4)
+	assert.Equal(t, CreateResult{Key: "foo", Outcome: BatchCreated}, results[0])
+	assert.Equal(t, CreateResult{Key: "bar", Outcome: BatchExists}, results[1])
+	assert.Equal(t, "baz", results[2].Key)
+	assert.Equal(t, BatchFailed, results[2].Outcome)
+	assert.ErrorIs(t, results[2].Err, ErrInvalidDuration)
+	assert.Equal(t, CreateResult{Key: "qux", Outcome: BatchCreated}, results[3])
+
+	ns.assertRegisteredLen(t, 3)
+	info, ok, err := ns.Describe(ctx, "foo")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "foo", info.Label)
+	// The existing timer was left alone
+	d, ok, err := ns.Remaining(ctx, "bar")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Greater(t, d, time.Minute)
+
+	err = ns.CreateWithOptions(ctx, "bar", time.Minute, CreateOptions{OnlyIfAbsent: true})
+	assert.ErrorIs(t, err, ErrTimerExists)
+}
+
+func TestNamespace_Label(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Hour, CreateOptions{
+		Label: "send renewal reminder for order 123",
+	}))
+	assert.NoError(t, ns.CreateRecurring(ctx, "bar", time.Hour))
+
+	info, ok, err := ns.Describe(ctx, "foo")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "send renewal reminder for order 123", info.Label)
+	assert.False(t, info.Recurring)
+	assert.InDelta(t, time.Hour, info.Remaining, float64(time.Second))
+
+	_, ok, err = ns.Describe(ctx, "baz")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	infos, err := ns.List(ctx)
+	require.NoError(t, err)
+	assert.Len(t, infos, 2)
+
+	// Upsert keeps the label, Create replaces it
+	assert.NoError(t, ns.Upsert(ctx, "foo", time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "send renewal reminder for order 123", timer.Label)
+
+	// The label is removed once the timer is consumed
+	labels, err := c.r.HLen(ctx, ns.labelsKey()).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, labels)
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Hour, CreateOptions{Label: "foo"}))
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	info, _, err = ns.Describe(ctx, "foo")
+	assert.NoError(t, err)
+	assert.Empty(t, info.Label)
+}
+
+func TestNamespace_PollMaxFire(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.PollMaxFire = 150
+	ns.PollYield = time.Millisecond
+
+	timers := make(map[string]time.Duration)
+	for i := 0; i < 200; i++ {
+		timers[strconv.Itoa(i)] = time.Millisecond
+	}
+	assert.NoError(t, ns.CreateMany(ctx, timers))
+	time.Sleep(10 * time.Millisecond)
+
+	// A capped Poll leaves the rest of the backlog for the next one
+	assert.NoError(t, ns.Poll(ctx))
+	ns.assertQueueLen(t, 150)
+	ns.assertRegisteredLen(t, 50)
+	assert.NoError(t, ns.Poll(ctx))
+	ns.assertQueueLen(t, 200)
+	ns.assertRegisteredLen(t, 0)
+}
+
+func TestNamespace_MaxPollDuration(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.MaxPollDuration = time.Nanosecond
+
+	timers := make(map[string]time.Duration)
+	for i := 0; i < 20; i++ {
+		timers[strconv.Itoa(i)] = time.Millisecond
+	}
+	assert.NoError(t, ns.CreateMany(ctx, timers))
+	time.Sleep(10 * time.Millisecond)
+
+	// Each Poll fires one timer before it runs out of time, and the rest are
+	// fired by the following Polls without losing or repeating any
+	var polls, fired int
+	for {
+		r, err := ns.PollWithResult(ctx)
+		require.NoError(t, err)
+		polls++
+		fired += r.Fired
+		if !r.Truncated {
+			break
+		}
+		assert.Equal(t, 1, r.Fired)
+	}
+	assert.Equal(t, 20, fired)
+	assert.Equal(t, 20, polls)
+	ns.assertRegisteredLen(t, 0)
+	queued, err := ns.QueueContents(ctx, 100)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "13", "14", "15", "16", "17", "18", "19"}, queued)
+
+	// PollLoop polls again right away instead of waiting for the interval
+	_, err = ns.PurgeQueue(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, ns.CreateMany(ctx, timers))
+	time.Sleep(10 * time.Millisecond)
+	loopCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		_ = ns.PollLoop(loopCtx, time.Hour)
+	}()
+	assert.Eventually(t, func() bool {
+		length, err := ns.QueueLength(ctx)
+		return err == nil && length == 20
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNamespace_StrictExpiry(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.StrictExpiry = true
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	ns.assertQueueLen(t, 2)
+
+	// Timers whose keys still exist are never fired
+	assert.NoError(t, ns.Create(ctx, "baz", time.Hour))
+	expired, err := ns.verifyExpired(ctx, []string{"baz", "qux"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"qux"}, expired)
+}
+
+func TestClient_DefaultTimeout(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	c.DefaultTimeout = time.Second
+	assert.NoError(t, c.Namespace("foo").Create(ctx, "foo", time.Hour))
+
+	bounded, cancel := c.withDefaultTimeout(context.Background())
+	defer cancel()
+	deadline, ok := bounded.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond)
+
+	// A deadline on the caller's context takes precedence
+	parent, cancelParent := context.WithTimeout(context.Background(), time.Hour)
+	defer cancelParent()
+	bounded, cancel = c.withDefaultTimeout(parent)
+	defer cancel()
+	assert.Equal(t, parent, bounded)
+}
+
+func TestNamespace_NextIdleBackoff(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.NextIdleBackoff = time.Millisecond
+	ns.NextIdleBackoffMax = 20 * time.Millisecond
+
+	keys := make(chan string)
+	go func() {
+		key, err := ns.Next(ctx)
+		assert.NoError(t, err)
+		keys <- key
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	select {
+	case key := <-keys:
+		assert.Equal(t, "foo", key)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for Next")
+	}
+
+	// Cancelling the context stops the wait
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	_, err := ns.Next(cctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestNamespace_Reconcile(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// A temporary set left behind by an older version, and one in use
+	orphaned, inUse := ns.registeredTempKey(), ns.registeredTempKey()
+	assert.NoError(t, c.r.SAdd(ctx, orphaned, "foo").Err())
+	assert.NoError(t, c.r.SAdd(ctx, inUse, "foo").Err())
+	assert.NoError(t, c.r.Expire(ctx, inUse, time.Minute).Err())
+
+	// A timer that lost its registration, and one that expired
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	assert.NoError(t, c.r.Del(ctx, ns.registeredKeys()...).Err())
+	assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+
+	r, err := ns.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, r.TempSetsRemoved)
+	assert.Equal(t, 1, r.Registered)
+	assert.Equal(t, 1, r.Poll.Fired)
+	assert.Equal(t, 1, r.Poll.Skipped)
+	assert.Positive(t, r.Duration)
+	ns.assertRegisteredTempLen(t, 1)
+	ns.assertRegisteredLen(t, 1)
+	key, err := ns.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", key)
+
+	r, err = ns.Reconcile(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ReconcileReport{Poll: PollResult{Skipped: 1}, Duration: r.Duration}, *r)
+}
+
+func TestNamespace_RebuildRegistered(t *testing.T) {
+	for _, rep := range []Representation{RepresentationSet, RepresentationBucketed, RepresentationSortedSet} {
+		t.Run(rep.String(), func(t *testing.T) {
+			c, stop := client(t)
+			defer stop()
+
+			ns := c.Namespace("foo")
+			ns.Representation = rep
+
+			assert.NoError(t, ns.Create(ctx, "foo", 100*time.Millisecond))
+			assert.NoError(t, ns.Create(ctx, "bar", time.Hour))
+			assert.NoError(t, c.r.Del(ctx, ns.registeredKeys()...).Err())
+
+			added, err := ns.RebuildRegistered(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, added)
+			ns.assertRegisteredLen(t, 2)
+
+			added, err = ns.RebuildRegistered(ctx)
+			assert.NoError(t, err)
+			assert.Zero(t, added)
+
+			time.Sleep(150 * time.Millisecond)
+			assert.NoError(t, ns.Poll(ctx))
+			key, err := ns.Next(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, "foo", key)
+		})
+	}
+}
+
+func TestNamespace_QueueShards(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.QueueShards = 4
+
+	timers := make(map[string]time.Duration)
+	for i := 0; i < 20; i++ {
+		timers[strconv.Itoa(i)] = time.Millisecond
+	}
+	assert.NoError(t, ns.CreateMany(ctx, timers))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	total := 0
+	for shard := 0; shard < ns.QueueShards; shard++ {
+		n, err := c.r.LLen(ctx, ns.queueShardKey(shard)).Result()
+		assert.NoError(t, err)
+		assert.NotZero(t, n)
+		total += int(n)
+	}
+	assert.Equal(t, 20, total)
+
+	// Queued timers can be cancelled from their shard
+	cancelled, err := ns.Cancel(ctx, "0")
+	assert.NoError(t, err)
+	assert.True(t, cancelled)
+
+	queue := ns.queueKeyFor("1")
+	timer, err := ns.NextShard(ctx, int(queue[len(queue)-1]-'0'))
+	require.NoError(t, err)
+	assert.Equal(t, queue, ns.queueKeyFor(timer.Key))
+	assert.Equal(t, queue, ns.queueShardKey(timer.Shard))
+
+	// Timers report the shard they were popped from, whether Next blocks in
+	// Redis or not
+	seen := map[string]bool{"0": true, timer.Key: true}
+	for i := 0; i < 18; i++ {
+		if i == 9 {
+			ns.NextIdleBackoff = time.Millisecond
+		}
+		timer, err := ns.NextTimer(ctx)
+		require.NoError(t, err)
+		assert.False(t, seen[timer.Key])
+		assert.Equal(t, ns.queueKeyFor(timer.Key), ns.queueShardKey(timer.Shard))
+		seen[timer.Key] = true
+	}
+	assert.Len(t, seen, 20)
+
+	_, err = ns.NextShard(ctx, 4)
+	assert.Error(t, err)
+}
+
+func TestNamespace_NextShard_Unsharded(t *testing.T) {
+	for _, shards := range []int{0, 1} {
+		t.Run(strconv.Itoa(shards), func(t *testing.T) {
+			c, stop := client(t)
+			defer stop()
+
+			ns := c.Namespace("foo")
+			ns.QueueShards = shards
+			assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+			time.Sleep(10 * time.Millisecond)
+			assert.NoError(t, ns.Poll(ctx))
+
+			// An unsharded queue is shard 0
+			timer, err := ns.NextShard(ctx, 0)
+			require.NoError(t, err)
+			assert.Equal(t, "foo", timer.Key)
+			assert.Zero(t, timer.Shard)
+			_, err = ns.NextShard(ctx, 1)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNamespace_OnCreate(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	created := make(map[string]time.Duration)
+	ns.OnCreate = func(key string, duration time.Duration) {
+		created[key] = duration
+	}
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Hour, CreateOptions{Warnings: []time.Duration{time.Minute}}))
+	assert.NoError(t, ns.CreateMany(ctx, map[string]time.Duration{"bar": time.Minute, "baz": time.Second}))
+	ok, err := ns.CreateDedup(ctx, "foo", time.Hour, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	created["foo"] = 0
+	ok, err = ns.CreateDedup(ctx, "foo", time.Hour, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, map[string]time.Duration{"foo": 0, "bar": time.Minute, "baz": time.Second}, created)
+}
+
+func TestNamespace_Claim(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	_, _, err := ns.Claim(ctx, time.Second)
+	assert.ErrorIs(t, err, ErrNoTimers)
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "bar", 20*time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	// Completed claims are never returned to the queue
+	key, token, err := ns.Claim(ctx, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", key)
+	assert.NoError(t, ns.Complete(ctx, token))
+	assert.ErrorIs(t, ns.Complete(ctx, token), ErrClaimNotFound)
+
+	// Expired claims are returned to the front of the queue
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	key, token, err = ns.Claim(ctx, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "bar", key)
+	n, err := ns.Reclaim(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+	time.Sleep(50 * time.Millisecond)
+	n, err = ns.Reclaim(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.ErrorIs(t, ns.Complete(ctx, token), ErrClaimNotFound)
+	key, err = ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", key)
+}
+
+func TestNamespace_ClaimTimer(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// A redelivered one-shot timer keeps its details until it's completed
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{Label: "label", Value: []byte("value")}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	first, _, err := ns.ClaimTimer(ctx, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", first.Key)
+	assert.Equal(t, "label", first.Label)
+	assert.Equal(t, []byte("value"), first.Value)
+	time.Sleep(20 * time.Millisecond)
+	_, err = ns.Reclaim(ctx)
+	assert.NoError(t, err)
+	again, token, err := ns.ClaimTimer(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "label", again.Label)
+	assert.Equal(t, []byte("value"), again.Value)
+	assert.Equal(t, first.DeliveryID, again.DeliveryID)
+	assert.Greater(t, again.Token, first.Token)
+	assert.NoError(t, ns.Complete(ctx, token))
+	exists, err := c.r.HExists(ctx, ns.valuesKey(), "foo").Result()
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// Recurring timers are only re-armed when they're first claimed
+	assert.NoError(t, ns.CreateRecurring(ctx, "bar", time.Hour))
+	assert.NoError(t, c.r.PExpire(ctx, ns.timerKey("bar"), time.Millisecond).Err())
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, _, err := ns.ClaimTimer(ctx, 10*time.Millisecond)
+	require.NoError(t, err)
+	assert.True(t, timer.Recurring)
+	ns.assertTTLBetween(t, "bar", 59*time.Minute, time.Hour)
+	assert.NoError(t, c.r.PExpire(ctx, ns.timerKey("bar"), 30*time.Minute).Err())
+	time.Sleep(20 * time.Millisecond)
+	_, err = ns.Reclaim(ctx)
+	assert.NoError(t, err)
+	timer, token, err = ns.ClaimTimer(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.True(t, timer.Recurring)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), timer.NextFireAt, time.Second)
+	ns.assertTTLBetween(t, "bar", 29*time.Minute, 30*time.Minute)
+	assert.NoError(t, ns.Complete(ctx, token))
+	exists, err = c.r.HExists(ctx, ns.recurringKey(), "bar").Result()
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Warnings can be told apart from the timer firing
+	assert.NoError(t, ns.CreateWithOptions(ctx, "baz", 200*time.Millisecond, CreateOptions{Warnings: []time.Duration{190 * time.Millisecond}}))
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, token, err = ns.ClaimTimer(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "baz", timer.Key)
+	assert.Equal(t, 190*time.Millisecond, timer.Warning)
+	assert.NoError(t, ns.Complete(ctx, token))
+	_, ok, err := ns.Describe(ctx, "baz")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	time.Sleep(200 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	key, _, err := ns.Claim(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "baz", key)
+
+	// Claim returns warnings with the key that they were queued with
+	assert.NoError(t, ns.CreateWithOptions(ctx, "qux", time.Hour, CreateOptions{Warnings: []time.Duration{time.Hour - time.Millisecond}}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	key, _, err = ns.Claim(ctx, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, warningKey("qux", time.Hour-time.Millisecond), key)
+}
+
+func TestNamespace_CreateAt(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	c.SyncClock = true
+
+	assert.NoError(t, ns.CreateAt(ctx, "foo", time.Now().Add(time.Hour)))
+	remaining, ok, err := ns.Remaining(ctx, "foo")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.InDelta(t, time.Hour, remaining, float64(time.Second))
+
+	// Times in the past fire as soon as possible
+	assert.NoError(t, ns.CreateAt(ctx, "bar", time.Now().Add(-time.Hour)))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "bar", key)
+}
+
+func TestNamespace_PurgeQueue(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{Label: "foo"}))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Millisecond, CreateOptions{Label: "bar"}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Hour, CreateOptions{Label: "baz"}))
+
+	purged, err := ns.PurgeQueue(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	ns.assertQueueLen(t, 0)
+	ns.assertRegisteredLen(t, 1)
+	ns.assertForgotten(t, "foo")
+
+	// Timers created again after they fired keep their companion data
+	info, ok, err := ns.Describe(ctx, "bar")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "baz", info.Label)
+	tokens, err := c.r.HLen(ctx, ns.tokensKey()).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, tokens)
+}
+
+func TestNamespace_TransferQueue(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	src, dst := c.Namespace("foo"), c.Namespace("bar")
+
+	assert.NoError(t, src.CreateWithOptions(ctx, "foo", time.Millisecond, CreateOptions{
+		Label: "foo",
+		Tags:  map[string]string{"user": "42"},
+	}))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, src.Poll(ctx))
+	assert.NoError(t, src.Create(ctx, "bar", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, src.Poll(ctx))
+	assert.NoError(t, dst.Create(ctx, "baz", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, dst.Poll(ctx))
+
+	moved, err := src.TransferQueue(ctx, dst)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, moved)
+	src.assertQueueLen(t, 0)
+	src.assertForgotten(t, "foo")
+	keys, err := dst.FindByTag(ctx, "user", "42")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+
+	// The timers keep their order, after the ones already in the queue
+	for _, want := range []string{"baz", "foo", "bar"} {
+		timer, err := dst.NextTimer(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, timer.Key)
+		if want == "foo" {
+			assert.Equal(t, "foo", timer.Label)
+			assert.Equal(t, map[string]string{"user": "42"}, timer.Tags)
+		}
+	}
+	keys, err = dst.FindByTag(ctx, "user", "42")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestNamespace_MemoryUsage(t *testing.T) {
+	if os.Getenv("RIMER_TEST_REDIS") != "container" {
+		t.Skip("miniredis doesn't support MEMORY USAGE")
+	}
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	empty, err := ns.MemoryUsage(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, empty)
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Hour, CreateOptions{Label: "foo"}))
+	used, err := ns.MemoryUsage(ctx)
+	assert.NoError(t, err)
+	assert.Positive(t, used)
+}
+
+func TestNamespace_NextWithTimeout(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	_, err := ns.Peek(ctx)
+	assert.ErrorIs(t, err, ErrNoTimers)
+	_, err = ns.NextWithTimeout(ctx, time.Second)
+	assert.ErrorIs(t, err, ErrNoTimers)
+
+	// The blocking pop timing out isn't surfaced as redis.Nil
+	timer, err := ns.NextTimerWithTimeout(ctx, time.Second)
+	assert.ErrorIs(t, err, ErrNoTimers)
+	assert.NotErrorIs(t, err, redis.Nil)
+	assert.Zero(t, timer)
+
+	// An empty key is a valid key, not a missing timer
+	assert.NoError(t, ns.Create(ctx, "", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	key, err := ns.Peek(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "", key)
+	key, err = ns.NextWithTimeout(ctx, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "", key)
+}
+
+func TestNamespace_CreateAfter(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// b fires after a, and c after b
+	assert.NoError(t, ns.Create(ctx, "a", time.Millisecond))
+	assert.NoError(t, ns.CreateAfter(ctx, "b", "a", time.Millisecond))
+	assert.NoError(t, ns.CreateAfter(ctx, "c", "b", time.Millisecond))
+	for _, want := range []string{"a", "b", "c"} {
+		time.Sleep(10 * time.Millisecond)
+		assert.NoError(t, ns.Poll(ctx))
+		key, err := ns.NextWithTimeout(ctx, time.Second)
+		assert.NoError(t, err)
+		assert.Equal(t, want, key)
+	}
+	ns.assertRegisteredLen(t, 0)
+
+	// Cancelling a timer drops its dependents, transitively
+	assert.NoError(t, ns.Create(ctx, "a", time.Hour))
+	assert.NoError(t, ns.CreateAfter(ctx, "b", "a", time.Millisecond))
+	assert.NoError(t, ns.CreateAfter(ctx, "c", "b", time.Millisecond))
+	_, err := ns.Cancel(ctx, "a")
+	assert.NoError(t, err)
+	ns.assertRegisteredLen(t, 0)
+	records, err := c.r.HLen(ctx, ns.afterKey()).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, records)
+
+	// Or creates them when configured to
+	ns.FireDependentsOnCancel = true
+	assert.NoError(t, ns.Create(ctx, "a", time.Hour))
+	assert.NoError(t, ns.CreateAfter(ctx, "b", "a", time.Hour))
+	_, err = ns.Cancel(ctx, "a")
+	assert.NoError(t, err)
+	_, ok, err := ns.Describe(ctx, "b")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Dependents that can never be created are dropped along with their
+	// records, and the others are still created
+	assert.NoError(t, ns.Create(ctx, "a", time.Hour))
+	assert.NoError(t, ns.CreateAfter(ctx, "c", "a", time.Minute))
+	assert.NoError(t, c.r.HSet(ctx, ns.afterKey(), "a", `{"b":0,"c":60000}`).Err())
+	_, err = ns.Cancel(ctx, "a")
+	assert.ErrorIs(t, err, ErrInvalidDuration)
+	records, err = c.r.HLen(ctx, ns.afterKey()).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, records)
+	_, ok, err = ns.Describe(ctx, "c")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// So are dependents that are longer than MaxDuration by the time they're
+	// created
+	ns.MaxDuration = 30 * time.Minute
+	assert.NoError(t, ns.Create(ctx, "d", time.Millisecond))
+	assert.NoError(t, ns.CreateAfter(ctx, "e", "d", time.Hour))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	_, err = ns.NextTimer(ctx)
+	assert.ErrorIs(t, err, ErrDurationTooLong)
+	records, err = c.r.HLen(ctx, ns.afterKey()).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, records)
+}
+
+func TestNamespace_CreateAfter_Cycle(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// Cycles are rejected, however long they are
+	assert.ErrorIs(t, ns.CreateAfter(ctx, "a", "a", time.Minute), ErrDependencyCycle)
+	assert.NoError(t, ns.CreateAfter(ctx, "b", "a", time.Minute))
+	assert.NoError(t, ns.CreateAfter(ctx, "c", "b", time.Minute))
+	assert.ErrorIs(t, ns.CreateAfter(ctx, "a", "b", time.Minute), ErrDependencyCycle)
+	assert.ErrorIs(t, ns.CreateAfter(ctx, "a", "c", time.Minute), ErrDependencyCycle)
+
+	// Cancelling a timer in a cycle that was written anyway still returns
+	assert.NoError(t, c.r.HSet(ctx, ns.afterKey(), "c", `{"a":60000}`).Err())
+	assert.NoError(t, ns.Create(ctx, "a", time.Hour))
+	cancelCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err := ns.Cancel(cancelCtx, "a")
+	assert.NoError(t, err)
+	records, err := c.r.HLen(ctx, ns.afterKey()).Result()
+	assert.NoError(t, err)
+	assert.Zero(t, records)
+}
+
+func TestNamespace_PollWithResult(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.PollMaxFire = 1
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "baz", time.Hour))
+	time.Sleep(10 * time.Millisecond)
+
+	r, err := ns.PollWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, PollResult{Expired: 2, Fired: 1, Skipped: 1}, r)
+	r, err = ns.PollWithResult(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, PollResult{Expired: 1, Fired: 1, Skipped: 1}, r)
+}
+
+func TestNamespace_TempSets(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	sets, err := ns.TempSets(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, sets)
+
+	// A set left behind by an older version, and one by an interrupted Poll
+	orphaned := ns.registeredTempKey()
+	assert.NoError(t, c.r.SAdd(ctx, orphaned, "foo").Err())
+	c.PollerID = "host-1234"
+	interrupted := ns.registeredTempKey()
+	assert.NoError(t, c.r.SAdd(ctx, interrupted, "foo").Err())
+	assert.NoError(t, c.r.Expire(ctx, interrupted, time.Minute).Err())
+
+	// Polls clean up after themselves
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	assert.NoError(t, ns.Poll(ctx))
+
+	sets, err = ns.TempSets(ctx)
+	require.NoError(t, err)
+	require.Len(t, sets, 2)
+	sort.Slice(sets, func(i, j int) bool { return sets[i].PollerID < sets[j].PollerID })
+	assert.Equal(t, TempSet{Key: orphaned, Remaining: -1}, sets[0])
+	assert.Equal(t, interrupted, sets[1].Key)
+	assert.Equal(t, "host-1234", sets[1].PollerID)
+	assert.InDelta(t, time.Minute, sets[1].Remaining, float64(time.Second))
+}
+
+func TestClient_Rand(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	c.Rand = rand.NewSource(1)
+	first := ns.registeredTempKey()
+	c.Rand = rand.NewSource(1)
+	assert.Equal(t, first, ns.registeredTempKey())
+	assert.NotEqual(t, first, ns.registeredTempKey())
+}
+
+func TestNamespace_jitter(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	assert.Equal(t, time.Second, ns.jitter(time.Second))
+
+	ns.PollJitter = 0.1
+	for i := 0; i < 100; i++ {
+		d := ns.jitter(time.Second)
+		assert.GreaterOrEqual(t, d, 900*time.Millisecond)
+		assert.LessOrEqual(t, d, 1100*time.Millisecond)
+	}
+}
+
+func TestNamespace_Export(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateWithOptions(ctx, "foo", time.Hour, CreateOptions{Label: "foo", Tags: map[string]string{"user": "42"}}))
+	assert.NoError(t, ns.CreateRecurring(ctx, "bar", time.Minute))
+	data, err := ns.Export(ctx)
+	require.NoError(t, err)
+
+	other := c.Namespace("bar")
+	assert.NoError(t, other.Import(ctx, data))
+	infos, err := other.List(ctx)
+	require.NoError(t, err)
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	require.Len(t, infos, 2)
+	assert.Equal(t, "bar", infos[0].Key)
+	assert.True(t, infos[0].Recurring)
+	assert.InDelta(t, time.Minute, infos[0].Remaining, float64(time.Second))
+	assert.Equal(t, "foo", infos[1].Key)
+	assert.Equal(t, "foo", infos[1].Label)
+	assert.InDelta(t, time.Hour, infos[1].Remaining, float64(time.Second))
+
+	// Tags are restored along with their indexes
+	keys, err := other.FindByTag(ctx, "user", "42")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo"}, keys)
+}
+
+func TestNamespace_IdleTTL(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	ns.IdleTTL = 24 * time.Hour
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	ttl, err := c.r.PTTL(ctx, ns.registeredKey()).Result()
+	assert.NoError(t, err)
+	assert.InDelta(t, 24*time.Hour, ttl, float64(time.Second))
+
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	ttl, err = c.r.PTTL(ctx, ns.queueKey()).Result()
+	assert.NoError(t, err)
+	assert.InDelta(t, 24*time.Hour, ttl, float64(time.Second))
+}
+
+func TestNamespace_RemainingMany(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Minute))
+	remaining, err := ns.RemainingMany(ctx, []string{"foo", "bar", "baz"})
+	require.NoError(t, err)
+	assert.Len(t, remaining, 2)
+	assert.InDelta(t, time.Hour, remaining["foo"], float64(time.Second))
+	assert.InDelta(t, time.Minute, remaining["bar"], float64(time.Second))
+
+	_, ok, err := ns.Remaining(ctx, "baz")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestNamespace_ListWithRemaining(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	assert.NoError(t, ns.Create(ctx, "bar", time.Millisecond))
+	assert.NoError(t, ns.Create(ctx, "baz", time.Hour))
+	time.Sleep(10 * time.Millisecond)
+
+	statuses, err := ns.ListWithRemaining(ctx)
+	require.NoError(t, err)
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	require.Len(t, statuses, 3)
+	assert.Equal(t, TimerStatus{Key: "bar", State: TimerExpired}, statuses[0])
+	assert.Equal(t, "baz", statuses[1].Key)
+	assert.Equal(t, TimerArmed, statuses[1].State)
+	assert.InDelta(t, time.Hour, statuses[1].Remaining, float64(time.Second))
+	assert.Equal(t, TimerStatus{Key: "foo", State: TimerQueued}, statuses[2])
+	assert.Equal(t, "queued", TimerQueued.String())
+}
+
+func TestNamespace_Upcoming(t *testing.T) {
+	for _, r := range []Representation{RepresentationSet, RepresentationSortedSet, RepresentationBucketed} {
+		t.Run(r.String(), func(t *testing.T) {
+			c, stop := client(t)
+			defer stop()
+
+			ns := c.Namespace("foo")
+			ns.Representation = r
+
+			upcoming, err := ns.Upcoming(ctx, 2)
+			assert.NoError(t, err)
+			assert.Empty(t, upcoming)
+
+			assert.NoError(t, ns.Create(ctx, "queued", time.Millisecond))
+			time.Sleep(10 * time.Millisecond)
+			assert.NoError(t, ns.Poll(ctx))
+			assert.NoError(t, ns.Create(ctx, "foo", time.Hour))
+			assert.NoError(t, ns.CreateWithOptions(ctx, "bar", time.Minute, CreateOptions{Label: "soon"}))
+			assert.NoError(t, ns.Create(ctx, "baz", 10*time.Minute))
+
+			upcoming, err = ns.Upcoming(ctx, 2)
+			assert.NoError(t, err)
+			require.Len(t, upcoming, 2)
+			assert.Equal(t, "bar", upcoming[0].Key)
+			assert.Equal(t, "soon", upcoming[0].Label)
+			assert.Equal(t, TimerArmed, upcoming[0].State)
+			assert.InDelta(t, time.Minute, upcoming[0].Remaining, float64(time.Second))
+			assert.Equal(t, "baz", upcoming[1].Key)
+			assert.InDelta(t, 10*time.Minute, upcoming[1].Remaining, float64(time.Second))
+
+			// Expired timers that haven't been polled yet come first
+			assert.NoError(t, ns.Create(ctx, "expired", time.Millisecond))
+			time.Sleep(10 * time.Millisecond)
+			upcoming, err = ns.Upcoming(ctx, 10)
+			assert.NoError(t, err)
+			require.Len(t, upcoming, 4)
+			assert.Equal(t, TimerStatus{Key: "expired", State: TimerExpired}, upcoming[0])
+			assert.Equal(t, "foo", upcoming[3].Key)
+		})
+	}
+}
+
+func TestNamespace_Create_Duration(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// Sub-millisecond durations are rounded up rather than down to zero
+	assert.NoError(t, ns.Create(ctx, "foo", 500*time.Microsecond))
+	ns.assertKeysLen(t, 1)
+	ns.assertRegisteredLen(t, 1)
+
+	// Durations that aren't positive are rejected without writing anything
+	assert.ErrorIs(t, ns.Create(ctx, "bar", 0), ErrInvalidDuration)
+	assert.ErrorIs(t, ns.Create(ctx, "bar", -time.Second), ErrInvalidDuration)
+	assert.ErrorIs(t, ns.BumpEarlier(ctx, "bar", 0), ErrInvalidDuration)
+	assert.ErrorIs(t, ns.ExtendLater(ctx, "bar", 0), ErrInvalidDuration)
+	ns.assertRegisteredLen(t, 1)
+
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", key)
+}
+
+func TestNamespace_durationMs(t *testing.T) {
+	for d, ms := range map[time.Duration]int64{
+		time.Nanosecond:                    1,
+		500 * time.Microsecond:             1,
+		time.Millisecond:                   1,
+		time.Millisecond + time.Nanosecond: 2,
+		1500 * time.Microsecond:            2,
+		time.Second:                        1000,
+	} {
+		got, err := durationMs(d)
+		assert.NoError(t, err)
+		assert.Equal(t, ms, got, d.String())
+	}
+}
+
+func TestNamespace_CreateRecurring(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateRecurring(ctx, "foo", time.Second))
+	time.Sleep(2 * time.Second)
+	assert.NoError(t, ns.Poll(ctx))
+
+	// Consuming the timer re-arms it
+	timer, err := ns.NextTimer(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", timer.Key)
+	assert.True(t, timer.Recurring)
+	assert.WithinDuration(t, time.Now().Add(time.Second), timer.NextFireAt, 100*time.Millisecond)
+	ns.assertKeysLen(t, 1)
+	ns.assertRegisteredLen(t, 1)
+
+	// Creating a one-shot timer with the same key stops the recurrence
+	assert.NoError(t, ns.Create(ctx, "foo", time.Second))
+	time.Sleep(2 * time.Second)
+	assert.NoError(t, ns.Poll(ctx))
+	timer, err = ns.NextTimer(ctx)
+	assert.NoError(t, err)
+	assert.False(t, timer.Recurring)
+	assert.True(t, timer.NextFireAt.IsZero())
+	ns.assertKeysLen(t, 0)
+	ns.assertRegisteredLen(t, 0)
+}
+
+func TestNamespace_RescheduleRecurring(t *testing.T) {
+	for _, r := range []Representation{RepresentationSet, RepresentationSortedSet} {
+		t.Run(r.String(), func(t *testing.T) {
+			c, stop := client(t)
+			defer stop()
+
+			ns := c.Namespace("foo")
+			ns.Representation = r
+
+			assert.ErrorIs(t, ns.RescheduleRecurring(ctx, "foo", time.Minute), ErrTimerNotFound)
+			assert.NoError(t, ns.Create(ctx, "once", time.Hour))
+			assert.ErrorIs(t, ns.RescheduleRecurring(ctx, "once", time.Minute), ErrNotRecurring)
+			assert.NoError(t, ns.CreateCron(ctx, "cron", "* * * * *"))
+			assert.ErrorIs(t, ns.RescheduleRecurring(ctx, "cron", time.Minute), ErrNotRecurring)
+			assert.ErrorIs(t, ns.RescheduleRecurring(ctx, "once", 0), ErrInvalidDuration)
+
+			// The pending fire is left alone, and the next one uses the new interval
+			assert.NoError(t, ns.CreateRecurring(ctx, "foo", 50*time.Millisecond))
+			assert.NoError(t, ns.RescheduleRecurring(ctx, "foo", time.Minute))
+			ns.assertTTLBetween(t, "foo", 0, 50*time.Millisecond)
+			time.Sleep(100 * time.Millisecond)
+			assert.NoError(t, ns.Poll(ctx))
+			timer, err := ns.NextTimer(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, "foo", timer.Key)
+			assert.WithinDuration(t, time.Now().Add(time.Minute), timer.NextFireAt, 100*time.Millisecond)
+			ns.assertTTLBetween(t, "foo", 59*time.Second, time.Minute)
+
+			// RescheduleRecurringNow also moves the pending fire
+			assert.NoError(t, ns.RescheduleRecurringNow(ctx, "foo", 50*time.Millisecond))
+			ns.assertTTLBetween(t, "foo", 0, 50*time.Millisecond)
+			upcoming, err := ns.Upcoming(ctx, 1)
+			require.NoError(t, err)
+			require.Len(t, upcoming, 1)
+			assert.Equal(t, "foo", upcoming[0].Key)
+			time.Sleep(100 * time.Millisecond)
+			assert.NoError(t, ns.Poll(ctx))
+
+			// Fired timers waiting in the queue can still be rescheduled
+			assert.NoError(t, ns.RescheduleRecurringNow(ctx, "foo", time.Hour))
+			timer, err = ns.NextTimer(ctx)
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(time.Hour), timer.NextFireAt, 100*time.Millisecond)
+			ns.assertTTLBetween(t, "foo", 59*time.Minute, time.Hour)
+
+			// The new interval is scaled and limited like when creating the timer
+			ns.TimeScale = 0.5
+			assert.NoError(t, ns.RescheduleRecurringNow(ctx, "foo", time.Hour))
+			ns.assertTTLBetween(t, "foo", 29*time.Minute, 30*time.Minute)
+			ns.MaxDuration = 10 * time.Minute
+			assert.ErrorIs(t, ns.RescheduleRecurringNow(ctx, "foo", time.Hour), ErrDurationTooLong)
+			ns.ClampDuration = true
+			assert.NoError(t, ns.RescheduleRecurringNow(ctx, "foo", time.Hour))
+			ns.assertTTLBetween(t, "foo", 9*time.Minute, 10*time.Minute)
+		})
+	}
+}
+
+func TestNamespace_CreateCron(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	assert.NoError(t, ns.CreateCron(ctx, "foo", "* * * * *"))
+	ns.assertTTLBetween(t, "foo", 0, time.Minute)
+	info, _, err := ns.Describe(ctx, "foo")
+	require.NoError(t, err)
+	assert.True(t, info.Recurring)
+
+	// Fire it early instead of waiting for the next minute
+	assert.NoError(t, c.r.PExpire(ctx, ns.timerKey("foo"), time.Millisecond).Err())
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+
+	// Consuming the timer re-arms it for the next occurrence
+	timer, err := ns.NextTimer(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", timer.Key)
+	assert.True(t, timer.Recurring)
+	assert.Equal(t, timer.NextFireAt.Truncate(time.Minute), timer.NextFireAt)
+	assert.WithinDuration(t, time.Now(), timer.NextFireAt, time.Minute)
+	ns.assertTTLBetween(t, "foo", 0, time.Minute)
+	ns.assertRegisteredLen(t, 1)
+
+	// The expression survives an export
+	data, err := ns.Export(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"cron":"* * * * *"`)
+
+	// Cancelling the timer before it's consumed stops the schedule
+	assert.NoError(t, c.r.PExpire(ctx, ns.timerKey("foo"), time.Millisecond).Err())
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, ns.Poll(ctx))
+	_, err = ns.Cancel(ctx, "foo")
+	assert.NoError(t, err)
+	ns.assertKeysLen(t, 0)
+	ns.assertRegisteredLen(t, 0)
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * * mon-", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *"} {
+		assert.ErrorIs(t, ns.CreateCron(ctx, "bar", expr), ErrInvalidCron, expr)
+	}
+	ns.assertRegisteredLen(t, 0)
+}
+
+func TestRedisMajorVersion(t *testing.T) {
+	assert.Equal(t, 7, redisMajorVersion("# Server\r\nredis_version:7.2.4\r\nredis_mode:standalone\r\n"))
+	assert.Equal(t, 6, redisMajorVersion("redis_version:6.2.14"))
+	assert.Equal(t, 0, redisMajorVersion("# Clients\r\nconnected_clients:1\r\n"))
+}
+
+func TestClient_supportsLMPop(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	// A failed check isn't remembered, so the next one tries again
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.False(t, c.supportsLMPop(cancelled))
+	assert.False(t, c.lmpopKnown)
+}
+
+func TestCronSchedule_next(t *testing.T) {
+	// A Wednesday
+	after := time.Date(2023, 5, 31, 10, 17, 30, 0, time.UTC)
+	for _, tt := range []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2023, 5, 31, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, 5, 31, 10, 30, 0, 0, time.UTC)},
+		{"0 9-17 * * mon-fri", time.Date(2023, 5, 31, 11, 0, 0, 0, time.UTC)},
+		{"30 8 * * sat,sun", time.Date(2023, 6, 3, 8, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2023, 6, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either the day of the month or the day of the week
+		{"0 0 15 * fri", time.Date(2023, 6, 2, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2023, 5, 31, 11, 0, 0, 0, time.UTC)},
+	} {
+		s, err := parseCron(tt.expr)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.next, s.next(after), tt.expr)
+	}
+}
+
+func TestNamespace_Histogram(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	assert.NoError(t, ns.Create(ctx, "a", time.Second))
+	assert.NoError(t, ns.Create(ctx, "b", time.Minute))
+	assert.NoError(t, ns.Create(ctx, "c", time.Minute))
+	assert.NoError(t, ns.Create(ctx, "d", time.Hour))
+
+	counts, err := ns.Histogram(ctx, []time.Duration{time.Second, time.Minute})
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 1}, counts)
+
+	_, err = ns.Histogram(ctx, []time.Duration{time.Minute, time.Second})
+	assert.Error(t, err)
+}
+
+func TestNamespace_Create_Atomic(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	// Make the SADD fail by storing the wrong type at the registered key, the
+	// timer must not be armed if it can't also be registered.
+	require.NoError(t, c.r.Set(ctx, ns.registeredKey(), "oops", 0).Err())
+	assert.Error(t, ns.Create(ctx, "foo", time.Minute))
+	ns.assertKeysLen(t, 0)
+
+	require.NoError(t, c.r.Del(ctx, ns.registeredKey()).Err())
+	assert.NoError(t, ns.Create(ctx, "foo", time.Minute))
+	ns.assertKeysLen(t, 1)
+	ns.assertRegisteredLen(t, 1)
+}
+
+func TestNamespace_Subscribe(t *testing.T) {
+	c, stop := client(t)
+	defer stop()
+
+	ns := c.Namespace("foo")
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	fired, err := ns.Subscribe(ctx)
+	require.NoError(t, err)
+
+	assert.NoError(t, ns.Create(ctx, "foo", time.Second))
+	time.Sleep(2 * time.Second)
+	assert.NoError(t, ns.Poll(ctx))
+
+	select {
+	case key := <-fired:
+		assert.Equal(t, "foo", key)
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for fired timer")
+	}
+
+	// The timer is still queued for reliable consumers
+	ns.assertQueueLen(t, 1)
+}
+
 func ExampleClient() {
 	c := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
@@ -90,8 +3637,38 @@ func ExampleClient() {
 }
 
 // client returns a new rimer client for testing and a function to stop the
-// redis container once we're done.
-func client(t *testing.T) (*Client, func()) {
+// redis server once we're done. The tests run against miniredis by default,
+// set RIMER_TEST_REDIS=container to run them against a real redis container.
+func client(t testing.TB) (*Client, func()) {
+	if os.Getenv("RIMER_TEST_REDIS") == "container" {
+		return containerClient(t)
+	}
+	m := miniredis.RunT(t)
+	// miniredis doesn't expire keys by itself, so we need to move its clock
+	// forward in real time.
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				m.FastForward(now.Sub(last))
+				last = now
+			}
+		}
+	}()
+	return New(redis.NewClient(&redis.Options{
+		Addr: m.Addr(),
+	})), func() { close(done) }
+}
+
+// containerClient returns a new rimer client for testing that's backed by a
+// redis container, and a function to stop the container once we're done.
+func containerClient(t testing.TB) (*Client, func()) {
 	ctx := context.Background()
 	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
 		ContainerRequest: testcontainers.ContainerRequest{
@@ -119,6 +3696,39 @@ func (n *Namespace) assertKeysLen(t *testing.T, len int) {
 	assert.Len(t, keys, len, "unexpected number of keys")
 }
 
+// assertForgotten asserts that no trace of the timer is left in any of the keys
+// in the namespace.
+func (n *Namespace) assertForgotten(t *testing.T, key string) {
+	keys, err := n.client.r.Keys(ctx, n.key("*")).Result()
+	require.NoError(t, err)
+	for _, k := range keys {
+		assert.NotEqual(t, n.timerKey(key), k)
+		typ, err := n.client.r.Type(ctx, k).Result()
+		require.NoError(t, err)
+		var found bool
+		switch typ {
+		case "hash":
+			found, err = n.client.r.HExists(ctx, k, key).Result()
+		case "set":
+			found, err = n.client.r.SIsMember(ctx, k, key).Result()
+		case "zset":
+			err = n.client.r.ZScore(ctx, k, key).Err()
+			found = err == nil
+			if err == redis.Nil {
+				err = nil
+			}
+		case "list":
+			var members []string
+			members, err = n.client.r.LRange(ctx, k, 0, -1).Result()
+			for _, m := range members {
+				found = found || m == key
+			}
+		}
+		require.NoError(t, err)
+		assert.False(t, found, "timer %s left in %s", key, k)
+	}
+}
+
 func (n *Namespace) assertQueueLen(t *testing.T, len int) {
 	count, err := n.client.r.LLen(ctx, n.queueKey()).Result()
 	require.NoError(t, err)
@@ -126,9 +3736,9 @@ func (n *Namespace) assertQueueLen(t *testing.T, len int) {
 }
 
 func (n *Namespace) assertRegisteredLen(t *testing.T, len int) {
-	count, err := n.client.r.SCard(ctx, n.registeredKey()).Result()
+	keys, err := n.registeredMembers(ctx)
 	require.NoError(t, err)
-	assert.Equal(t, int64(len), count, "unexpected registered length")
+	assert.Len(t, keys, len, "unexpected registered length")
 }
 
 func (n *Namespace) assertRegisteredTempLen(t *testing.T, len int) {
@@ -136,3 +3746,82 @@ func (n *Namespace) assertRegisteredTempLen(t *testing.T, len int) {
 	require.NoError(t, err)
 	assert.Len(t, keys, len, "unexpected number of registered temp keys")
 }
+
+func (n *Namespace) assertTTLBetween(t *testing.T, key string, min, max time.Duration) {
+	ttl, err := n.client.r.PTTL(ctx, n.timerKey(key)).Result()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, ttl, min, "unexpected ttl")
+	assert.LessOrEqual(t, ttl, max, "unexpected ttl")
+}
+
+func BenchmarkNamespace_Create(b *testing.B) {
+	c, stop := client(b)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := ns.Create(ctx, strconv.Itoa(i), time.Hour); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNamespace_CreateMany(b *testing.B) {
+	c, stop := client(b)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	timers := make(map[string]time.Duration, b.N)
+	for i := 0; i < b.N; i++ {
+		timers[strconv.Itoa(i)] = time.Hour
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	if err := ns.CreateMany(ctx, timers); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkNamespace_Poll(b *testing.B) {
+	for _, size := range []int{100, 1000, 10000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			c, stop := client(b)
+			defer stop()
+
+			ns := c.Namespace("foo")
+			timers := make(map[string]time.Duration, size)
+			for i := 0; i < size; i++ {
+				timers[strconv.Itoa(i)] = time.Hour
+			}
+			require.NoError(b, ns.CreateMany(ctx, timers))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := ns.Poll(ctx); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNamespace_Next(b *testing.B) {
+	c, stop := client(b)
+	defer stop()
+
+	ns := c.Namespace("foo")
+	keys := make([]any, b.N)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	require.NoError(b, c.r.LPush(ctx, ns.queueKey(), keys...).Err())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ns.Next(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
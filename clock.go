@@ -0,0 +1,65 @@
+package rimer
+
+import (
+	"context"
+	"time"
+)
+
+var defaultClockRefresh = time.Minute
+
+// CreateAt is like Create, but fires the timer at the given time instead of
+// after a duration. If SyncClock is set, the time is measured against the
+// Redis server's clock. Times that have already passed fire as soon as
+// possible.
+func (n *Namespace) CreateAt(ctx context.Context, key string, at time.Time) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	now, err := n.client.now(ctx)
+	if err != nil {
+		return err
+	}
+	duration := at.Sub(now)
+	if duration < time.Millisecond {
+		duration = time.Millisecond
+	}
+	_, err = n.create(ctx, key, duration, createParams{})
+	return err
+}
+
+// now returns the current time according to the Redis server if SyncClock is
+// set, and according to this machine otherwise.
+func (c *Client) now(ctx context.Context) (time.Time, error) {
+	if !c.SyncClock {
+		return time.Now(), nil
+	}
+	offset, err := c.clockSkew(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(offset), nil
+}
+
+// clockSkew returns how far the Redis server's clock is ahead of this
+// machine's, measuring it again if the cached offset is older than
+// ClockRefresh. The server's time is assumed to be read halfway through the
+// round trip.
+func (c *Client) clockSkew(ctx context.Context) (time.Duration, error) {
+	c.clockMu.Lock()
+	defer c.clockMu.Unlock()
+	refresh := c.ClockRefresh
+	if refresh <= 0 {
+		refresh = defaultClockRefresh
+	}
+	if !c.clockSyncedAt.IsZero() && time.Since(c.clockSyncedAt) < refresh {
+		return c.clockOffset, nil
+	}
+	start := time.Now()
+	server, err := c.r.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	end := time.Now()
+	c.clockOffset = server.Sub(start.Add(end.Sub(start) / 2))
+	c.clockSyncedAt = end
+	return c.clockOffset, nil
+}
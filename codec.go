@@ -0,0 +1,135 @@
+package rimer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// Codec transforms the values of timers, see CreateOptions, before they're
+// stored in Redis and after they're read back, for example to compress them.
+// Each stored value is marked with the name of the codec that encoded it, so
+// values that were stored with different codecs can be read side by side while
+// migrating from one codec to another, see Client.Codecs.
+type Codec interface {
+	// Name identifies the codec in the marker that's stored with each value.
+	// It must not be empty, must be at most 255 bytes long, and must never
+	// change once values were stored with it.
+	Name() string
+	// Encode transforms a value before it's stored.
+	Encode(value []byte) ([]byte, error)
+	// Decode reverses Encode.
+	Decode(data []byte) ([]byte, error)
+}
+
+// codecMagic starts every value that was stored with a codec. It's followed by
+// the length of the codec's name in a single byte, the name, and the encoded
+// value. Values stored without a codec are stored as they are, unless they
+// happen to start with codecMagic themselves, in which case they're marked
+// with an empty name so they aren't mistaken for encoded values.
+const codecMagic = "\x00rimer-codec\x00"
+
+// encodeValue encodes a value with the client's Codec and marks it with the
+// codec's name.
+func (c *Client) encodeValue(value []byte) ([]byte, error) {
+	if c.Codec == nil {
+		if !bytes.HasPrefix(value, []byte(codecMagic)) {
+			return value, nil
+		}
+		return markValue("", value), nil
+	}
+	name := c.Codec.Name()
+	if name == "" || len(name) > 255 {
+		return nil, fmt.Errorf("invalid codec name %q", name)
+	}
+	encoded, err := c.Codec.Encode(value)
+	if err != nil {
+		return nil, fmt.Errorf("encoding value with codec %s: %w", name, err)
+	}
+	return markValue(name, encoded), nil
+}
+
+// markValue prefixes data with the marker for the named codec.
+func markValue(name string, data []byte) []byte {
+	marked := make([]byte, 0, len(codecMagic)+1+len(name)+len(data))
+	marked = append(marked, codecMagic...)
+	marked = append(marked, byte(len(name)))
+	marked = append(marked, name...)
+	return append(marked, data...)
+}
+
+// decodeValue decodes a stored value with the codec that it's marked with,
+// which is either the client's Codec or one of its Codecs. It returns
+// ErrUnknownCodec if it's neither.
+func (c *Client) decodeValue(stored []byte) ([]byte, error) {
+	data, ok := bytes.CutPrefix(stored, []byte(codecMagic))
+	if !ok {
+		return stored, nil
+	}
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return nil, fmt.Errorf("%w: truncated marker", ErrUnknownCodec)
+	}
+	name, data := string(data[1:1+int(data[0])]), data[1+int(data[0]):]
+	if name == "" {
+		return data, nil
+	}
+	for _, codec := range append([]Codec{c.Codec}, c.Codecs...) {
+		if codec == nil || codec.Name() != name {
+			continue
+		}
+		value, err := codec.Decode(data)
+		if err != nil {
+			return nil, fmt.Errorf("decoding value with codec %s: %w", name, err)
+		}
+		return value, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, name)
+}
+
+// GzipCodec is a Codec that compresses values with gzip, which saves Redis
+// memory for large, compressible values at the cost of some CPU time. Other
+// compression algorithms, such as snappy or zstd, can be plugged in by
+// implementing Codec.
+type GzipCodec struct {
+	// Level is the compression level, see compress/gzip. Zero means
+	// gzip.DefaultCompression rather than gzip.NoCompression, which has the
+	// same value, so storing values uncompressed isn't possible with GzipCodec.
+	// Leave the client's Codec unset for that instead.
+	Level int
+}
+
+// Name returns "gzip".
+func (GzipCodec) Name() string {
+	return "gzip"
+}
+
+// Encode compresses the value.
+func (g GzipCodec) Encode(value []byte) ([]byte, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(value); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decompresses the value.
+func (GzipCodec) Decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,217 @@
+package rimer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// consumeTimeout is how long Consume waits for a timer at a time. Blocking
+	// commands aren't interrupted when their context is cancelled, so this is
+	// how long Consume can take to notice that it should stop.
+	consumeTimeout = time.Second
+
+	// consumeErrorBackoff is how long Consume waits before taking the next
+	// timer after Next fails.
+	consumeErrorBackoff = time.Second
+)
+
+// ConsumeOptions configures Consume.
+type ConsumeOptions struct {
+	// MaxInFlight is the most handlers that run at the same time. Consume only
+	// takes a timer from the queue when fewer handlers are running, so a burst
+	// of fired timers stays in the queue for other consumers instead of piling
+	// up in this one. Defaults to 1, which handles timers one at a time.
+	MaxInFlight int
+
+	// OnError is called with every error returned by Next, after which Consume
+	// waits a second and carries on. If it's nil, Consume stops and returns the
+	// first error instead. Errors that are returned along with a timer, such as
+	// ErrPayloadMissing, don't make Consume wait, and the timer is still given
+	// to the handler.
+	OnError func(err error)
+}
+
+// Consume takes fired timers from the queue and calls handler with each of
+// them in its own goroutine, running at most MaxInFlight handlers at a time,
+// until the context is cancelled. It notices within a second, and then waits
+// for the running handlers to return before returning the context's error.
+// The handlers are given the context passed to Consume, so they should stop
+// early once it's cancelled.
+//
+// A timer that's returned by Next along with an error, such as
+// ErrPayloadMissing, has already been taken from the queue, so it's handled
+// like any other timer rather than lost, and the error is passed to OnError.
+//
+// Consume returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) Consume(ctx context.Context, opts ConsumeOptions, handler func(ctx context.Context, t FiredTimer)) error {
+	if n.Queue != QueueList {
+		return ErrQueueMismatch
+	}
+	limit := opts.MaxInFlight
+	if limit <= 0 {
+		limit = 1
+	}
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case slots <- struct{}{}:
+		}
+		t, err := n.NextTimerWithTimeout(ctx, consumeTimeout)
+		if err != nil && t.Key == "" {
+			<-slots
+			if err == ErrNoTimers {
+				continue
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if opts.OnError == nil {
+				return err
+			}
+			opts.OnError(err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(consumeErrorBackoff):
+			}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			handler(ctx, t)
+		}()
+		if err != nil {
+			if opts.OnError == nil {
+				return err
+			}
+			opts.OnError(err)
+		}
+	}
+}
+
+// BatchError is returned by a ConsumeBatch handler that handled some of the
+// timers in the batch but not all of them. Only the Failed timers are moved to
+// the dead letter queue.
+type BatchError struct {
+	// Failed are the keys of the timers that couldn't be handled.
+	Failed []string
+	// Err is the reason that they failed.
+	Err error
+}
+
+// Error returns the reason that the timers failed.
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d timers failed: %v", len(e.Failed), e.Err)
+}
+
+// Unwrap returns the reason that the timers failed.
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// ConsumeBatch takes fired timers from the queue in batches of up to max and
+// calls handler with the keys of each batch, until the context is cancelled.
+// It waits for the first timer of a batch, then takes whatever else is already
+// waiting, so a batch is only full when the queue is backed up. This suits
+// handlers whose work is cheaper in bulk, such as a single write to a database
+// for many timers.
+//
+// Taking a timer from the queue acknowledges it, so a batch is done when the
+// handler returns nil. If it returns an error, the timers in the batch are
+// moved to the dead letter queue, see DeadLetter, where they can be replayed
+// later, and ConsumeBatch carries on. A handler that handled part of the batch
+// returns a *BatchError with the keys of the timers that failed, and only those
+// are dead lettered. ConsumeBatch returns the first error from taking timers
+// or dead lettering them, and the context's error once it's cancelled. A timer
+// that's returned by Next along with an error, such as ErrPayloadMissing, has
+// already been taken from the queue, so it's handled as part of the batch
+// before the error is returned.
+//
+// ConsumeBatch returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) ConsumeBatch(ctx context.Context, max int, handler func(keys []string) error) error {
+	if n.Queue != QueueList {
+		return ErrQueueMismatch
+	}
+	if max <= 0 {
+		max = 1
+	}
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		t, err := n.NextTimerWithTimeout(ctx, consumeTimeout)
+		if err == ErrNoTimers {
+			continue
+		}
+		if err != nil && t.Key == "" {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		batch := []FiredTimer{t}
+		for err == nil && len(batch) < max {
+			t, err = n.nextTimer(ctx, -1)
+			if t.Key != "" {
+				batch = append(batch, t)
+			}
+		}
+		if err != nil && err != ErrNoTimers && ctx.Err() == nil {
+			// The timers that were already taken are handled before the
+			// error is returned, so they aren't lost.
+			return errors.Join(n.handleBatch(batch, handler), err)
+		}
+		if err = n.handleBatch(batch, handler); err != nil {
+			return err
+		}
+	}
+}
+
+// handleBatch calls a ConsumeBatch handler with the given timers, and moves the
+// ones that failed to the dead letter queue. The timers have already been
+// taken from the queue, so this uses a fresh context that isn't cancelled along
+// with ConsumeBatch's.
+func (n *Namespace) handleBatch(batch []FiredTimer, handler func(keys []string) error) error {
+	keys := make([]string, len(batch))
+	for i, t := range batch {
+		keys[i] = t.Key
+	}
+	err := handler(keys)
+	if err == nil {
+		return nil
+	}
+	failed := batch
+	var berr *BatchError
+	if errors.As(err, &berr) {
+		keys := make(map[string]bool, len(berr.Failed))
+		for _, key := range berr.Failed {
+			keys[key] = true
+		}
+		failed = nil
+		for _, t := range batch {
+			if keys[t.Key] {
+				failed = append(failed, t)
+			}
+		}
+	}
+	ctx, cancel := n.client.withDefaultTimeout(context.Background())
+	defer cancel()
+	for _, t := range failed {
+		if err := n.DeadLetter(ctx, t); err != nil {
+			return fmt.Errorf("dead lettering timer %s: %w", t.Key, err)
+		}
+	}
+	return nil
+}
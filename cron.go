@@ -0,0 +1,244 @@
+package rimer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronPrefix marks the cron timers in the recurring hash, which holds the
+// interval in milliseconds of the other recurring timers.
+const cronPrefix = "cron:"
+
+// cronHorizon is how far ahead the next occurrence of a cron expression is
+// searched for, expressions that don't match within it never fire.
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+// cronDescriptors are the shorthands for common cron expressions.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	cronMonths = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	cronDays   = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// cronSchedule is a parsed cron expression, with a bit set for every minute,
+// hour, day of the month, month and day of the week that it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are true if the day of the month or the day of the
+	// week is unrestricted, see matchesDay.
+	domStar, dowStar bool
+}
+
+// parseCron parses a standard five field cron expression, with the minute, the
+// hour, the day of the month, the month and the day of the week, or one of the
+// cronDescriptors.
+func parseCron(expr string) (cronSchedule, error) {
+	var s cronSchedule
+	spec := strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return s, fmt.Errorf("%w %q: expected 5 fields, got %d", ErrInvalidCron, expr, len(fields))
+	}
+	var err error
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+		names    map[string]int
+	}{
+		{&s.minute, 0, 59, nil},
+		{&s.hour, 0, 23, nil},
+		{&s.dom, 1, 31, nil},
+		{&s.month, 1, 12, cronMonths},
+		{&s.dow, 0, 7, cronDays},
+	} {
+		*f.bits, err = parseCronField(fields[i], f.min, f.max, f.names)
+		if err != nil {
+			return s, fmt.Errorf("%w %q: %s", ErrInvalidCron, expr, err)
+		}
+	}
+	// Sunday is both 0 and 7.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = strings.HasPrefix(fields[2], "*")
+	s.dowStar = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseCronField parses a single field of a cron expression, which is a comma
+// separated list of values, ranges and "*", each optionally followed by a step,
+// and returns a bit set of the values that it matches.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step, hasStep := strings.Cut(part, "/")
+		var lo, hi int
+		if rng == "*" {
+			lo, hi = min, max
+		} else {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseCronValue(from, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseCronValue(to, min, max, names); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		}
+		inc := 1
+		if hasStep {
+			var err error
+			inc, err = strconv.Atoi(step)
+			if err != nil || inc <= 0 {
+				return 0, fmt.Errorf("invalid step %q", step)
+			}
+		}
+		for v := lo; v <= hi; v += inc {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a single value of a cron field, which is either a
+// number between min and max, or one of the names.
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, min, max)
+	}
+	return v, nil
+}
+
+// matchesDay returns whether the schedule matches the given day. Like cron, if
+// both the day of the month and the day of the week are restricted, a day that
+// matches either of them matches.
+func (s cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after the given time that the schedule matches,
+// in UTC, or the zero time if it doesn't match within cronHorizon.
+func (s cronSchedule) next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronHorizon)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// nextCron returns the next time after now that the cron expression fires.
+func nextCron(expr string) (time.Time, error) {
+	s, err := parseCron(expr)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := s.next(time.Now())
+	if next.IsZero() {
+		return next, fmt.Errorf("%w %q: never fires", ErrInvalidCron, expr)
+	}
+	return next, nil
+}
+
+// CreateCron creates a recurring timer that fires on the schedule of a
+// standard five field cron expression, such as "*/15 9-17 * * mon-fri", with
+// the minute, the hour, the day of the month, the month and the day of the
+// week, or one of the shorthands "@hourly", "@daily", "@weekly", "@monthly"
+// and "@yearly". The expression is evaluated in UTC, so every process agrees
+// on when the timer fires. Malformed expressions, and expressions that never
+// fire, are rejected with ErrInvalidCron.
+//
+// The expression is stored with the timer, and each time the timer is
+// consumed, it's re-armed to fire at the next occurrence after that. Like
+// CreateRecurring, an occurrence that passes while the timer is waiting to be
+// consumed is skipped. Unlike interval timers, which are re-armed atomically
+// as they're consumed, cron timers are re-armed right after, so a consumer
+// that crashes in between loses the schedule until the timer is created
+// again. Calling Create with the same key turns it back into a one-shot timer.
+func (n *Namespace) CreateCron(ctx context.Context, key, expr string) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	next, err := nextCron(expr)
+	if err != nil {
+		return err
+	}
+	_, err = n.create(ctx, key, time.Until(next), createParams{cron: strings.TrimSpace(expr), unscaled: true})
+	return err
+}
+
+// armCronScript re-arms the KEYS[1] cron timer to fire in ARGV[3] milliseconds,
+// and registers it in KEYS[2], but only if it's still a cron timer with the
+// ARGV[2] record in the KEYS[3] recurring hash and it hasn't been re-created in
+// the meantime. Returns whether the timer was re-armed.
+var armCronScript = newNamespaceScript(`
+if redis.call('HGET', KEYS[3], ARGV[1]) ~= ARGV[2] or redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+redis.call('SET', KEYS[1], '', 'PX', ARGV[3])
+register(KEYS[2], ARGV[1], ARGV[3])
+return 1
+`)
+
+// armCron re-arms the consumed cron timer with the given record from the
+// recurring hash for the next occurrence of its expression, and returns when
+// that is.
+func (n *Namespace) armCron(ctx context.Context, key, record string) (time.Time, error) {
+	next, err := nextCron(strings.TrimPrefix(record, cronPrefix))
+	if err != nil {
+		return next, err
+	}
+	ms, err := durationMs(time.Until(next))
+	if err != nil {
+		return next, err
+	}
+	err = n.runScript(ctx, armCronScript,
+		[]string{n.timerKey(key), n.registeredKeyFor(key), n.recurringKey()},
+		key, record, ms).Err()
+	return next, err
+}
@@ -0,0 +1,119 @@
+package rimer
+
+import (
+	"context"
+	"time"
+)
+
+// defaultMaxReplays is the default for Namespace.MaxReplays.
+const defaultMaxReplays = 3
+
+// deadLetterScript pushes the ARGV[1] timer onto the KEYS[1] dead letter queue,
+// and records the ARGV[2] number of times that it was replayed in the KEYS[2]
+// hash.
+var deadLetterScript = newNamespaceScript(`
+redis.call('LPUSH', KEYS[1], ARGV[1])
+touch(KEYS[1])
+if ARGV[2] == '0' then
+	redis.call('HDEL', KEYS[2], ARGV[1])
+else
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+end
+return 1
+`)
+
+// replayScript moves the ARGV[1] timer out of the KEYS[1] dead letter queue,
+// unless it has already been replayed ARGV[2] times according to the KEYS[2]
+// hash, and records the replay in the KEYS[3] replays hash. If ARGV[3] is
+// empty, the timer is fired straight onto the KEYS[4] queue with the next
+// fencing token from the KEYS[7] counter, which is stored in the KEYS[8] hash.
+// Otherwise it's re-armed at KEYS[5] and registered in KEYS[6] to fire in
+// ARGV[3] milliseconds. Returns whether the timer was replayed.
+var replayScript = newNamespaceScript(`
+local count = tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or 0)
+if count >= tonumber(ARGV[2]) then
+	return 0
+end
+if redis.call('LREM', KEYS[1], -1, ARGV[1]) == 0 then
+	return 0
+end
+redis.call('HDEL', KEYS[2], ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], count + 1)
+if ARGV[3] == '' then
+	local token = redis.call('INCR', KEYS[7])
+	redis.call('HSET', KEYS[8], ARGV[1], token)
+	redis.call('LPUSH', KEYS[4], ARGV[1])
+	touch(KEYS[4])
+else
+	redis.call('SET', KEYS[5], '', 'PX', ARGV[3])
+	register(KEYS[6], ARGV[1], ARGV[3])
+end
+return 1
+`)
+
+// DeadLetter moves a timer that was consumed but couldn't be handled onto the
+// namespace's dead letter queue, where it stays until it's replayed with
+// ReplayDeadLetters, for example once the bug in the handler is fixed. Only
+// the timer's key and the number of times it was already replayed are kept,
+// its value, fields and other data were removed when it was consumed.
+//
+// DeadLetter returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) DeadLetter(ctx context.Context, t FiredTimer) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList {
+		return ErrQueueMismatch
+	}
+	return n.runScript(ctx, deadLetterScript,
+		[]string{n.deadLettersKey(), n.deadLetteredKey()},
+		t.Key, t.Replays).Err()
+}
+
+// ReplayDeadLetters moves up to max of the oldest timers in the dead letter
+// queue back into the namespace, and returns how many were moved. If delay is
+// zero, the timers are pushed straight onto the queue as if they had just
+// fired, otherwise they're re-armed to fire once delay has passed. Each timer
+// can only be replayed MaxReplays times, so a timer that keeps failing is
+// eventually left in the dead letter queue for good. Timers that have used up
+// their replays don't count towards max. The number of times a timer was
+// replayed is reported by FiredTimer.Replays, and must be passed back to
+// DeadLetter along with the timer.
+//
+// ReplayDeadLetters returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) ReplayDeadLetters(ctx context.Context, max int, delay time.Duration) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList {
+		return 0, ErrQueueMismatch
+	}
+	var ms any = ""
+	if delay != 0 {
+		var err error
+		if ms, err = durationMs(delay); err != nil {
+			return 0, err
+		}
+	}
+	maxReplays := n.MaxReplays
+	if maxReplays <= 0 {
+		maxReplays = defaultMaxReplays
+	}
+	keys, err := n.client.r.LRange(ctx, n.deadLettersKey(), 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	// The oldest timers are at the end of the list.
+	for i := len(keys) - 1; i >= 0 && replayed < max; i-- {
+		k := keys[i]
+		ok, err := n.runScript(ctx, replayScript,
+			[]string{n.deadLettersKey(), n.deadLetteredKey(), n.replaysKey(), n.queueKeyFor(k), n.timerKey(k), n.registeredKeyFor(k), n.tokenKey(), n.tokensKey()},
+			k, maxReplays, ms).Bool()
+		if err != nil {
+			return replayed, err
+		}
+		if ok {
+			replayed++
+		}
+	}
+	return replayed, nil
+}
@@ -0,0 +1,17 @@
+package rimer
+
+import (
+	"context"
+	"time"
+)
+
+// CreateDedup is like Create, but refuses to create the timer if a timer with
+// the same key was created with CreateDedup within the last dedupWindow, even
+// if that timer has already fired. This protects against producers that may
+// deliver the same trigger more than once. It returns whether a new timer was
+// actually created.
+func (n *Namespace) CreateDedup(ctx context.Context, key string, duration, dedupWindow time.Duration) (bool, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	return n.create(ctx, key, duration, createParams{dedupWindow: dedupWindow})
+}
@@ -0,0 +1,92 @@
+package rimer
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// Diag is a snapshot of the client's connection to Redis, see Diagnostics.
+type Diag struct {
+	// PingRTT is the round trip time of a PING command.
+	PingRTT time.Duration
+	// Keys is the number of keys under the client's prefix, in every namespace.
+	Keys int64
+	// DBSize is the number of keys in the whole Redis database, as reported by
+	// the server.
+	DBSize int64
+	// Pool is the state of the underlying go-redis connection pool.
+	Pool *redis.PoolStats
+}
+
+// Diagnostics returns a snapshot of the client's connection to Redis, which
+// helps troubleshooting when polling or consuming timers is slow. Counting
+// the keys under the client's prefix scans the whole keyspace, so this
+// shouldn't be called frequently on large databases.
+func (c *Client) Diagnostics(ctx context.Context) (Diag, error) {
+	ctx, cancel := c.withDefaultTimeout(ctx)
+	defer cancel()
+	d := Diag{Pool: c.r.PoolStats()}
+	start := time.Now()
+	err := c.r.Ping(ctx).Err()
+	if err != nil {
+		return d, err
+	}
+	d.PingRTT = time.Since(start)
+	d.DBSize, err = c.r.DBSize(ctx).Result()
+	if err != nil {
+		return d, err
+	}
+	iter := c.r.Scan(ctx, 0, c.KeyBuilder.Join(c.Prefix, "*"), 1000).Iterator()
+	for iter.Next(ctx) {
+		d.Keys++
+	}
+	return d, iter.Err()
+}
+
+// memoryUsageBatchSize is the number of keys that MemoryUsage scans, and
+// measures with a single pipeline, at a time.
+const memoryUsageBatchSize = 1000
+
+// MemoryUsage returns an estimate of the number of bytes of Redis memory used
+// by the keys of this namespace, including its timers, queues, companion
+// hashes and indexes, using the MEMORY USAGE command. Redis only samples the
+// elements of large collections, so the result is an estimate for namespaces
+// with many timers. Like Diagnostics, this scans the whole keyspace.
+func (n *Namespace) MemoryUsage(ctx context.Context) (int64, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	var total int64
+	var keys []string
+	measure := func() error {
+		cmds := make([]*redis.IntCmd, len(keys))
+		_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for i, k := range keys {
+				cmds[i] = p.MemoryUsage(ctx, k)
+			}
+			return nil
+		})
+		keys = keys[:0]
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			// Keys that were deleted since they were scanned don't use any.
+			total += cmd.Val()
+		}
+		return nil
+	}
+	iter := n.client.r.Scan(ctx, 0, n.key("*"), memoryUsageBatchSize).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+		if len(keys) == memoryUsageBatchSize {
+			if err := measure(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return total, err
+	}
+	return total, measure()
+}
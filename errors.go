@@ -0,0 +1,91 @@
+package rimer
+
+import "errors"
+
+var (
+	// ErrPollTimeout is returned by Poll when one of its Redis commands takes
+	// longer than the namespace's PollTimeout.
+	ErrPollTimeout = errors.New("rimer: poll operation timed out")
+
+	// ErrPollSkipped is returned by Poll when another poller holds the
+	// namespace's poll lock, see PollLock.
+	ErrPollSkipped = errors.New("rimer: poll skipped, another poller holds the lock")
+
+	// ErrReadOnly is returned when Redis refuses a write because the client is
+	// connected to a read-only replica, which also happens while a failover is
+	// promoting a new primary. Methods that only read, like Describe, List and
+	// Remaining, keep working against a replica, so callers can keep serving
+	// queries and back off writing until the failover completes.
+	ErrReadOnly = errors.New("rimer: redis is read-only")
+
+	// ErrSchemaVersion is returned when a namespace was written by a newer
+	// version of rimer with a schema that this version doesn't understand.
+	ErrSchemaVersion = errors.New("rimer: unsupported schema version")
+
+	// ErrRepresentation is returned when a namespace is used with a different
+	// representation than the one it was created with.
+	ErrRepresentation = errors.New("rimer: representation mismatch")
+
+	// ErrInvalidDuration is returned when a timer is given a duration that
+	// isn't positive.
+	ErrInvalidDuration = errors.New("rimer: duration must be positive")
+
+	// ErrDurationTooLong is returned when a timer is created with a duration
+	// longer than its namespace's MaxDuration.
+	ErrDurationTooLong = errors.New("rimer: duration exceeds the namespace's maximum")
+
+	// ErrAliasCycle is returned by AliasNamespace when the alias would end up
+	// pointing at itself.
+	ErrAliasCycle = errors.New("rimer: namespace alias cycle")
+
+	// ErrUnknownCodec is returned when a timer's value was stored with a
+	// codec that the client doesn't have, see Client.Codecs.
+	ErrUnknownCodec = errors.New("rimer: value was stored with an unknown codec")
+
+	// ErrQueueMismatch is returned when consuming timers from a namespace in a
+	// way that its Queue doesn't support.
+	ErrQueueMismatch = errors.New("rimer: operation not supported by queue")
+
+	// ErrInvalidNamespace is returned by NamespaceChecked when a namespace name
+	// isn't safe to use in Redis keys.
+	ErrInvalidNamespace = errors.New("rimer: invalid namespace")
+
+	// ErrNoTimers is returned by the methods that don't block waiting for a
+	// timer, like NextWithTimeout and Peek, when there are no timers available.
+	// It's returned in place of redis.Nil, so callers never have to check for
+	// both.
+	ErrNoTimers = errors.New("rimer: no timers available")
+
+	// ErrTimerExists is returned when creating a timer with
+	// CreateOptions.OnlyIfAbsent and a timer with the same key already exists.
+	ErrTimerExists = errors.New("rimer: timer already exists")
+
+	// ErrInvalidCron is returned by CreateCron when the cron expression is
+	// malformed or never fires.
+	ErrInvalidCron = errors.New("rimer: invalid cron expression")
+
+	// ErrVersionConflict is returned when creating a timer with
+	// CreateOptions.ExpectedVersion and the timer has a different version.
+	ErrVersionConflict = errors.New("rimer: timer version conflict")
+
+	// ErrPayloadMissing is returned along with a consumed timer when the timer
+	// was created with a value, but the value no longer exists, see
+	// IgnoreMissingValues.
+	ErrPayloadMissing = errors.New("rimer: timer value is missing")
+
+	// ErrTimerNotFound is returned when changing a timer that doesn't exist.
+	ErrTimerNotFound = errors.New("rimer: timer not found")
+
+	// ErrNotRecurring is returned by RescheduleRecurring when the timer isn't
+	// a recurring timer created with CreateRecurring.
+	ErrNotRecurring = errors.New("rimer: timer is not recurring")
+
+	// ErrClaimNotFound is returned by Complete when the claim doesn't exist,
+	// because it was already completed or it expired and its timer was
+	// returned to the queue.
+	ErrClaimNotFound = errors.New("rimer: claim not found")
+
+	// ErrDependencyCycle is returned by CreateAfter when the timer that the
+	// new timer would depend on already depends on it.
+	ErrDependencyCycle = errors.New("rimer: dependency cycle")
+)
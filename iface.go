@@ -0,0 +1,22 @@
+package rimer
+
+import (
+	"context"
+	"time"
+)
+
+// TimerNamespace is the set of Namespace methods that most code built on top of
+// rimer needs, so that it can depend on an interface and be tested with a fake
+// instead of Redis. Methods are only ever added to it in a new major version.
+type TimerNamespace interface {
+	Create(ctx context.Context, key string, duration time.Duration) error
+	CreateRecurring(ctx context.Context, key string, interval time.Duration) error
+	Upsert(ctx context.Context, key string, duration time.Duration) error
+	Cancel(ctx context.Context, key string) (bool, error)
+	Poll(ctx context.Context) error
+	Next(ctx context.Context) (string, error)
+	NextTimer(ctx context.Context) (FiredTimer, error)
+	NextWithTimeout(ctx context.Context, timeout time.Duration) (string, error)
+}
+
+var _ TimerNamespace = (*Namespace)(nil)
@@ -0,0 +1,510 @@
+package rimer
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Histogram counts the pending timers in this namespace by how much time they
+// have remaining. The buckets are upper bounds in ascending order, and a timer
+// is counted in the first bucket that its remaining time fits into. The returned
+// slice has one more element than buckets, the last element counts the timers
+// that fire later than the last bucket.
+//
+// Timers that have already expired but haven't been polled yet are counted as
+// having no time remaining.
+func (n *Namespace) Histogram(ctx context.Context, buckets []time.Duration) ([]int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if !sort.SliceIsSorted(buckets, func(i, j int) bool { return buckets[i] < buckets[j] }) {
+		return nil, fmt.Errorf("buckets must be in ascending order")
+	}
+	keys, err := n.registeredMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	remaining, err := n.remaining(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]int, len(buckets)+1)
+	for _, r := range remaining {
+		i := sort.Search(len(buckets), func(i int) bool { return r <= buckets[i] })
+		counts[i]++
+	}
+	return counts, nil
+}
+
+// NextFireTime returns the time that the next timer in this namespace fires,
+// and false if there are no pending timers. Timers that have already expired
+// but haven't been polled yet are overdue, and with RepresentationSortedSet
+// their original fire time is returned, while with the other representations
+// only the current time is known.
+func (n *Namespace) NextFireTime(ctx context.Context) (time.Time, bool, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Representation == RepresentationSortedSet {
+		zs, err := n.client.r.ZRangeWithScores(ctx, n.scheduledKey(), 0, 0).Result()
+		if err != nil || len(zs) == 0 || math.IsInf(zs[0].Score, 1) {
+			return time.Time{}, false, err
+		}
+		return time.UnixMilli(int64(zs[0].Score)), true, nil
+	}
+	keys, err := n.registeredMembers(ctx)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	remaining, err := n.remaining(ctx, keys)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	next := time.Duration(math.MaxInt64)
+	for _, r := range remaining {
+		if r < next {
+			next = r
+		}
+	}
+	if next == math.MaxInt64 {
+		return time.Time{}, false, nil
+	}
+	return time.Now().Add(next), true, nil
+}
+
+// QueueLength returns the number of fired timers that are waiting to be
+// consumed, across all of the namespace's queue shards. With QueueStream it's
+// the length of the stream, which includes the entries that were already read
+// by a consumer group, until the stream is trimmed, see StreamMaxLen.
+func (n *Namespace) QueueLength(ctx context.Context) (int64, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue == QueueStream {
+		return n.client.r.XLen(ctx, n.streamKey()).Result()
+	}
+	var cmds []*redis.IntCmd
+	_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range n.queueKeys() {
+			cmds = append(cmds, p.LLen(ctx, k))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, cmd := range cmds {
+		total += cmd.Val()
+	}
+	return total, nil
+}
+
+// TimerInfo describes a pending timer, see Describe and List.
+type TimerInfo struct {
+	// Key is the key that the timer was created with.
+	Key string
+	// Label is the label that the timer was created with, see CreateOptions.
+	Label string
+	// Remaining is the time until the timer fires. It's zero for timers that
+	// have expired but haven't been polled yet.
+	Remaining time.Duration
+	// Recurring is true if the timer was created with CreateRecurring.
+	Recurring bool
+	// Version is the timer's version, see CreateOptions.ExpectedVersion.
+	Version int64
+}
+
+// Describe returns the details of the pending timer with the given key, and
+// false if there is no such timer. Timers that have fired and are waiting in
+// the queue are no longer pending.
+func (n *Namespace) Describe(ctx context.Context, key string) (TimerInfo, bool, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	var registered bool
+	var err error
+	if n.Representation == RepresentationSortedSet {
+		err = n.client.r.ZScore(ctx, n.scheduledKey(), key).Err()
+		registered = err == nil
+		if err == redis.Nil {
+			err = nil
+		}
+	} else {
+		registered, err = n.client.r.SIsMember(ctx, n.registeredKeyFor(key), key).Result()
+	}
+	if err != nil || !registered {
+		return TimerInfo{}, false, err
+	}
+	infos, err := n.describe(ctx, []string{key})
+	if err != nil {
+		return TimerInfo{}, false, err
+	}
+	return infos[0], true, nil
+}
+
+// List returns the details of every pending timer in this namespace, in no
+// particular order.
+func (n *Namespace) List(ctx context.Context) ([]TimerInfo, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	keys, err := n.registeredMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return n.describe(ctx, keys)
+}
+
+// describe returns the details of the given timers.
+func (n *Namespace) describe(ctx context.Context, keys []string) ([]TimerInfo, error) {
+	remaining, err := n.remaining(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	labels := make([]*redis.StringCmd, len(keys))
+	recurring := make([]*redis.BoolCmd, len(keys))
+	versions := make([]*redis.StringCmd, len(keys))
+	_, err = n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			labels[i] = p.HGet(ctx, n.labelsKey(), k)
+			recurring[i] = p.HExists(ctx, n.recurringKey(), k)
+			versions[i] = p.HGet(ctx, n.versionsKey(), k)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	infos := make([]TimerInfo, len(keys))
+	for i, k := range keys {
+		version, _ := versions[i].Int64()
+		infos[i] = TimerInfo{
+			Key:       k,
+			Label:     labels[i].Val(),
+			Remaining: remaining[i],
+			Recurring: recurring[i].Val(),
+			Version:   version,
+		}
+	}
+	return infos, nil
+}
+
+// TimerState is the state of a timer, see ListWithRemaining.
+type TimerState int
+
+const (
+	// TimerArmed is a timer that hasn't expired yet.
+	TimerArmed TimerState = iota
+	// TimerExpired is a timer that has expired but hasn't been polled yet.
+	TimerExpired
+	// TimerQueued is a timer that has fired and is waiting in the queue to be
+	// consumed.
+	TimerQueued
+)
+
+// String returns the name of the state.
+func (s TimerState) String() string {
+	switch s {
+	case TimerArmed:
+		return "armed"
+	case TimerExpired:
+		return "expired"
+	case TimerQueued:
+		return "queued"
+	default:
+		return "TimerState(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// TimerStatus is the state of a timer and the time until it fires, see
+// ListWithRemaining and Upcoming.
+type TimerStatus struct {
+	// Key is the key that the timer was created with.
+	Key string
+	// State is the state of the timer.
+	State TimerState
+	// Remaining is the time until an armed timer fires, or the maximum
+	// duration if it never expires. It's zero for timers in any other state.
+	Remaining time.Duration
+	// Label is the label that the timer was created with, see CreateOptions.
+	// It's empty for timers that are waiting in the queue.
+	Label string
+}
+
+// ListWithRemaining returns the state of every timer in this namespace that's
+// armed, waiting to be polled or waiting in the queue, along with the time
+// until it fires, in no particular order. It's meant to back admin views, and
+// only takes two pipelined round trips no matter how many timers there are: one
+// to read the registered timers and the queue, and one to read the remaining
+// time and label of each registered timer. A timer that was created again
+// after it fired is listed twice, once as queued and once as armed.
+func (n *Namespace) ListWithRemaining(ctx context.Context) ([]TimerStatus, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	registeredKeys := n.registeredKeys()
+	members := make([]*redis.StringSliceCmd, len(registeredKeys))
+	var queued []*redis.StringSliceCmd
+	_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range registeredKeys {
+			if n.Representation == RepresentationSortedSet {
+				members[i] = p.ZRange(ctx, k, 0, -1)
+			} else {
+				members[i] = p.SMembers(ctx, k)
+			}
+		}
+		if n.Queue == QueueList {
+			for _, queue := range n.queueKeys() {
+				queued = append(queued, p.LRange(ctx, queue, 0, -1))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, cmd := range members {
+		keys = append(keys, cmd.Val()...)
+	}
+	statuses, err := n.statuses(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmd := range queued {
+		for _, k := range cmd.Val() {
+			statuses = append(statuses, TimerStatus{Key: k, State: TimerQueued})
+		}
+	}
+	return statuses, nil
+}
+
+// Upcoming returns the state of the limit registered timers that are due to
+// fire soonest, soonest first, along with the time until they fire and their
+// labels. Timers that have expired but haven't been polled yet come first,
+// while timers that are already waiting in the queue aren't listed. With
+// RepresentationSortedSet the timers are read from the sorted set in order,
+// while the other representations look up the remaining time of every
+// registered timer to sort them.
+func (n *Namespace) Upcoming(ctx context.Context, limit int) ([]TimerStatus, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if limit <= 0 {
+		return nil, nil
+	}
+	keys, err := n.soonest(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+	return n.statuses(ctx, keys)
+}
+
+// statuses returns the state, remaining time and label of the given registered
+// timers using a single pipeline.
+func (n *Namespace) statuses(ctx context.Context, keys []string) ([]TimerStatus, error) {
+	pttls := make([]*redis.DurationCmd, len(keys))
+	labels := make([]*redis.StringCmd, len(keys))
+	_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			pttls[i] = p.PTTL(ctx, n.timerKey(k))
+			labels[i] = p.HGet(ctx, n.labelsKey(), k)
+		}
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return nil, err
+	}
+	statuses := make([]TimerStatus, 0, len(keys))
+	for i, cmd := range pttls {
+		status := TimerStatus{Key: keys[i], Label: labels[i].Val()}
+		switch d := cmd.Val(); {
+		case d == -1:
+			status.Remaining = time.Duration(math.MaxInt64)
+		case d < 0:
+			status.State = TimerExpired
+		default:
+			status.Remaining = d
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// QueueContents returns the keys of up to limit timers that are waiting in the
+// queue, without consuming them, in the order that they fired, so the timers
+// that have been stuck the longest come first. The limit is required so that a
+// badly backed up queue can't be read in full by accident. With QueueShards,
+// the shards are listed one after another.
+//
+// QueueContents returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) QueueContents(ctx context.Context, limit int) ([]string, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList {
+		return nil, ErrQueueMismatch
+	}
+	if limit <= 0 {
+		return nil, fmt.Errorf("limit must be positive")
+	}
+	var keys []string
+	for _, queue := range n.queueKeys() {
+		// Timers are pushed onto the front of the queue as they fire, so the
+		// earliest ones are at the end.
+		queued, err := n.client.r.LRange(ctx, queue, -int64(limit-len(keys)), -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i := len(queued) - 1; i >= 0; i-- {
+			keys = append(keys, queued[i])
+		}
+		if len(keys) == limit {
+			break
+		}
+	}
+	return keys, nil
+}
+
+// IsQueued reports whether the timer with the given key has fired and is
+// waiting in the queue to be consumed, as opposed to being armed, waiting to
+// be polled, or already consumed. It looks the timer up in the hash of fencing
+// tokens of queued timers, so it takes constant time however long the queue
+// is, unlike searching the list. A timer that was popped by a consumer that
+// crashed before handling it is still reported as queued, and a timer that is
+// queued twice, because it was re-created and fired again before it was
+// consumed, is no longer reported once either copy is consumed.
+//
+// IsQueued returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) IsQueued(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList {
+		return false, ErrQueueMismatch
+	}
+	return n.client.r.HExists(ctx, n.tokensKey(), key).Result()
+}
+
+// Remaining returns the time until the timer with the given key fires, and
+// false if the timer doesn't exist or has already expired. Timers without an
+// expiry are reported as having the maximum duration.
+func (n *Namespace) Remaining(ctx context.Context, key string) (time.Duration, bool, error) {
+	remaining, err := n.RemainingMany(ctx, []string{key})
+	if err != nil {
+		return 0, false, err
+	}
+	d, ok := remaining[key]
+	return d, ok, nil
+}
+
+// RemainingMany is like Remaining, but looks up many timers with a single
+// round trip. Timers that don't exist or have already expired are left out of
+// the returned map.
+func (n *Namespace) RemainingMany(ctx context.Context, keys []string) (map[string]time.Duration, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	cmds, err := n.pttl(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]time.Duration, len(keys))
+	for i, cmd := range cmds {
+		switch d := cmd.Val(); {
+		case d == -1:
+			out[keys[i]] = time.Duration(math.MaxInt64)
+		case d >= 0:
+			out[keys[i]] = d
+		}
+	}
+	return out, nil
+}
+
+// remaining returns the remaining time for each of the given timer keys using
+// a single pipeline. Timers that have already expired have no time remaining,
+// and timers without an expiry are reported as having the maximum duration.
+func (n *Namespace) remaining(ctx context.Context, keys []string) ([]time.Duration, error) {
+	cmds, err := n.pttl(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]time.Duration, len(keys))
+	for i, cmd := range cmds {
+		switch d := cmd.Val(); {
+		case d == -1:
+			out[i] = time.Duration(math.MaxInt64)
+		case d < 0:
+			out[i] = 0
+		default:
+			out[i] = d
+		}
+	}
+	return out, nil
+}
+
+// pttl runs PTTL for each of the given timer keys using a single pipeline.
+func (n *Namespace) pttl(ctx context.Context, keys []string) ([]*redis.DurationCmd, error) {
+	cmds := make([]*redis.DurationCmd, len(keys))
+	_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = p.PTTL(ctx, n.timerKey(k))
+		}
+		return nil
+	})
+	return cmds, err
+}
+
+// fireRateRetention is the number of seconds in the ring buffer of fire counts,
+// and the longest window that FireRate can measure.
+const fireRateRetention = time.Hour
+
+// FireRate returns the number of timers fired per second in this namespace
+// over the given window, such as the last minute, for autoscaling consumers.
+// Fires are counted in whole seconds, including the current one, in a ring
+// buffer of the last hour's seconds that's kept in a single hash, and the
+// window is rounded up to a whole number of seconds. The window must be
+// positive and at most an hour.
+func (n *Namespace) FireRate(ctx context.Context, window time.Duration) (float64, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if window <= 0 {
+		return 0, ErrInvalidDuration
+	}
+	if window > fireRateRetention {
+		return 0, fmt.Errorf("window must be at most %s", fireRateRetention)
+	}
+	seconds := int64((window + time.Second - 1) / time.Second)
+	retention := int64(fireRateRetention / time.Second)
+	now := time.Now().Unix()
+	slots := make([]string, seconds)
+	for i := range slots {
+		slots[i] = strconv.FormatInt((now-int64(i))%retention, 10)
+	}
+	counts, err := n.client.r.HMGet(ctx, n.firesKey(), slots...).Result()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, c := range counts {
+		v, ok := c.(string)
+		if !ok {
+			continue
+		}
+		at, count, ok := strings.Cut(v, ":")
+		if !ok {
+			return 0, fmt.Errorf("invalid fire count %q", v)
+		}
+		second, err := strconv.ParseInt(at, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid fire count %q: %w", v, err)
+		}
+		if second <= now-seconds || second > now {
+			// The slot was last counted in an earlier lap of the ring.
+			continue
+		}
+		fired, err := strconv.ParseInt(count, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid fire count %q: %w", v, err)
+		}
+		total += fired
+	}
+	return float64(total) / float64(seconds), nil
+}
@@ -0,0 +1,120 @@
+package rimer
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strings"
+)
+
+// KeyBuilder builds the redis keys used by rimer out of their parts. The first
+// two parts are always the client's prefix and the namespace, followed by the
+// name of the data structure and, for timers, the timer's key.
+type KeyBuilder interface {
+	// Join joins the given parts into a redis key. Joining a "*" part must
+	// produce a glob pattern that matches any value of that part.
+	Join(parts ...string) string
+
+	// Split splits a redis key into at most n parts, or into all of its parts
+	// if n is negative, such that joining the parts again returns the same
+	// key. Split is only used by Migrate.
+	Split(key string, n int) []string
+}
+
+// Separator is a KeyBuilder that joins the parts of a key with a separator.
+type Separator string
+
+var defaultKeyBuilder KeyBuilder = Separator(":")
+
+// Join joins the parts with the separator.
+func (s Separator) Join(parts ...string) string {
+	return strings.Join(parts, string(s))
+}
+
+// Split splits the key on the separator into at most n parts, or all of them
+// if n is negative.
+func (s Separator) Split(key string, n int) []string {
+	return strings.SplitN(key, string(s), n)
+}
+
+// userParts is the number of parts after the data structure's name that hold
+// values chosen by users, such as timer keys and tag values, for the data
+// structures that have them. The last of these parts may contain the old
+// separator, so Migrate keeps whatever follows the ones before it as a single
+// part. The parts of every other data structure are built by rimer, and are
+// migrated one by one.
+var userParts = map[string]int{
+	"timer": 1,
+	"dedup": 1,
+	"idx":   2,
+}
+
+// migrateKeyScript moves KEYS[1] to KEYS[2], preserving its TTL. If KEYS[2]
+// already exists, because it was written by a client that was already using
+// the new KeyBuilder, the two are merged instead.
+var migrateKeyScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return 0
+end
+if redis.call('EXISTS', KEYS[2]) == 0 then
+	redis.call('RENAME', KEYS[1], KEYS[2])
+	return 1
+end
+local t = redis.call('TYPE', KEYS[1]).ok
+if t ~= redis.call('TYPE', KEYS[2]).ok then
+	return redis.error_reply('WRONGTYPE cannot merge ' .. KEYS[1] .. ' into ' .. KEYS[2])
+end
+if t == 'set' then
+	redis.call('SUNIONSTORE', KEYS[2], KEYS[2], KEYS[1])
+elseif t == 'zset' then
+	redis.call('ZUNIONSTORE', KEYS[2], 2, KEYS[2], KEYS[1], 'AGGREGATE', 'MAX')
+elseif t == 'hash' then
+	local fields = redis.call('HGETALL', KEYS[1])
+	for i = 1, #fields, 2 do
+		redis.call('HSETNX', KEYS[2], fields[i], fields[i + 1])
+	end
+elseif t == 'list' then
+	while redis.call('LMOVE', KEYS[1], KEYS[2], 'LEFT', 'RIGHT') do end
+end
+-- Timers that already exist under the new key are newer, so they win.
+redis.call('DEL', KEYS[1])
+return 1
+`)
+
+// Migrate moves every key under the client's prefix from the layout built by
+// one KeyBuilder to the layout built by another, so that a new naming scheme
+// can be adopted without losing any timers. Keys are renamed, so timers keep
+// their remaining time, and keys that already exist in the new layout are
+// merged with the old ones.
+//
+// Migrate is idempotent and can be resumed if it fails part way through, keys
+// that have already been moved are no longer found in the old layout. It's
+// safe to run while other clients are writing timers using the new KeyBuilder,
+// but clients still using the old KeyBuilder should be stopped first.
+//
+// The tags hash refers to the tag indexes by their full key, so timers with
+// tags that were created before the migration should be re-created afterwards
+// for them to be removed from the migrated indexes once they're consumed.
+func (c *Client) Migrate(ctx context.Context, from, to KeyBuilder) error {
+	ctx, cancel := c.withDefaultTimeout(ctx)
+	defer cancel()
+	iter := c.r.Scan(ctx, 0, from.Join(c.Prefix, "*"), 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		parts := from.Split(key, -1)
+		if len(parts) < 3 || parts[0] != c.Prefix {
+			continue
+		}
+		if n, ok := userParts[parts[2]]; ok && len(parts) > 2+n {
+			parts = append(parts[:2+n], from.Join(parts[2+n:]...))
+		}
+		moved := to.Join(parts...)
+		if moved == key {
+			continue
+		}
+		err := migrateKeyScript.Run(ctx, c.r, []string{key, moved}).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
@@ -0,0 +1,42 @@
+package rimer
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+)
+
+// LatestSchemaVersion is the version of the Redis layout written by this
+// version of rimer. It's recorded in each namespace's _meta hash the first time
+// the namespace is used, and clients refuse to write to namespaces with a newer
+// schema version than they support.
+const LatestSchemaVersion = 1
+
+// metaScript records the namespace's metadata if it hasn't been recorded yet,
+// and checks that it's compatible with this client.
+var metaScript = newNamespaceScript(`
+local err = checkMeta()
+if err then
+	return redis.error_reply(err)
+end
+writeMeta()
+return 1
+`)
+
+// SchemaVersion returns the schema version that this namespace was created
+// with, or 0 if the namespace hasn't been used yet.
+func (n *Namespace) SchemaVersion(ctx context.Context) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	v, err := n.client.r.HGet(ctx, n.metaKey(), "version").Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return v, err
+}
+
+// checkMeta records the namespace's metadata if it hasn't been recorded yet,
+// and returns ErrSchemaVersion or ErrRepresentation if the namespace is
+// incompatible with this client.
+func (n *Namespace) checkMeta(ctx context.Context) error {
+	return n.runScript(ctx, metaScript, nil).Err()
+}
@@ -0,0 +1,33 @@
+package rimer
+
+// Metadata is context that's carried from the code that creates a timer to the
+// code that handles it, see CreateOptions. It implements the TextMapCarrier
+// interface of OpenTelemetry's propagation package, so a trace context can be
+// propagated across the time between creating and firing a timer:
+//
+//	opts := rimer.CreateOptions{Metadata: rimer.Metadata{}}
+//	otel.GetTextMapPropagator().Inject(ctx, opts.Metadata)
+//
+// and once the timer fires:
+//
+//	ctx = otel.GetTextMapPropagator().Extract(ctx, t.Metadata)
+type Metadata map[string]string
+
+// Get returns the value for the given key, or "" if there is none.
+func (m Metadata) Get(key string) string {
+	return m[key]
+}
+
+// Set sets the value for the given key.
+func (m Metadata) Set(key, value string) {
+	m[key] = value
+}
+
+// Keys returns the keys that have values, in no particular order.
+func (m Metadata) Keys() []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
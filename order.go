@@ -0,0 +1,188 @@
+package rimer
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Order is the order in which Next returns the timers waiting in the queue.
+// Poll always pushes fired timers onto the head of the queue, and the order
+// decides which end Next pops from.
+type Order int
+
+const (
+	// OrderFIFO returns the timers in the order they were fired, so the timer
+	// that has been waiting the longest is returned first. This is the default.
+	OrderFIFO Order = iota
+
+	// OrderLIFO returns the most recently fired timer first. When consumers
+	// fall behind, fresh timers are handled right away while the backlog
+	// waits, which suits work where stale timers are worth less than new ones.
+	OrderLIFO
+)
+
+// String returns the name of the order.
+func (o Order) String() string {
+	switch o {
+	case OrderFIFO:
+		return "fifo"
+	case OrderLIFO:
+		return "lifo"
+	default:
+		return "Order(" + strconv.Itoa(int(o)) + ")"
+	}
+}
+
+var defaultNextIdleBackoffMax = 5 * time.Second
+
+// pop blocks until a timer is available in one of the queues, for at most
+// timeout or forever if it's zero, and pops it from the end given by the
+// namespace's order. The queues are checked in the given order. A negative
+// timeout doesn't wait at all.
+func (n *Namespace) pop(ctx context.Context, queues []string, timeout time.Duration) ([]string, error) {
+	if timeout < 0 {
+		return n.popNow(ctx, queues)
+	}
+	if timeout == 0 && n.NextIdleBackoff > 0 {
+		return n.popIdle(ctx, queues)
+	}
+	if n.Order == OrderLIFO {
+		return n.client.r.BLPop(ctx, timeout, queues...).Result()
+	}
+	return n.client.r.BRPop(ctx, timeout, queues...).Result()
+}
+
+// popNow pops a timer from the first of the queues that isn't empty without
+// blocking, and returns redis.Nil if they're all empty. Like BLPOP and BRPOP,
+// it returns the queue's key along with the timer's. Many queues are popped
+// from with a single LMPOP if the server supports it, and one at a time
+// otherwise.
+func (n *Namespace) popNow(ctx context.Context, queues []string) ([]string, error) {
+	if len(queues) > 1 && n.client.supportsLMPop(ctx) {
+		queue, keys, err := n.client.r.LMPop(ctx, n.popDirection(), 1, queues...).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(keys) != 1 {
+			return nil, fmt.Errorf("expected 1 key, got %d", len(keys))
+		}
+		return []string{queue, keys[0]}, nil
+	}
+	for _, queue := range queues {
+		key, err := n.client.r.Do(ctx, n.popCommand(), queue).Text()
+		if err == nil {
+			return []string{queue, key}, nil
+		}
+		if err != redis.Nil {
+			return nil, err
+		}
+	}
+	return nil, redis.Nil
+}
+
+// popIdle waits until a timer is available in the queue without blocking in
+// Redis, see NextIdleBackoff, and pops it. Like BLPOP and BRPOP, it returns the
+// queue's key along with the timer's.
+func (n *Namespace) popIdle(ctx context.Context, queues []string) ([]string, error) {
+	max := n.NextIdleBackoffMax
+	if max <= 0 {
+		max = defaultNextIdleBackoffMax
+	}
+	delay := n.NextIdleBackoff
+	for {
+		keys, err := n.popNow(ctx, queues)
+		if err != redis.Nil {
+			return keys, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > max {
+			delay = max
+		}
+	}
+}
+
+// popCommand returns the command that pops a timer from the end of the queue
+// that pop pops from, without blocking.
+func (n *Namespace) popCommand() string {
+	if n.Order == OrderLIFO {
+		return "LPOP"
+	}
+	return "RPOP"
+}
+
+// popDirection returns the end of the queue that pop pops from, as LMPOP
+// expects it.
+func (n *Namespace) popDirection() string {
+	if n.Order == OrderLIFO {
+		return "left"
+	}
+	return "right"
+}
+
+// supportsLMPop reports whether the server supports LMPOP, which was added in
+// Redis 7. The server's version is only checked until it's read successfully,
+// and servers whose version can't be read are assumed not to support it in
+// the meantime.
+func (c *Client) supportsLMPop(ctx context.Context) bool {
+	c.lmpopMu.Lock()
+	defer c.lmpopMu.Unlock()
+	if c.lmpopKnown {
+		return c.lmpop
+	}
+	info, err := c.r.Info(ctx, "server").Result()
+	if err != nil {
+		return false
+	}
+	c.lmpop = redisMajorVersion(info) >= 7
+	c.lmpopKnown = true
+	return c.lmpop
+}
+
+// redisMajorVersion returns the major version of the server from the output of
+// INFO, or zero if it isn't there.
+func redisMajorVersion(info string) int {
+	for _, line := range strings.Split(info, "\n") {
+		version, ok := strings.CutPrefix(strings.TrimSpace(line), "redis_version:")
+		if !ok {
+			continue
+		}
+		major, _, _ := strings.Cut(version, ".")
+		n, _ := strconv.Atoi(major)
+		return n
+	}
+	return 0
+}
+
+// peekIndex returns the index of the timer in the queue that pop returns next.
+func (n *Namespace) peekIndex() int64 {
+	if n.Order == OrderLIFO {
+		return 0
+	}
+	return -1
+}
+
+// pushBackCommand returns the command that pushes a timer onto the end of the
+// queue that is popped last.
+func (n *Namespace) pushBackCommand() string {
+	if n.Order == OrderLIFO {
+		return "RPUSH"
+	}
+	return "LPUSH"
+}
+
+// pushFrontCommand returns the command that pushes a timer onto the end of the
+// queue that is popped next.
+func (n *Namespace) pushFrontCommand() string {
+	if n.Order == OrderLIFO {
+		return "LPUSH"
+	}
+	return "RPUSH"
+}
@@ -0,0 +1,76 @@
+package rimer
+
+import (
+	"context"
+	"time"
+)
+
+var (
+	defaultPollBackoffMax        = time.Minute
+	defaultPollBackoffMultiplier = 2.0
+)
+
+// PollLoop calls Poll every interval until the context is cancelled, and then
+// returns the context's error. Errors from Poll are passed to OnPollError if
+// it's set. While Poll keeps failing, the time between Polls is increased
+// exponentially by PollBackoffMultiplier up to PollBackoffMax, so that an
+// outage doesn't turn into a retry storm. The interval is reset as soon as a
+// Poll succeeds. The time between Polls is randomized by PollJitter. A Poll
+// that was truncated by MaxPollDuration is followed by another one right away.
+func (n *Namespace) PollLoop(ctx context.Context, interval time.Duration) error {
+	delay := interval
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		r, err := n.PollWithResult(ctx)
+		if err == ErrPollSkipped {
+			err = nil
+		}
+		if err != nil && n.OnPollError != nil && ctx.Err() == nil {
+			n.OnPollError(err)
+		}
+		delay = n.pollDelay(delay, interval, err)
+		if err == nil && r.Truncated {
+			timer.Reset(0)
+			continue
+		}
+		timer.Reset(n.jitter(delay))
+	}
+}
+
+// pollDelay returns how long PollLoop should wait before the next Poll, given
+// the previous delay and the result of the last Poll.
+func (n *Namespace) pollDelay(previous, interval time.Duration, err error) time.Duration {
+	if err == nil {
+		return interval
+	}
+	max, multiplier := n.PollBackoffMax, n.PollBackoffMultiplier
+	if max <= 0 {
+		max = defaultPollBackoffMax
+	}
+	if multiplier <= 1 {
+		multiplier = defaultPollBackoffMultiplier
+	}
+	delay := time.Duration(float64(previous) * multiplier)
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	if delay < interval {
+		delay = interval
+	}
+	return delay
+}
+
+// jitter randomly shortens or lengthens d by up to PollJitter of it.
+func (n *Namespace) jitter(d time.Duration) time.Duration {
+	if n.PollJitter <= 0 {
+		return d
+	}
+	f := float64(n.client.random()) / (1 << 63)
+	return time.Duration(float64(d) * (1 + n.PollJitter*(2*f-1)))
+}
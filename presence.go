@@ -0,0 +1,144 @@
+package rimer
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"time"
+)
+
+const (
+	// consumerPresenceTTL is how long a consumer is counted by
+	// ActiveConsumers after it last took, or waited for, a timer.
+	consumerPresenceTTL = 30 * time.Second
+
+	// consumerPresenceRefresh is how often a consumer's presence key is
+	// refreshed while it's waiting for a timer.
+	consumerPresenceRefresh = consumerPresenceTTL / 3
+
+	// activeConsumersBatchSize is how many keys ActiveConsumers asks Redis for
+	// at a time while it's scanning the presence keys.
+	activeConsumersBatchSize = 100
+)
+
+// ActiveConsumers returns how many consumers have taken timers from this
+// namespace's queue with Next or one of its variants, NextWithValue, Claim,
+// NextGroup, ClaimPending or Consume within the last 30 seconds, or are
+// waiting for one right now. Each Client counts as one
+// consumer of each namespace, no matter how many goroutines or Namespace
+// values use it. A queue that keeps growing while there are no active
+// consumers means that nothing is handling the timers.
+//
+// Consumers keep a key with a TTL in Redis that they refresh while they're
+// taking timers, so consumers that crash stop being counted once it expires.
+// Like Diagnostics, counting them scans the whole keyspace.
+func (n *Namespace) ActiveConsumers(ctx context.Context) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	count := 0
+	iter := n.client.r.Scan(ctx, 0, n.consumerKey("*"), activeConsumersBatchSize).Iterator()
+	for iter.Next(ctx) {
+		count++
+	}
+	return count, iter.Err()
+}
+
+// presenceState is what a client knows about one of its presence keys.
+type presenceState struct {
+	// waiting is how many calls are taking a timer from the namespace.
+	waiting int
+	// refreshedAt is when the key was last refreshed.
+	refreshedAt time.Time
+}
+
+// present marks the client as an active consumer of this namespace, see
+// ActiveConsumers, until the returned function is called and for
+// consumerPresenceTTL after that. The key is refreshed right away if it hasn't
+// been for a while, and by a single goroutine per client while calls are
+// waiting for timers, so taking timers in a loop doesn't cost an extra
+// command each time. It's best effort, errors are left for the command that
+// takes the timer to report.
+func (n *Namespace) present(ctx context.Context) (stop func()) {
+	c := n.client
+	key := n.consumerKey(c.consumer())
+	c.presenceMu.Lock()
+	if c.presence == nil {
+		c.presence = make(map[string]*presenceState)
+	}
+	s, ok := c.presence[key]
+	if !ok {
+		s = &presenceState{}
+		c.presence[key] = s
+	}
+	s.waiting++
+	refresh := time.Since(s.refreshedAt) >= consumerPresenceRefresh
+	if refresh {
+		s.refreshedAt = time.Now()
+	}
+	if !c.presenceRunning {
+		c.presenceRunning = true
+		go c.refreshPresence()
+	}
+	c.presenceMu.Unlock()
+	if refresh && c.r.Set(ctx, key, 1, consumerPresenceTTL).Err() != nil {
+		c.presenceMu.Lock()
+		s.refreshedAt = time.Time{}
+		c.presenceMu.Unlock()
+	}
+	return func() {
+		c.presenceMu.Lock()
+		s.waiting--
+		c.presenceMu.Unlock()
+	}
+}
+
+// refreshPresence refreshes the presence keys that calls are waiting on every
+// consumerPresenceRefresh, and returns once no calls are waiting. Keys that
+// no call is waiting on are forgotten, and expire on their own.
+func (c *Client) refreshPresence() {
+	ticker := time.NewTicker(consumerPresenceRefresh)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.presenceMu.Lock()
+		var keys []string
+		for key, s := range c.presence {
+			if s.waiting == 0 {
+				if time.Since(s.refreshedAt) >= consumerPresenceTTL {
+					delete(c.presence, key)
+				}
+				continue
+			}
+			if time.Since(s.refreshedAt) >= consumerPresenceRefresh/2 {
+				s.refreshedAt = time.Now()
+				keys = append(keys, key)
+			}
+		}
+		if len(c.presence) == 0 {
+			c.presenceRunning = false
+			c.presenceMu.Unlock()
+			return
+		}
+		c.presenceMu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), consumerPresenceRefresh)
+		_, _ = c.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for _, key := range keys {
+				p.Set(ctx, key, 1, consumerPresenceTTL)
+			}
+			return nil
+		})
+		cancel()
+	}
+}
+
+// consumer returns the ID that identifies this client in presence keys.
+func (c *Client) consumer() string {
+	c.consumerOnce.Do(func() {
+		c.consumerID = strconv.FormatInt(c.random(), 36)
+	})
+	return c.consumerID
+}
+
+// consumerKey returns the redis key that marks a consumer as active.
+func (n *Namespace) consumerKey(id string) string {
+	return n.key("_consumers", id)
+}
@@ -0,0 +1,137 @@
+// Package prometheus exposes the metrics of rimer namespaces to Prometheus. It
+// lives in its own package so that the rimer package itself doesn't depend on
+// the Prometheus client.
+//
+//	collector := prometheus.NewCollector(orders, invoices)
+//	registry.MustRegister(collector)
+//	http.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+package prometheus
+
+import (
+	"context"
+	"github.com/clarkmcc/rimer"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// defaultTimeout is the default time that reading the queue length of each
+// namespace may take during a scrape.
+const defaultTimeout = 5 * time.Second
+
+// Collector is a prometheus.Collector of the metrics of rimer namespaces, each
+// labelled with the name of the namespace:
+//
+//   - rimer_timers_created_total counts the timers that were created,
+//   - rimer_timers_fired_total counts the timers that were fired by Poll,
+//   - rimer_poll_duration_seconds is a histogram of how long Poll took,
+//   - rimer_poll_errors_total counts the polls that failed,
+//   - and rimer_queue_length is the number of fired timers waiting to be
+//     consumed, which is read from Redis on every scrape.
+type Collector struct {
+	// Timeout bounds reading the queue length of each namespace during a
+	// scrape. Defaults to 5 seconds.
+	Timeout time.Duration
+
+	namespaces  []*rimer.Namespace
+	created     *prometheus.CounterVec
+	fired       *prometheus.CounterVec
+	polls       *prometheus.HistogramVec
+	pollErrors  *prometheus.CounterVec
+	queueLength *prometheus.Desc
+}
+
+// NewCollector creates a collector of the metrics of the given namespaces. The
+// counters are updated by the namespaces' OnCreate, OnFire and OnPoll hooks,
+// which the collector wraps, calling the hooks that were already set after
+// updating its metrics. So the namespaces passed here must be the ones that
+// timers are created and polled with, and hooks set on them later replace the
+// collector's.
+func NewCollector(namespaces ...*rimer.Namespace) *Collector {
+	labels := []string{"namespace"}
+	c := &Collector{
+		Timeout:    defaultTimeout,
+		namespaces: namespaces,
+		created: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rimer_timers_created_total",
+			Help: "The number of timers that were created.",
+		}, labels),
+		fired: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rimer_timers_fired_total",
+			Help: "The number of timers that were fired.",
+		}, labels),
+		polls: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rimer_poll_duration_seconds",
+			Help:    "How long polling the timers took.",
+			Buckets: prometheus.DefBuckets,
+		}, labels),
+		pollErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rimer_poll_errors_total",
+			Help: "The number of polls that failed.",
+		}, labels),
+		queueLength: prometheus.NewDesc("rimer_queue_length",
+			"The number of fired timers waiting to be consumed.", labels, nil),
+	}
+	for _, n := range namespaces {
+		c.instrument(n)
+	}
+	return c
+}
+
+// instrument wraps the hooks of the namespace to update the collector's
+// metrics.
+func (c *Collector) instrument(n *rimer.Namespace) {
+	name := n.Name()
+	created, fired, polls, pollErrors := c.created.WithLabelValues(name), c.fired.WithLabelValues(name),
+		c.polls.WithLabelValues(name), c.pollErrors.WithLabelValues(name)
+	onCreate, onFire, onPoll := n.OnCreate, n.OnFire, n.OnPoll
+	n.OnCreate = func(key string, duration time.Duration) {
+		created.Inc()
+		if onCreate != nil {
+			onCreate(key, duration)
+		}
+	}
+	n.OnFire = func(key string) {
+		fired.Inc()
+		if onFire != nil {
+			onFire(key)
+		}
+	}
+	n.OnPoll = func(r rimer.PollResult, duration time.Duration, err error) {
+		polls.Observe(duration.Seconds())
+		if err != nil && err != rimer.ErrPollSkipped {
+			pollErrors.Inc()
+		}
+		if onPoll != nil {
+			onPoll(r, duration, err)
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.created.Describe(ch)
+	c.fired.Describe(ch)
+	c.polls.Describe(ch)
+	c.pollErrors.Describe(ch)
+	ch <- c.queueLength
+}
+
+// Collect implements prometheus.Collector. The queue length of each namespace
+// is read from Redis, and a namespace whose queue length couldn't be read is
+// reported as an invalid metric, which fails the scrape.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.created.Collect(ch)
+	c.fired.Collect(ch)
+	c.polls.Collect(ch)
+	c.pollErrors.Collect(ch)
+	for _, n := range c.namespaces {
+		ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+		length, err := n.QueueLength(ctx)
+		cancel()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(c.queueLength, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.queueLength, prometheus.GaugeValue, float64(length), n.Name())
+	}
+}
@@ -0,0 +1,63 @@
+package prometheus
+
+import (
+	"context"
+	"github.com/clarkmcc/rimer/rimertest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	ctx := context.Background()
+	c, m := rimertest.NewServer(t)
+	foo, bar := c.Namespace("foo"), c.Namespace("bar")
+
+	var created []string
+	foo.OnCreate = func(key string, duration time.Duration) {
+		created = append(created, key)
+	}
+	collector := NewCollector(foo, bar)
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(collector))
+
+	require.NoError(t, foo.Create(ctx, "a", time.Hour))
+	require.NoError(t, foo.Create(ctx, "b", time.Hour))
+	require.NoError(t, bar.Create(ctx, "c", 2*time.Hour))
+	m.FastForward(time.Hour)
+	require.NoError(t, foo.Poll(ctx))
+	require.NoError(t, bar.Poll(ctx))
+
+	// The hooks that were already set are still called
+	assert.Equal(t, []string{"a", "b"}, created)
+
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP rimer_queue_length The number of fired timers waiting to be consumed.
+# TYPE rimer_queue_length gauge
+rimer_queue_length{namespace="bar"} 0
+rimer_queue_length{namespace="foo"} 2
+# HELP rimer_timers_created_total The number of timers that were created.
+# TYPE rimer_timers_created_total counter
+rimer_timers_created_total{namespace="bar"} 1
+rimer_timers_created_total{namespace="foo"} 2
+# HELP rimer_timers_fired_total The number of timers that were fired.
+# TYPE rimer_timers_fired_total counter
+rimer_timers_fired_total{namespace="bar"} 0
+rimer_timers_fired_total{namespace="foo"} 2
+# HELP rimer_poll_errors_total The number of polls that failed.
+# TYPE rimer_poll_errors_total counter
+rimer_poll_errors_total{namespace="bar"} 0
+rimer_poll_errors_total{namespace="foo"} 0
+`), "rimer_queue_length", "rimer_timers_created_total", "rimer_timers_fired_total", "rimer_poll_errors_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, testutil.CollectAndCount(collector, "rimer_poll_duration_seconds"))
+
+	// A failed scrape of the queue length fails the whole scrape
+	m.Close()
+	_, err = registry.Gather()
+	assert.Error(t, err)
+}
@@ -0,0 +1,84 @@
+package rimer
+
+import (
+	"context"
+)
+
+// purgeQueueScript deletes the KEYS[1] queue and returns the timers that were
+// in it.
+var purgeQueueScript = newNamespaceScript(`
+local queued = redis.call('LRANGE', KEYS[1], 0, -1)
+redis.call('DEL', KEYS[1])
+return queued
+`)
+
+// forgetQueuedScript removes the timers in ARGV that were discarded from the
+// queue from the companion hashes, which are KEYS[1] onwards, followed by the
+// timer key and the registered key of each timer. Timers that were created
+// again after they fired keep their companion data, except for the fencing
+// token of the discarded entry.
+var forgetQueuedScript = newNamespaceScript(`
+local tokens = 2
+for i = 1, nargs do
+	local timer, registered = KEYS[companionKeyCount + 2 * i - 1], KEYS[companionKeyCount + 2 * i]
+	if redis.call('EXISTS', timer) == 1 or isRegistered(registered, ARGV[i]) then
+		redis.call('HDEL', KEYS[tokens], ARGV[i])
+	else
+		forget(1, ARGV[i])
+	end
+end
+return nargs
+`)
+
+// PurgeQueue discards the timers that have fired but haven't been consumed yet,
+// and returns how many there were. Armed timers, and timers that have expired
+// but haven't been polled yet, are left alone. The discarded timers are handled
+// as if they were cancelled: one-shot timers are forgotten, and the timers that
+// depend on them are never created, unless FireDependentsOnCancel is set.
+// Recurring timers are never re-armed. This is meant for recovering from an
+// incident where a bad batch of timers fired.
+//
+// PurgeQueue returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) PurgeQueue(ctx context.Context) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList {
+		return 0, ErrQueueMismatch
+	}
+	total := 0
+	for _, queue := range n.queueKeys() {
+		purged, err := n.runScript(ctx, purgeQueueScript, []string{queue}).StringSlice()
+		if err != nil {
+			return total, err
+		}
+		total += len(purged)
+		for len(purged) > 0 {
+			batch := purged
+			if len(batch) > cancelBatchSize {
+				batch = batch[:cancelBatchSize]
+			}
+			err = n.forgetQueued(ctx, batch)
+			if err != nil {
+				return total, err
+			}
+			purged = purged[len(batch):]
+		}
+	}
+	return total, nil
+}
+
+// forgetQueued runs forgetQueuedScript for the given timers that were discarded
+// from the queue, and releases their dependent timers.
+func (n *Namespace) forgetQueued(ctx context.Context, keys []string) error {
+	companions := n.companionKeys()
+	redisKeys := make([]string, 0, companionKeyCount+2*len(keys))
+	redisKeys = append(redisKeys, companions[:]...)
+	for _, k := range keys {
+		redisKeys = append(redisKeys, n.timerKey(k), n.registeredKeyFor(k))
+	}
+	err := n.runScript(ctx, forgetQueuedScript, redisKeys, toAny(keys)...).Err()
+	if err != nil {
+		return err
+	}
+	return n.releaseDependents(ctx, keys, false)
+}
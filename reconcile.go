@@ -0,0 +1,158 @@
+package rimer
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"time"
+)
+
+// registeredTempTTL is how long the temporary sets that Poll uses to find the
+// expired timers live, so that the sets of a Poll that's interrupted before it
+// deletes its set don't stick around. It's far longer than a Poll should ever
+// take, because a set that expires while it's still in use makes every timer
+// in it look expired.
+const registeredTempTTL = 10 * time.Minute
+
+// ReconcileReport describes what a single Reconcile did.
+type ReconcileReport struct {
+	// TempSetsRemoved is the number of orphaned temporary sets that were
+	// removed, see Reconcile.
+	TempSetsRemoved int
+	// Registered is the number of timers that existed but weren't registered,
+	// and were registered again, see RebuildRegistered.
+	Registered int
+	// Poll is what polling the namespace did, see PollWithResult.
+	Poll PollResult
+	// Duration is how long Reconcile took.
+	Duration time.Duration
+}
+
+// Reconcile runs the namespace's periodic housekeeping in a single call, so
+// that operators can schedule one maintenance task instead of several, and
+// returns a report of what it did for logging. In order, it:
+//
+//   - removes the orphaned temporary sets left behind by polls that were
+//     interrupted before they could delete them,
+//   - registers the timers that exist but aren't registered, like
+//     RebuildRegistered, so that they fire,
+//   - and polls the namespace, like PollWithResult.
+//
+// The temporary sets that Poll creates now expire on their own, so only those
+// without an expiry, which were left behind by older versions, are removed.
+// Temporary sets that are in use by an older version polling concurrently
+// can't be told apart from orphaned ones, so Reconcile shouldn't be run while
+// older versions are polling the namespace.
+//
+// Like RebuildRegistered, Reconcile scans the whole keyspace, so it should be
+// run every few minutes rather than as often as Poll. If a step fails, the
+// report describes the steps that ran before it.
+func (n *Namespace) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	start := time.Now()
+	r := &ReconcileReport{}
+	defer func() {
+		r.Duration = time.Since(start)
+	}()
+	var err error
+	r.TempSetsRemoved, err = n.removeOrphanedTempSets(ctx)
+	if err != nil {
+		return r, err
+	}
+	r.Registered, err = n.RebuildRegistered(ctx)
+	if err != nil {
+		return r, err
+	}
+	r.Poll, err = n.PollWithResult(ctx)
+	return r, err
+}
+
+// TempSet is a temporary set created by a Poll, see TempSets.
+type TempSet struct {
+	// Key is the Redis key of the set.
+	Key string
+	// PollerID is the PollerID of the client whose Poll created the set, or
+	// "" if it wasn't set.
+	PollerID string
+	// Remaining is the time until the set expires, or -1 if it doesn't
+	// expire because it was left behind by an older version, see Reconcile.
+	Remaining time.Duration
+}
+
+// TempSets returns the temporary sets that Polls of this namespace have
+// created and not yet deleted, along with the pollers that created them. A Poll
+// deletes its set before it returns, so sets that stick around for longer than
+// a Poll takes were left behind by a Poll that was interrupted or is stuck,
+// and their PollerID tells which poller to look at. Like Reconcile, it scans
+// the whole keyspace.
+func (n *Namespace) TempSets(ctx context.Context) ([]TempSet, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	keys, cmds, err := n.tempSets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := n.key("_registered_")
+	sets := make([]TempSet, 0, len(keys))
+	for i, k := range keys {
+		// PTTL is -2 for keys that have been deleted since they were scanned.
+		if cmds[i].Val() == -2 {
+			continue
+		}
+		set := TempSet{Key: k, Remaining: cmds[i].Val()}
+		if rest, ok := strings.CutPrefix(k, prefix); ok {
+			if j := strings.LastIndexByte(rest, '_'); j >= 0 {
+				set.PollerID = rest[:j]
+			}
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// removeOrphanedTempSets removes the temporary sets of this namespace that
+// don't expire, and returns how many there were.
+func (n *Namespace) removeOrphanedTempSets(ctx context.Context) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	keys, cmds, err := n.tempSets(ctx)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+	orphaned := keys[:0]
+	for i, cmd := range cmds {
+		// PTTL is -1 for keys without an expiry, and -2 for keys that have
+		// been deleted since they were scanned.
+		if cmd.Val() == -1 {
+			orphaned = append(orphaned, keys[i])
+		}
+	}
+	if len(orphaned) == 0 {
+		return 0, nil
+	}
+	removed, err := n.client.r.Del(ctx, orphaned...).Result()
+	return int(removed), err
+}
+
+// tempSets scans for the temporary sets of this namespace, and returns their
+// keys along with their PTTLs.
+func (n *Namespace) tempSets(ctx context.Context) ([]string, []*redis.DurationCmd, error) {
+	var keys []string
+	iter := n.client.r.Scan(ctx, 0, n.registeredTempPrefix(), rebuildBatchSize).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil || len(keys) == 0 {
+		return nil, nil, err
+	}
+	cmds := make([]*redis.DurationCmd, len(keys))
+	_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			cmds[i] = p.PTTL(ctx, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return keys, cmds, nil
+}
@@ -0,0 +1,317 @@
+package rimer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// FiredTimer is a timer that has fired and has been consumed from the queue.
+type FiredTimer struct {
+	// Key is the key that the timer was created with.
+	Key string
+	// ID is the stream entry ID of the timer when using QueueStream, which is
+	// passed to Ack once the timer has been handled. It's empty otherwise.
+	ID string
+	// Token is a fencing token that is greater than the token of any timer that
+	// fired before it in the namespace. Consumers that act on timers can pass
+	// it along so that the systems they act on can reject stale or duplicate
+	// deliveries that carry a token lower than one they've already seen.
+	Token int64
+	// Label is the label that the timer was created with, see CreateOptions.
+	Label string
+	// Value is the value that the timer was created with, see CreateOptions,
+	// or nil if it has none.
+	Value []byte
+	// Fields are the fields that the timer was created with, see
+	// CreateOptions, or nil if it has none.
+	Fields map[string][]byte
+	// Tags are the tags that the timer was created with, see CreateOptions,
+	// so that a consumer of a queue with mixed kinds of timers can route them
+	// without parsing their keys. It's nil if the timer has none.
+	Tags map[string]string
+	// Metadata is the metadata that the timer was created with, see
+	// CreateOptions.
+	Metadata Metadata
+	// FiredAt is the time that the timer fired, if the namespace has
+	// RecordFireTime set, and the zero time otherwise.
+	FiredAt time.Time
+	// Replays is the number of times the timer was replayed from the dead
+	// letter queue, see ReplayDeadLetters.
+	Replays int
+	// LazyValue is true if the timer was created with CreateOptions.LazyValue.
+	// Its Value is the one computed by the namespace's ResolveValue, or nil
+	// if it's not set, in which case the consumer resolves the value itself.
+	LazyValue bool
+	// Warning is the offset of the warning that fired, see CreateOptions, in
+	// which case Key is the key of the timer that the warning is about. It's
+	// zero for the timer itself.
+	Warning time.Duration
+	// Recurring is true if the timer was created with CreateRecurring or
+	// CreateCron, in which case it has already been re-armed and will fire
+	// again.
+	Recurring bool
+	// NextFireAt is the approximate time that a recurring timer will fire
+	// next. It's the zero time for one-shot timers.
+	NextFireAt time.Time
+	// Shard is the shard of the queue that the timer was popped from when
+	// using QueueShards, and zero otherwise.
+	Shard int
+	// DeliveryID identifies this fire of the timer, and stays the same when
+	// the timer is delivered again, unlike Token, which is new every time.
+	// Consumers that record the delivery IDs they've handled can skip the
+	// duplicates that at-least-once delivery leads to. With QueueList, it's
+	// kept when a claim expires and the timer is reclaimed, see Claim, and
+	// when it's requeued with RequeueTimer, while Requeue and replaying dead
+	// letters start a new delivery. With QueueStream, it's the ID of the
+	// stream entry.
+	DeliveryID string
+}
+
+// CreateRecurring creates a timer that fires every interval. Each time the timer
+// is consumed by Next, it is re-armed to fire again after another interval. This
+// means that the interval is measured from when the timer was consumed, not from
+// when it fired. Calling Create with the same key turns it back into a one-shot
+// timer.
+func (n *Namespace) CreateRecurring(ctx context.Context, key string, interval time.Duration) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	_, err := n.create(ctx, key, interval, createParams{interval: interval})
+	return err
+}
+
+// rearmScript takes the fencing token of a timer that was consumed from the
+// queue out of the tokens hash, and re-arms the timer if it's recurring using
+// the interval stored in the recurring hash. One-shot timers are removed from
+// the companion hashes, which start at KEYS[3], unless ARGV[2] is '1' because
+// the timer is being claimed. It returns, by index:
+//
+//  0. the interval in milliseconds, or 0 if the timer isn't recurring, or the
+//     timer's record in the recurring hash if it's a cron timer, which is
+//     re-armed by armCron instead, see CreateCron, or the time left until it
+//     fires again if it was reclaimed
+//  1. the fencing token, or 0 if the timer doesn't have one
+//  2. the label
+//  3. the value, or false if the timer doesn't have one
+//  4. the fields, or false if the timer doesn't have any
+//  5. the tag record, or false if the timer doesn't have any tags
+//  6. 1 if the timer's value is resolved lazily
+//  7. the metadata, or false if the timer doesn't have any
+//  8. the number of times the timer was replayed from the dead letter queue
+//  9. 1 if the timer was created with a value
+//  10. the time that the timer fired in milliseconds, or 0 if it wasn't recorded
+//  11. the delivery ID, or "" if the timer doesn't have one
+//  12. 1 if the timer was returned to the queue by Reclaim, in which case it
+//     was already re-armed when it was first claimed and isn't re-armed again
+var rearmScript = newNamespaceScript(`
+local token, fired, delivery, reclaimed = 0, 0, '', false
+local entry = redis.call('HGET', KEYS[4], ARGV[1])
+if entry then
+	token, fired, delivery, reclaimed = parseToken(entry)
+end
+redis.call('HDEL', KEYS[4], ARGV[1])
+local label = redis.call('HGET', KEYS[5], ARGV[1]) or ''
+local value = redis.call('HGET', KEYS[6], ARGV[1])
+local fields = redis.call('HGET', KEYS[8], ARGV[1])
+local lazy = redis.call('HEXISTS', KEYS[9], ARGV[1])
+local metadata = redis.call('HGET', KEYS[10], ARGV[1])
+local replays = tonumber(redis.call('HGET', KEYS[12], ARGV[1]) or 0)
+local valued = redis.call('HEXISTS', KEYS[13], ARGV[1])
+local tags = redis.call('HGET', KEYS[15], ARGV[1])
+local again = reclaimed and 1 or 0
+local interval = redis.call('HGET', KEYS[3], ARGV[1])
+if not interval then
+	if ARGV[2] ~= '1' then
+		forget(3, ARGV[1])
+	end
+	return {0, token, label, value, fields, tags, lazy, metadata, replays, valued, fired, delivery, again}
+end
+if reclaimed then
+	local pttl = redis.call('PTTL', KEYS[1])
+	return {math.max(pttl, 1), token, label, value, fields, tags, lazy, metadata, replays, valued, fired, delivery, again}
+end
+if string.sub(interval, 1, 5) == 'cron:' then
+	return {interval, token, label, value, fields, tags, lazy, metadata, replays, valued, fired, delivery, again}
+end
+redis.call('SET', KEYS[1], '', 'PX', interval)
+register(KEYS[2], ARGV[1], interval)
+return {tonumber(interval), token, label, value, fields, tags, lazy, metadata, replays, valued, fired, delivery, again}
+`)
+
+// rearm re-arms the fired timer if it's recurring and fills in the recurrence
+// details, the label, the value, unless it already has one, the fields, the
+// tags, the metadata and the warning offset on t, and creates the timers that
+// depend on it. Timers consumed from a list also have their fencing token
+// filled in, timers consumed from a stream already have it.
+func (n *Namespace) rearm(ctx context.Context, t *FiredTimer) error {
+	return n.rearmFor(ctx, t, false)
+}
+
+// rearmFor is like rearm, but if claim is set, the companion data of one-shot
+// timers is kept until the claim is completed, see Complete. A timer that was
+// returned to the queue by Reclaim was already re-armed, and its dependents
+// created, when it was first claimed, so neither happens again.
+func (n *Namespace) rearmFor(ctx context.Context, t *FiredTimer, claim bool) error {
+	companions := n.companionKeys()
+	keep := ""
+	if claim {
+		keep = "1"
+	}
+	res, err := n.runScript(ctx, rearmScript,
+		append([]string{n.timerKey(t.Key), n.registeredKeyFor(t.Key)}, companions[:]...),
+		t.Key, keep).Slice()
+	if err != nil {
+		return err
+	}
+	if len(res) != 13 {
+		return fmt.Errorf("expected 13 values, got %d", len(res))
+	}
+	reclaimed, _ := res[12].(int64)
+	ms, _ := res[0].(int64)
+	token, _ := res[1].(int64)
+	t.Label, _ = res[2].(string)
+	if v, ok := res[3].(string); ok && t.Value == nil {
+		t.Value, err = n.client.decodeValue([]byte(v))
+		if err != nil {
+			return fmt.Errorf("decoding value of timer %s: %w", t.Key, err)
+		}
+	}
+	if v, ok := res[4].(string); ok {
+		err = json.Unmarshal([]byte(v), &t.Fields)
+		if err != nil {
+			return fmt.Errorf("decoding fields of timer %s: %w", t.Key, err)
+		}
+	}
+	if v, ok := res[5].(string); ok {
+		var r tagRecord
+		err = json.Unmarshal([]byte(v), &r)
+		if err != nil {
+			return fmt.Errorf("decoding tags of timer %s: %w", t.Key, err)
+		}
+		t.Tags = r.Tags
+	}
+	if v, ok := res[7].(string); ok {
+		err = json.Unmarshal([]byte(v), &t.Metadata)
+		if err != nil {
+			return fmt.Errorf("decoding metadata of timer %s: %w", t.Key, err)
+		}
+	}
+	key := t.Key
+	t.Key, t.Warning = splitWarningKey(key)
+	replays, _ := res[8].(int64)
+	t.Replays = int(replays)
+	if fired, _ := res[10].(int64); fired > 0 && t.FiredAt.IsZero() {
+		t.FiredAt = time.UnixMilli(fired)
+	}
+	if lazy, _ := res[6].(int64); lazy == 1 {
+		t.LazyValue = true
+	}
+	if n.Queue == QueueList {
+		t.Token = token
+		t.DeliveryID, _ = res[11].(string)
+	}
+	if ms > 0 {
+		t.Recurring = true
+		t.NextFireAt = time.Now().Add(time.Duration(ms) * time.Millisecond)
+	} else if record, ok := res[0].(string); ok {
+		t.Recurring = true
+		t.NextFireAt, err = n.armCron(ctx, key, record)
+		if err != nil {
+			return fmt.Errorf("re-arming cron timer %s: %w", t.Key, err)
+		}
+	}
+	if reclaimed == 0 {
+		err = n.releaseDependents(ctx, []string{key}, true)
+		if err != nil {
+			return err
+		}
+	}
+	if valued, _ := res[9].(int64); valued == 1 && t.Value == nil && !n.IgnoreMissingValues {
+		return fmt.Errorf("%w: timer %s", ErrPayloadMissing, t.Key)
+	}
+	if !t.LazyValue || n.ResolveValue == nil {
+		return nil
+	}
+	t.Value, err = n.ResolveValue(ctx, t.Key)
+	if err != nil {
+		return fmt.Errorf("resolving value of timer %s: %w", t.Key, err)
+	}
+	return nil
+}
+
+// rescheduleRecurringScript sets the interval of the KEYS[3] recurring timer
+// ARGV[1] to ARGV[2] milliseconds. If ARGV[3] is set, a pending timer is also
+// re-armed to fire after the new interval. It returns 1 if the interval was
+// changed, 0 if the timer doesn't exist and -1 if it isn't an interval timer.
+var rescheduleRecurringScript = newNamespaceScript(`
+local interval = redis.call('HGET', KEYS[3], ARGV[1])
+if not interval then
+	if redis.call('EXISTS', KEYS[1]) == 1 or isRegistered(KEYS[2], ARGV[1]) then
+		return -1
+	end
+	return 0
+end
+if string.sub(interval, 1, 5) == 'cron:' then
+	return -1
+end
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+touch(KEYS[3])
+if ARGV[3] == '1' and redis.call('PTTL', KEYS[1]) >= 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	register(KEYS[2], ARGV[1], ARGV[2])
+end
+return 1
+`)
+
+// RescheduleRecurring changes the interval of a recurring timer created with
+// CreateRecurring, without recreating it. The timer keeps its label, value and
+// other options, and the time it's currently set to fire, and the new interval
+// is used from the next time it's re-armed. Use RescheduleRecurringNow to also
+// move the pending fire. It returns ErrTimerNotFound if the timer doesn't exist
+// and ErrNotRecurring if it's a one-shot or cron timer.
+func (n *Namespace) RescheduleRecurring(ctx context.Context, key string, newInterval time.Duration) error {
+	return n.rescheduleRecurring(ctx, key, newInterval, false)
+}
+
+// RescheduleRecurringNow is like RescheduleRecurring, but if the timer is
+// pending, it's also re-armed to fire after the new interval from now. Timers
+// that have expired and are waiting to be polled or consumed fire as before.
+func (n *Namespace) RescheduleRecurringNow(ctx context.Context, key string, newInterval time.Duration) error {
+	return n.rescheduleRecurring(ctx, key, newInterval, true)
+}
+
+// rescheduleRecurring changes the interval of a recurring timer, and re-arms it
+// if it's pending and now is set.
+func (n *Namespace) rescheduleRecurring(ctx context.Context, key string, newInterval time.Duration, now bool) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if newInterval <= 0 {
+		return ErrInvalidDuration
+	}
+	newInterval, err := n.limitDuration(n.scaleDuration(newInterval))
+	if err != nil {
+		return err
+	}
+	ms, err := durationMs(newInterval)
+	if err != nil {
+		return err
+	}
+	rearm := ""
+	if now {
+		rearm = "1"
+	}
+	res, err := n.runScript(ctx, rescheduleRecurringScript,
+		[]string{n.timerKey(key), n.registeredKeyFor(key), n.recurringKey()},
+		key, ms, rearm).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case 0:
+		return fmt.Errorf("%w: %s", ErrTimerNotFound, key)
+	case -1:
+		return fmt.Errorf("%w: %s", ErrNotRecurring, key)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package rimer
+
+import (
+	"context"
+	"strings"
+)
+
+// rebuildBatchSize is the number of timer keys that RebuildRegistered scans,
+// and registers, at a time.
+const rebuildBatchSize = 100
+
+// rebuildRegisteredScript registers the timers in ARGV that still exist but
+// aren't registered. KEYS holds the timer key and the registered key of each
+// timer in turn. Returns the number of timers that were registered.
+var rebuildRegisteredScript = newNamespaceScript(`
+local err = checkMeta()
+if err then
+	return redis.error_reply(err)
+end
+writeMeta()
+local added = 0
+for i = 1, nargs do
+	local pttl = redis.call('PTTL', KEYS[2 * i - 1])
+	if pttl ~= -2 and not isRegistered(KEYS[2 * i], ARGV[i]) then
+		if pttl < 0 then
+			pttl = 0
+		end
+		register(KEYS[2 * i], ARGV[i], pttl)
+		added = added + 1
+	end
+end
+return added
+`)
+
+// RebuildRegistered registers every timer whose key exists but that isn't
+// registered, and returns the number of timers that were registered. Poll only
+// fires registered timers, so timers whose registration was lost, for example
+// because the registered set was flushed while the timer keys were not, never
+// fire until this is run. It's safe to run while timers are being created and
+// polled.
+func (n *Namespace) RebuildRegistered(ctx context.Context) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	prefix := n.timerKey("")
+	total := 0
+	var keys []string
+	rebuild := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		redisKeys := make([]string, 0, 2*len(keys))
+		for _, k := range keys {
+			redisKeys = append(redisKeys, n.timerKey(k), n.registeredKeyFor(k))
+		}
+		added, err := n.runScript(ctx, rebuildRegisteredScript, redisKeys, toAny(keys)...).Int()
+		total += added
+		keys = keys[:0]
+		return err
+	}
+	iter := n.client.r.Scan(ctx, 0, n.timerKey("*"), rebuildBatchSize).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, strings.TrimPrefix(iter.Val(), prefix))
+		if len(keys) == rebuildBatchSize {
+			if err := rebuild(); err != nil {
+				return total, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return total, err
+	}
+	return total, rebuild()
+}
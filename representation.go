@@ -0,0 +1,302 @@
+package rimer
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"hash/crc32"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Representation is the data structure used to keep track of the registered
+// timers in a namespace. The representation mostly changes how the namespace's
+// methods are implemented in Redis rather than how they behave, except that
+// only RepresentationSortedSet knows when each timer was due, which PollUntil
+// and the order of fired timers rely on. Every client that uses a namespace
+// must agree on its representation, see MigrateRepresentation for changing the
+// representation of an existing namespace.
+type Representation int
+
+const (
+	// RepresentationSet keeps the registered timers in a single set, and Poll
+	// finds the expired timers by diffing the set against the unexpired timer
+	// keys. This is the default, and works well for most namespaces.
+	RepresentationSet Representation = iota
+
+	// RepresentationSortedSet keeps the registered timers in a sorted set
+	// scored by the time they fire, so Poll only has to look at the timers
+	// that are due instead of every timer key. This is ideal for namespaces
+	// with few timers, or where Poll is called frequently. It's also the only
+	// representation where the timers that expired since the last Poll are
+	// queued in the order they were due, with ties broken by key, so that Next
+	// returns them in that order with OrderFIFO and PollMaxFire fires the
+	// oldest ones first. The other representations don't store fire times, so
+	// they queue the timers found by a single Poll in no particular order.
+	RepresentationSortedSet
+
+	// RepresentationBucketed spreads the registered timers across a fixed
+	// number of sets by hashing their keys. This keeps each set small for
+	// namespaces with a very large number of timers.
+	RepresentationBucketed
+)
+
+// registeredBuckets is the number of sets used by RepresentationBucketed.
+const registeredBuckets = 16
+
+// String returns the name of the representation.
+func (r Representation) String() string {
+	switch r {
+	case RepresentationSet:
+		return "set"
+	case RepresentationSortedSet:
+		return "sorted-set"
+	case RepresentationBucketed:
+		return "bucketed"
+	default:
+		return "Representation(" + strconv.Itoa(int(r)) + ")"
+	}
+}
+
+// lua returns the name of the representation as used by registeredLua. Only
+// the sorted set differs, buckets are plain sets that scripts are given the
+// key of.
+func (r Representation) lua() string {
+	if r == RepresentationSortedSet {
+		return "zset"
+	}
+	return "set"
+}
+
+// registeredKeyFor returns the redis key that the given timer is registered in.
+func (n *Namespace) registeredKeyFor(key string) string {
+	return n.registeredKeyForRepresentation(n.Representation, key)
+}
+
+func (n *Namespace) registeredKeyForRepresentation(r Representation, key string) string {
+	switch r {
+	case RepresentationSortedSet:
+		return n.scheduledKey()
+	case RepresentationBucketed:
+		return n.registeredBucketKey(int(crc32.ChecksumIEEE([]byte(key)) % registeredBuckets))
+	default:
+		return n.registeredKey()
+	}
+}
+
+// registeredKeys returns all the redis keys that timers are registered in.
+func (n *Namespace) registeredKeys() []string {
+	return n.registeredKeysForRepresentation(n.Representation)
+}
+
+func (n *Namespace) registeredKeysForRepresentation(r Representation) []string {
+	switch r {
+	case RepresentationSortedSet:
+		return []string{n.scheduledKey()}
+	case RepresentationBucketed:
+		keys := make([]string, registeredBuckets)
+		for i := range keys {
+			keys[i] = n.registeredBucketKey(i)
+		}
+		return keys
+	default:
+		return []string{n.registeredKey()}
+	}
+}
+
+// registeredMembers returns the keys of all registered timers.
+func (n *Namespace) registeredMembers(ctx context.Context) ([]string, error) {
+	return n.registeredMembersForRepresentation(ctx, n.Representation)
+}
+
+func (n *Namespace) registeredMembersForRepresentation(ctx context.Context, r Representation) ([]string, error) {
+	var members []string
+	for _, k := range n.registeredKeysForRepresentation(r) {
+		var keys []string
+		var err error
+		if r == RepresentationSortedSet {
+			keys, err = n.client.r.ZRange(ctx, k, 0, -1).Result()
+		} else {
+			keys, err = n.client.r.SMembers(ctx, k).Result()
+		}
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, keys...)
+	}
+	return members, nil
+}
+
+// registeredCount returns the number of registered timers.
+func (n *Namespace) registeredCount(ctx context.Context) (int, error) {
+	keys := n.registeredKeys()
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for i, k := range keys {
+			if n.Representation == RepresentationSortedSet {
+				cmds[i] = p.ZCard(ctx, k)
+			} else {
+				cmds[i] = p.SCard(ctx, k)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, cmd := range cmds {
+		count += int(cmd.Val())
+	}
+	return count, nil
+}
+
+// expired returns the keys of the registered timers that have expired.
+func (n *Namespace) expired(ctx context.Context) ([]string, error) {
+	switch n.Representation {
+	case RepresentationSet, RepresentationBucketed:
+		return n.expiredSets(ctx)
+	case RepresentationSortedSet:
+		return n.expiredSortedSet(ctx)
+	default:
+		return nil, fmt.Errorf("unknown representation %s", n.Representation)
+	}
+}
+
+// expiredSortedSet returns the registered timers that have expired when using
+// RepresentationSortedSet. The timer keys are still the source of truth, so
+// timers that are due according to their score but whose keys haven't expired
+// yet (e.g. because of clock skew) are left for the next Poll.
+func (n *Namespace) expiredSortedSet(ctx context.Context) ([]string, error) {
+	return n.expiredSortedSetBefore(ctx, strconv.FormatInt(time.Now().UnixMilli(), 10))
+}
+
+// expiredSortedSetBefore is like expiredSortedSet, but only considers timers
+// whose score is at most max, which is a ZRANGEBYSCORE bound. The timers are
+// returned in the order they were due.
+func (n *Namespace) expiredSortedSetBefore(ctx context.Context, max string) ([]string, error) {
+	var due []string
+	err := n.pollOp(ctx, func(ctx context.Context) (err error) {
+		due, err = n.client.r.ZRangeByScore(ctx, n.scheduledKey(), &redis.ZRangeBy{
+			Min: "-inf",
+			Max: max,
+		}).Result()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	cmds := make([]*redis.IntCmd, len(due))
+	err = n.pollOp(ctx, func(ctx context.Context) error {
+		_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+			for i, k := range due {
+				cmds[i] = p.Exists(ctx, n.timerKey(k))
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var expired []string
+	for i, cmd := range cmds {
+		if cmd.Val() == 0 {
+			expired = append(expired, due[i])
+		}
+	}
+	return expired, nil
+}
+
+// PollUntil is like Poll, but only fires the expired timers that were due
+// before cutoff, oldest first, and returns how many it fired. Calling it with
+// an increasing cutoff replays a backlog of timers, for example after an
+// outage, in time-ordered slices instead of all at once. Timers that are due
+// before cutoff but haven't expired yet are left for a later Poll, and like
+// Poll, it stops firing once MaxPollDuration has passed.
+//
+// Only RepresentationSortedSet stores the fire time of each timer, so the
+// other representations return ErrRepresentation.
+func (n *Namespace) PollUntil(ctx context.Context, cutoff time.Time) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	deadline := n.pollDeadline()
+	if n.Representation != RepresentationSortedSet {
+		return 0, fmt.Errorf("%w: PollUntil requires %s", ErrRepresentation, RepresentationSortedSet)
+	}
+	err := n.pollOp(ctx, n.checkMeta)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := n.expiredSortedSetBefore(ctx, "("+strconv.FormatInt(cutoff.UnixMilli(), 10))
+	if err != nil {
+		return 0, err
+	}
+	r := PollResult{Expired: len(keys)}
+	err = n.fire(ctx, keys, deadline, &r)
+	return r.Fired, err
+}
+
+// MigrateRepresentation moves the registered timers in this namespace from the
+// namespace's current representation to the given one, and updates the
+// namespace to use it. The timers themselves are left untouched, so they keep
+// their remaining time.
+//
+// The migration happens in a transaction that is retried if the registered
+// timers change while it's running, but clients that are still using the old
+// representation will keep writing to it afterwards. Stop or reconfigure every
+// other client before migrating.
+func (n *Namespace) MigrateRepresentation(ctx context.Context, to Representation) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	from := n.Representation
+	if from == to {
+		return nil
+	}
+	old := n.registeredKeysForRepresentation(from)
+	for {
+		err := n.client.r.Watch(ctx, func(tx *redis.Tx) error {
+			members, err := n.registeredMembersForRepresentation(ctx, from)
+			if err != nil {
+				return err
+			}
+			remaining, err := n.remaining(ctx, members)
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+				for i, k := range members {
+					key := n.registeredKeyForRepresentation(to, k)
+					if to == RepresentationSortedSet {
+						score := float64(now.Add(remaining[i]).UnixMilli())
+						if remaining[i] == math.MaxInt64 {
+							score = math.Inf(1)
+						}
+						p.ZAdd(ctx, key, redis.Z{Score: score, Member: k})
+					} else {
+						p.SAdd(ctx, key, k)
+					}
+				}
+				if n.IdleTTL > 0 {
+					for _, k := range n.registeredKeysForRepresentation(to) {
+						p.PExpire(ctx, k, n.IdleTTL)
+					}
+				}
+				p.Del(ctx, old...)
+				p.HSet(ctx, n.metaKey(), "representation", to.String())
+				return nil
+			})
+			return err
+		}, old...)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		n.Representation = to
+		return nil
+	}
+}
@@ -0,0 +1,113 @@
+// Package rimertest makes it easy to test code that uses rimer without running
+// a real Redis server. The clients it returns are backed by miniredis, which
+// supports every command that rimer uses.
+package rimertest
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/clarkmcc/rimer"
+	"github.com/redis/go-redis/v9"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// New returns a rimer client backed by a new in-memory Redis server. The server
+// is shut down when the test finishes.
+func New(t testing.TB) *rimer.Client {
+	c, _ := NewServer(t)
+	return c
+}
+
+// NewServer is like New, but also returns the underlying miniredis server so
+// that tests can manipulate it directly. Keys expire in real time, but tests
+// that don't want to wait can also call FastForward on the server to make the
+// timers expire sooner.
+func NewServer(t testing.TB) (*rimer.Client, *miniredis.Miniredis) {
+	m := miniredis.RunT(t)
+	stop := RealTime(m)
+	t.Cleanup(stop)
+	r := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { _ = r.Close() })
+	return rimer.New(r), m
+}
+
+// RealTime makes keys on the miniredis server expire in real time, which
+// miniredis doesn't do by itself, until the returned function is called.
+func RealTime(m *miniredis.Miniredis) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		last := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				m.FastForward(now.Sub(last))
+				last = now
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// Fail makes every command that the client runs for which match returns true
+// fail with err instead of reaching Redis, until the returned function is
+// called. This lets tests check how code that uses rimer behaves when Redis
+// fails part way through an operation. A pipeline fails as a whole if any of
+// its commands match. Scripts are run with EVALSHA, and fall back to EVAL if
+// Redis doesn't have them cached yet.
+func Fail(c *rimer.Client, match func(cmd redis.Cmder) bool, err error) (stop func()) {
+	h := &failHook{match: match, err: err}
+	h.enabled.Store(true)
+	c.Redis().AddHook(h)
+	return func() { h.enabled.Store(false) }
+}
+
+// FailCommand is like Fail, but matches every command with the given name, such
+// as "evalsha".
+func FailCommand(c *rimer.Client, name string, err error) (stop func()) {
+	return Fail(c, func(cmd redis.Cmder) bool { return cmd.Name() == name }, err)
+}
+
+type failHook struct {
+	match   func(cmd redis.Cmder) bool
+	err     error
+	enabled atomic.Bool
+}
+
+func (h *failHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *failHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if h.enabled.Load() && h.match(cmd) {
+			cmd.SetErr(h.err)
+			return h.err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *failHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !h.enabled.Load() {
+			return next(ctx, cmds)
+		}
+		for _, cmd := range cmds {
+			if h.match(cmd) {
+				for _, cmd := range cmds {
+					cmd.SetErr(h.err)
+				}
+				return h.err
+			}
+		}
+		return next(ctx, cmds)
+	}
+}
+
+var _ redis.Hook = (*failHook)(nil)
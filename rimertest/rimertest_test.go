@@ -0,0 +1,100 @@
+package rimertest
+
+import (
+	"context"
+	"errors"
+	"github.com/clarkmcc/rimer"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewServer(t *testing.T) {
+	ctx := context.Background()
+	c, m := NewServer(t)
+	ns := c.Namespace("foo")
+
+	require.NoError(t, ns.Create(ctx, "foo", time.Hour))
+	require.NoError(t, ns.Poll(ctx))
+
+	// Skip ahead instead of waiting for the timer to expire
+	m.FastForward(time.Hour)
+	require.NoError(t, ns.Poll(ctx))
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", key)
+}
+
+func TestFail(t *testing.T) {
+	ctx := context.Background()
+	c := New(t)
+	ns := c.Namespace("foo")
+	boom := errors.New("boom")
+
+	// A failed Create doesn't leave anything behind
+	stop := FailCommand(c, "evalsha", boom)
+	assert.ErrorIs(t, ns.Create(ctx, "foo", time.Millisecond), boom)
+	stop()
+	_, ok, err := ns.Describe(ctx, "foo")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Timers that fail to fire stay registered and fire on the next Poll
+	require.NoError(t, ns.Create(ctx, "foo", time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	stop = FailCommand(c, "evalsha", boom)
+	assert.ErrorIs(t, ns.Poll(ctx), boom)
+	stop()
+	require.NoError(t, ns.Poll(ctx))
+	key, err := ns.Next(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", key)
+}
+
+func TestFail_ReadOnly(t *testing.T) {
+	ctx := context.Background()
+	c := New(t)
+	ns := c.Namespace("foo")
+	require.NoError(t, ns.Create(ctx, "foo", time.Hour))
+
+	// Simulate a replica, which rejects every command that writes, including
+	// scripts that write
+	reads := map[string]bool{
+		"get": true, "mget": true, "exists": true, "pttl": true, "type": true,
+		"hget": true, "hmget": true, "hgetall": true, "hexists": true, "hlen": true, "hscan": true,
+		"smembers": true, "sismember": true, "scard": true, "sscan": true,
+		"zrange": true, "zscore": true, "zcard": true, "zscan": true,
+		"lrange": true, "llen": true, "lindex": true, "scan": true,
+	}
+	readOnly := errors.New("READONLY You can't write against a read only replica.")
+	stop := Fail(c, func(cmd redis.Cmder) bool { return !reads[cmd.Name()] }, readOnly)
+	defer stop()
+
+	assert.ErrorIs(t, ns.Create(ctx, "bar", time.Hour), rimer.ErrReadOnly)
+	assert.ErrorIs(t, ns.Poll(ctx), rimer.ErrReadOnly)
+	_, err := ns.NextWithTimeout(ctx, time.Second)
+	assert.ErrorIs(t, err, rimer.ErrReadOnly)
+
+	// Queries keep working
+	_, ok, err := ns.Describe(ctx, "foo")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	remaining, ok, err := ns.Remaining(ctx, "foo")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Greater(t, remaining, time.Duration(0))
+	timers, err := ns.List(ctx)
+	assert.NoError(t, err)
+	require.Len(t, timers, 1)
+	assert.Equal(t, "foo", timers[0].Key)
+	length, err := ns.QueueLength(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, length)
+
+	// Script errors from older versions of Redis are recognized too
+	stop()
+	stop = FailCommand(c, "evalsha", errors.New("ERR Error running script (call to f_0): @user_script:1: @user_script: 1: -READONLY You can't write against a read only replica."))
+	assert.ErrorIs(t, ns.Create(ctx, "bar", time.Hour), rimer.ErrReadOnly)
+}
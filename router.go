@@ -0,0 +1,101 @@
+package rimer
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"time"
+)
+
+// routerReplicas is the number of points that each client has on the hash
+// ring, which spreads the namespaces evenly across the clients.
+const routerReplicas = 100
+
+// Router spreads namespaces across many clients, each with its own Redis, by
+// consistent hashing of the namespace names. Every operation on a namespace
+// is routed to the same client, so the namespace's timers, registered set and
+// queue always live together on one Redis and polling and firing work exactly
+// as they do with a single client. Adding or removing a client only moves the
+// namespaces whose points on the ring change, but the timers of a moved
+// namespace stay behind on its old Redis, so the clients should be changed
+// while the namespaces that move are empty.
+//
+// The ring is built from the address and database of each client's Redis, so
+// every process that shares the namespaces must be given clients for the same
+// Redis instances, in any order.
+type Router struct {
+	// DefaultNamespace is the namespace used by the Create, Poll and Next
+	// methods on the router itself. Defaults to "default".
+	DefaultNamespace string
+
+	clients []*Client
+	ring    []routerPoint
+}
+
+// routerPoint is a point on the hash ring, which owns the namespaces that
+// hash to it or to anything after the previous point.
+type routerPoint struct {
+	hash   uint32
+	client int
+}
+
+// NewRouter creates a router that spreads namespaces across the given clients.
+// The clients are used as they are, so their Prefix, KeyBuilder and other
+// options should be set before the router is used. It panics if no clients
+// are given.
+func NewRouter(clients ...*Client) *Router {
+	if len(clients) == 0 {
+		panic("rimer: NewRouter requires at least one client")
+	}
+	r := &Router{
+		DefaultNamespace: defaultNamespace,
+		clients:          clients,
+		ring:             make([]routerPoint, 0, len(clients)*routerReplicas),
+	}
+	for i, c := range clients {
+		opts := c.r.Options()
+		for j := 0; j < routerReplicas; j++ {
+			id := fmt.Sprintf("%s/%d#%d", opts.Addr, opts.DB, j)
+			r.ring = append(r.ring, routerPoint{hash: crc32.ChecksumIEEE([]byte(id)), client: i})
+		}
+	}
+	sort.Slice(r.ring, func(i, j int) bool {
+		if r.ring[i].hash != r.ring[j].hash {
+			return r.ring[i].hash < r.ring[j].hash
+		}
+		return r.ring[i].client < r.ring[j].client
+	})
+	return r
+}
+
+// Client returns the client that the given namespace is routed to.
+func (r *Router) Client(ns string) *Client {
+	h := crc32.ChecksumIEEE([]byte(ns))
+	i := sort.Search(len(r.ring), func(i int) bool { return r.ring[i].hash >= h })
+	if i == len(r.ring) {
+		i = 0
+	}
+	return r.clients[r.ring[i].client]
+}
+
+// Namespace returns the given namespace on the client that it's routed to.
+// See Client.Namespace.
+func (r *Router) Namespace(ns string) *Namespace {
+	return r.Client(ns).Namespace(ns)
+}
+
+// Create creates a new timer in the default namespace. See Namespace.Create.
+func (r *Router) Create(ctx context.Context, key string, duration time.Duration) error {
+	return r.Namespace(r.DefaultNamespace).Create(ctx, key, duration)
+}
+
+// Poll polls the timers in the default namespace. See Namespace.Poll.
+func (r *Router) Poll(ctx context.Context) error {
+	return r.Namespace(r.DefaultNamespace).Poll(ctx)
+}
+
+// Next returns the next timer that fired in the default namespace. See Namespace.Next.
+func (r *Router) Next(ctx context.Context) (string, error) {
+	return r.Namespace(r.DefaultNamespace).Next(ctx)
+}
@@ -0,0 +1,114 @@
+package rimer
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// scheduleInterval is how often the timers created by Schedule are polled and
+// consumed.
+const scheduleInterval = 50 * time.Millisecond
+
+// Schedule calls fn in its own goroutine once delay has passed, like
+// time.AfterFunc, but backed by a timer in Redis with a unique key. The
+// returned function cancels the call if it hasn't been made yet.
+//
+// The timers are kept in a namespace of their own that belongs to this
+// Namespace value, "_schedule_<name>_<id>", so they never mix with the
+// namespace's other timers or with the functions scheduled by other
+// processes. The first call to Schedule starts polling that namespace and
+// consuming its timers in the background every 50ms, which stops again once
+// every scheduled function has been called or cancelled, so nothing else needs
+// to poll or consume it.
+//
+// Functions can't be stored in Redis, so Schedule isn't durable: a function
+// only lives as long as the Namespace value it was scheduled with, and the
+// timers of a process that exits before they fire are left behind and never
+// fire. Use Create and a consumer for work that must survive restarts. Errors
+// from polling, consuming and cancelling are passed to OnPollError.
+func (n *Namespace) Schedule(ctx context.Context, delay time.Duration, fn func()) (cancel func(), err error) {
+	key := strconv.FormatInt(n.client.random(), 36)
+	n.scheduleMu.Lock()
+	if n.scheduled == nil {
+		n.scheduled = make(map[string]func())
+		id := strconv.FormatInt(n.client.random(), 36)
+		n.schedule = n.client.namespace("_schedule_" + n.name + "_" + id)
+	}
+	n.scheduled[key] = fn
+	if !n.scheduling {
+		n.scheduling = true
+		go n.runScheduled()
+	}
+	schedule := n.schedule
+	n.scheduleMu.Unlock()
+	err = schedule.Create(ctx, key, delay)
+	if err != nil {
+		n.unschedule(key)
+		return nil, err
+	}
+	return func() {
+		if n.unschedule(key) == nil {
+			return
+		}
+		_, err := schedule.Cancel(context.Background(), key)
+		if err != nil && n.OnPollError != nil {
+			n.OnPollError(err)
+		}
+	}, nil
+}
+
+// unschedule forgets the function scheduled with the given key and returns it,
+// or nil if it was already called or cancelled.
+func (n *Namespace) unschedule(key string) func() {
+	n.scheduleMu.Lock()
+	defer n.scheduleMu.Unlock()
+	fn := n.scheduled[key]
+	delete(n.scheduled, key)
+	return fn
+}
+
+// runScheduled polls and consumes the timers created by Schedule until there
+// are no scheduled functions left.
+func (n *Namespace) runScheduled() {
+	ctx := context.Background()
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	report := func(err error) {
+		if err != nil && n.OnPollError != nil {
+			n.OnPollError(err)
+		}
+	}
+	for range ticker.C {
+		n.scheduleMu.Lock()
+		if len(n.scheduled) == 0 {
+			n.scheduling = false
+			n.scheduleMu.Unlock()
+			return
+		}
+		schedule := n.schedule
+		n.scheduleMu.Unlock()
+		err := schedule.Poll(ctx)
+		if err != nil {
+			report(err)
+			continue
+		}
+		for {
+			t, err := schedule.nextTimer(ctx, -1)
+			if err == ErrNoTimers {
+				break
+			}
+			if t.Key == "" {
+				// Redis failed, and we'll try again on the next tick.
+				report(err)
+				break
+			}
+			// The timer has fired even if its companion data couldn't be
+			// cleaned up, so the function is called regardless.
+			report(err)
+			if fn := n.unschedule(t.Key); fn != nil {
+				go fn()
+			}
+		}
+	}
+}
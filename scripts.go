@@ -0,0 +1,193 @@
+package rimer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// namespaceLua is prepended to the scripts that write timers so that they work
+// with any representation and keep the namespace's metadata up to date. Scripts
+// that use it must be run with runScript, which appends the namespace's _meta
+// key to KEYS, and the schema version, the representation, the current time in
+// milliseconds and the idle TTL in milliseconds to ARGV. nargs is the number of
+// arguments that were passed to the script itself.
+var namespaceLua = `
+local companionKeyCount = ` + strconv.Itoa(companionKeyCount) + `
+local metaKey = KEYS[#KEYS]
+local nargs = #ARGV - 5
+local version, representation = ARGV[#ARGV - 4], ARGV[#ARGV - 3]
+local rep, now = ARGV[#ARGV - 2], tonumber(ARGV[#ARGV - 1])
+local idleTTL = tonumber(ARGV[#ARGV])
+-- touch refreshes the idle TTL of a key that was just written to, see IdleTTL.
+local function touch(key)
+	if idleTTL > 0 then
+		redis.call('PEXPIRE', key, idleTTL)
+	end
+end
+local function register(key, member, ms)
+	if rep == 'zset' then
+		redis.call('ZADD', key, now + tonumber(ms), member)
+	else
+		redis.call('SADD', key, member)
+	end
+	touch(key)
+end
+local function unregister(key, member)
+	if rep == 'zset' then
+		redis.call('ZREM', key, member)
+	else
+		redis.call('SREM', key, member)
+	end
+end
+local function isRegistered(key, member)
+	if rep == 'zset' then
+		return redis.call('ZSCORE', key, member) ~= false
+	end
+	return redis.call('SISMEMBER', key, member) == 1
+end
+local function checkMeta()
+	local meta = redis.call('HMGET', metaKey, 'version', 'representation')
+	if meta[1] and tonumber(meta[1]) > tonumber(version) then
+		return 'SCHEMA namespace has schema version ' .. meta[1] .. ', this client supports up to ' .. version
+	end
+	if meta[2] and meta[2] ~= representation then
+		return 'REPRESENTATION namespace uses the ' .. meta[2] .. ' representation, not ' .. representation
+	end
+end
+-- untag removes a timer from the tag indexes that are listed for it in the
+-- tags hash, see tagRecord.
+local function untag(key, member)
+	local raw = redis.call('HGET', key, member)
+	if raw then
+		for _, index in ipairs(cjson.decode(raw).indexes) do
+			redis.call('SREM', index, member)
+		end
+	end
+end
+-- forget removes a timer from the companion hashes and the tag indexes, given
+-- the keys returned by companionKeys starting at KEYS[i].
+local function forget(i, member)
+	untag(KEYS[i + companionKeyCount - 1], member)
+	for j = i, i + companionKeyCount - 1 do
+		redis.call('HDEL', KEYS[j], member)
+	end
+end
+-- cleanup removes every trace of a timer, given the keys returned by
+-- cleanupKeys starting at KEYS[i]. Returns whether there was anything to remove.
+local function cleanup(i, member)
+	local removed = redis.call('DEL', KEYS[i])
+	if isRegistered(KEYS[i + 1], member) then
+		unregister(KEYS[i + 1], member)
+		removed = 1
+	end
+	removed = removed + redis.call('LREM', KEYS[i + 2], 0, member)
+	forget(i + 3, member)
+	return removed > 0
+end
+-- parseToken splits an entry of the tokens hash,
+-- "<token>[:<fired>[:<delivery>[:r]]]", into the fencing token, the time that
+-- the timer fired or 0, the timer's delivery ID, which is the token unless the
+-- timer was delivered again, and whether the timer was returned to the queue
+-- by Reclaim after it was re-armed.
+local function parseToken(entry)
+	local parts = {}
+	for part in string.gmatch(entry, '[^:]+') do
+		table.insert(parts, part)
+	end
+	local token = tonumber(parts[1])
+	local fired = tonumber(parts[2] or '0')
+	local delivery = parts[3] or parts[1]
+	return token, fired, delivery, parts[4] == 'r'
+end
+local function writeMeta()
+	redis.call('HSETNX', metaKey, 'version', version)
+	redis.call('HSETNX', metaKey, 'representation', representation)
+end
+`
+
+// companionKeyCount is the number of keys returned by companionKeys.
+const companionKeyCount = 13
+
+// companionKeys returns the hashes that hold data about a timer alongside it,
+// keyed by the timer's key. Anything stored about a timer must be kept in one
+// of these so that it's cleaned up along with the timer, see cleanupKeys. The
+// only exception are dependency records, which outlive the timer until they're
+// released, see releaseDependents. Scripts rely on the order of the keys, and
+// the tags hash must come last.
+func (n *Namespace) companionKeys() [companionKeyCount]string {
+	return [...]string{n.recurringKey(), n.tokensKey(), n.labelsKey(), n.valuesKey(), n.warningsKey(), n.fieldsKey(), n.lazyKey(), n.metadataKey(), n.guardsKey(), n.replaysKey(), n.valuedKey(), n.versionsKey(), n.tagsKey()}
+}
+
+// cleanupKeys returns the keys that the cleanup function in namespaceLua needs
+// to remove every trace of the given timer: the timer itself, the key that it's
+// registered in, the queue and the companion hashes.
+func (n *Namespace) cleanupKeys(key string) []string {
+	companions := n.companionKeys()
+	return append([]string{n.timerKey(key), n.registeredKeyFor(key), n.queueKeyFor(key)}, companions[:]...)
+}
+
+// cleanupKeysMany returns the keys returned by cleanupKeys for each of the given
+// timers in turn.
+func (n *Namespace) cleanupKeysMany(keys []string) []string {
+	redisKeys := make([]string, 0, (3+companionKeyCount)*len(keys))
+	for _, k := range keys {
+		redisKeys = append(redisKeys, n.cleanupKeys(k)...)
+	}
+	return redisKeys
+}
+
+// newNamespaceScript creates a script that has access to the functions in
+// namespaceLua.
+func newNamespaceScript(src string) *redis.Script {
+	return redis.NewScript(namespaceLua + src)
+}
+
+// runScript runs a script created with newNamespaceScript.
+func (n *Namespace) runScript(ctx context.Context, s *redis.Script, keys []string, args ...any) *redis.Cmd {
+	keys, args = n.scriptArgs(keys, args)
+	cmd := s.Run(ctx, n.client.r, keys, args...)
+	if err := cmd.Err(); err != nil {
+		cmd.SetErr(scriptError(err))
+	}
+	return cmd
+}
+
+// scriptArgs appends the keys and arguments that every script created with
+// newNamespaceScript expects to the script's own, see namespaceLua.
+func (n *Namespace) scriptArgs(keys []string, args []any) ([]string, []any) {
+	keys = append(keys, n.metaKey())
+	args = append(args, LatestSchemaVersion, n.Representation.String(), n.Representation.lua(), time.Now().UnixMilli(), n.IdleTTL.Milliseconds())
+	return keys, args
+}
+
+// scriptError translates the errors returned by our scripts into the
+// corresponding sentinel errors.
+func scriptError(err error) error {
+	code, msg, _ := strings.Cut(err.Error(), " ")
+	switch code {
+	case "SCHEMA":
+		return fmt.Errorf("%w: %s", ErrSchemaVersion, msg)
+	case "REPRESENTATION":
+		return fmt.Errorf("%w: %s", ErrRepresentation, msg)
+	default:
+		return readOnlyError(err)
+	}
+}
+
+// readOnlyError wraps the errors that Redis returns for writes to a read-only
+// replica in ErrReadOnly. Older versions of Redis report a write made by a
+// script as a script error that contains the READONLY error.
+func readOnlyError(err error) error {
+	if err == nil || errors.Is(err, ErrReadOnly) {
+		return err
+	}
+	if s := err.Error(); strings.HasPrefix(s, "READONLY ") || strings.Contains(s, "-READONLY ") {
+		return fmt.Errorf("%w: %s", ErrReadOnly, s)
+	}
+	return err
+}
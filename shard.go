@@ -0,0 +1,18 @@
+package rimer
+
+import (
+	"context"
+	"fmt"
+)
+
+// NextShard is like NextTimer, but only pops timers from the given shard of the
+// queue, see QueueShards, so that each consumer can be assigned a shard of its
+// own. Shards are numbered from 0 to QueueShards-1, and a namespace whose
+// queue isn't sharded has a single shard 0.
+func (n *Namespace) NextShard(ctx context.Context, shard int) (FiredTimer, error) {
+	queues := n.queueKeys()
+	if shard < 0 || shard >= len(queues) {
+		return FiredTimer{}, fmt.Errorf("shard %d out of range for %d shards", shard, len(queues))
+	}
+	return n.nextTimerFrom(ctx, queues[shard:shard+1], 0)
+}
@@ -0,0 +1,171 @@
+package rimer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapshotVersion is the version of the format written by Export.
+const snapshotVersion = 1
+
+// snapshot is the format written by Export and read by Import.
+type snapshot struct {
+	Version int             `json:"version"`
+	At      time.Time       `json:"at"`
+	Timers  []snapshotTimer `json:"timers"`
+}
+
+type snapshotTimer struct {
+	Key         string            `json:"key"`
+	RemainingMs int64             `json:"remaining_ms"`
+	IntervalMs  int64             `json:"interval_ms,omitempty"`
+	Cron        string            `json:"cron,omitempty"`
+	Label       string            `json:"label,omitempty"`
+	Value       []byte            `json:"value,omitempty"`
+	Fields      map[string][]byte `json:"fields,omitempty"`
+	LazyValue   bool              `json:"lazy_value,omitempty"`
+	Metadata    Metadata          `json:"metadata,omitempty"`
+	GuardKey    string            `json:"guard_key,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+// Export returns a snapshot of the pending timers in this namespace, along with
+// their remaining time, recurrence or cron expression, label, tags, value, fields, metadata, guard key
+// and whether their value is resolved lazily, that can be restored with Import
+// into this or any other namespace. Timers that have already fired and are
+// waiting in the queue aren't included.
+func (n *Namespace) Export(ctx context.Context) ([]byte, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	infos, err := n.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := snapshot{Version: snapshotVersion, At: time.Now(), Timers: make([]snapshotTimer, 0, len(infos))}
+	var intervals, values, fields, lazy, metadata, guards, tags []any
+	if len(infos) > 0 {
+		keys := make([]string, len(infos))
+		for i, info := range infos {
+			keys[i] = info.Key
+		}
+		intervals, err = n.client.r.HMGet(ctx, n.recurringKey(), keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		values, err = n.client.r.HMGet(ctx, n.valuesKey(), keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		fields, err = n.client.r.HMGet(ctx, n.fieldsKey(), keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		lazy, err = n.client.r.HMGet(ctx, n.lazyKey(), keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		metadata, err = n.client.r.HMGet(ctx, n.metadataKey(), keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		guards, err = n.client.r.HMGet(ctx, n.guardsKey(), keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+		tags, err = n.client.r.HMGet(ctx, n.tagsKey(), keys...).Result()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, info := range infos {
+		if info.Remaining == math.MaxInt64 {
+			// Timers without an expiry never fire, so there's nothing to restore.
+			continue
+		}
+		t := snapshotTimer{Key: info.Key, RemainingMs: info.Remaining.Milliseconds(), Label: info.Label}
+		if v, ok := intervals[i].(string); ok && strings.HasPrefix(v, cronPrefix) {
+			t.Cron = strings.TrimPrefix(v, cronPrefix)
+		} else if ok {
+			t.IntervalMs, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid interval for timer %s: %w", info.Key, err)
+			}
+		}
+		if v, ok := values[i].(string); ok {
+			t.Value, err = n.client.decodeValue([]byte(v))
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for timer %s: %w", info.Key, err)
+			}
+		}
+		if v, ok := fields[i].(string); ok {
+			err = json.Unmarshal([]byte(v), &t.Fields)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fields for timer %s: %w", info.Key, err)
+			}
+		}
+		if v, ok := metadata[i].(string); ok {
+			err = json.Unmarshal([]byte(v), &t.Metadata)
+			if err != nil {
+				return nil, fmt.Errorf("invalid metadata for timer %s: %w", info.Key, err)
+			}
+		}
+		if v, ok := tags[i].(string); ok {
+			var r tagRecord
+			err = json.Unmarshal([]byte(v), &r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tags for timer %s: %w", info.Key, err)
+			}
+			t.Tags = r.Tags
+		}
+		t.LazyValue = lazy[i] != nil
+		t.GuardKey, _ = guards[i].(string)
+		s.Timers = append(s.Timers, t)
+	}
+	return json.Marshal(s)
+}
+
+// Import restores the timers in a snapshot created by Export into this
+// namespace, replacing any timers with the same keys. The timers fire at the
+// same time that they would have fired at when the snapshot was taken, so the
+// time that passed since then is subtracted from their remaining time, and
+// timers that are already overdue fire as soon as possible.
+func (n *Namespace) Import(ctx context.Context, data []byte) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	var s snapshot
+	err := json.Unmarshal(data, &s)
+	if err != nil {
+		return err
+	}
+	if s.Version != snapshotVersion {
+		return fmt.Errorf("unsupported snapshot version %d", s.Version)
+	}
+	elapsed := time.Since(s.At)
+	for _, t := range s.Timers {
+		remaining := time.Duration(t.RemainingMs)*time.Millisecond - elapsed
+		if remaining < time.Millisecond {
+			remaining = time.Millisecond
+		}
+		_, err = n.create(ctx, t.Key, remaining, createParams{
+			interval:  time.Duration(t.IntervalMs) * time.Millisecond,
+			cron:      t.Cron,
+			label:     t.Label,
+			tags:      t.Tags,
+			value:     t.Value,
+			fields:    t.Fields,
+			lazyValue: t.LazyValue,
+			metadata:  t.Metadata,
+			guardKey:  t.GuardKey,
+			unscaled:  true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
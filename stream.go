@@ -0,0 +1,149 @@
+package rimer
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Queue is the data structure that Poll delivers fired timers to.
+type Queue int
+
+const (
+	// QueueList pushes fired timers onto a list, and each timer is popped by a
+	// single call to Next. This is the default.
+	QueueList Queue = iota
+
+	// QueueStream adds fired timers to a Redis Stream, and consumers read them
+	// as part of a consumer group using NextGroup. Each timer is delivered to
+	// one consumer in every group, and stays pending until it's acknowledged
+	// with Ack, so timers held by a consumer that crashed can be recovered with
+	// ClaimPending.
+	QueueStream
+)
+
+// String returns the name of the queue.
+func (q Queue) String() string {
+	switch q {
+	case QueueList:
+		return "list"
+	case QueueStream:
+		return "stream"
+	default:
+		return "Queue(" + strconv.Itoa(int(q)) + ")"
+	}
+}
+
+// NextGroup returns the next timer in the stream that hasn't been delivered to
+// any other consumer in the group, blocking until one is available. The group
+// is created the first time it's used, starting from the beginning of the
+// stream. The timer stays pending in the group until it's acknowledged with
+// Ack. Recurring timers are re-armed when they're first delivered.
+//
+// NextGroup returns ErrQueueMismatch if the namespace doesn't use QueueStream.
+func (n *Namespace) NextGroup(ctx context.Context, group, consumer string) (t FiredTimer, err error) {
+	if n.Queue != QueueStream {
+		return t, ErrQueueMismatch
+	}
+	defer n.present(ctx)()
+	for {
+		var res []redis.XStream
+		res, err = n.client.r.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    group,
+			Consumer: consumer,
+			Streams:  []string{n.streamKey(), ">"},
+			Count:    1,
+		}).Result()
+		if err != nil && strings.HasPrefix(err.Error(), "NOGROUP") {
+			err = n.createGroup(ctx, group)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if len(res) != 1 || len(res[0].Messages) != 1 {
+			return t, fmt.Errorf("expected 1 message, got %d streams", len(res))
+		}
+		t = firedTimerFromMessage(res[0].Messages[0])
+		err = n.rearm(ctx, &t)
+		return
+	}
+}
+
+// Ack acknowledges that the timers with the given stream entry IDs have been
+// handled by a consumer in the group, so they're no longer pending.
+func (n *Namespace) Ack(ctx context.Context, group string, ids ...string) error {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueStream {
+		return ErrQueueMismatch
+	}
+	return n.client.r.XAck(ctx, n.streamKey(), group, ids...).Err()
+}
+
+// ClaimPending transfers the timers that have been pending in the group for
+// longer than minIdle to the given consumer, and returns them. This recovers
+// timers that were delivered to a consumer that crashed before acknowledging
+// them. Claimed timers must still be acknowledged with Ack.
+func (n *Namespace) ClaimPending(ctx context.Context, group, consumer string, minIdle time.Duration) ([]FiredTimer, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueStream {
+		return nil, ErrQueueMismatch
+	}
+	defer n.present(ctx)()
+	var timers []FiredTimer
+	start := "0-0"
+	for {
+		msgs, next, err := n.client.r.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   n.streamKey(),
+			Group:    group,
+			Consumer: consumer,
+			MinIdle:  minIdle,
+			Start:    start,
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			t := firedTimerFromMessage(msg)
+			t.Key, t.Warning = splitWarningKey(t.Key)
+			timers = append(timers, t)
+		}
+		if next == "0-0" {
+			return timers, nil
+		}
+		start = next
+	}
+}
+
+// createGroup creates the consumer group on the stream, creating the stream if
+// it doesn't exist yet. It's not an error if the group already exists.
+func (n *Namespace) createGroup(ctx context.Context, group string) error {
+	err := n.client.r.XGroupCreateMkStream(ctx, n.streamKey(), group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// firedTimerFromMessage returns the fired timer that the stream entry was
+// added for.
+func firedTimerFromMessage(msg redis.XMessage) FiredTimer {
+	key, _ := msg.Values["key"].(string)
+	token, _ := msg.Values["token"].(string)
+	t := FiredTimer{Key: key, ID: msg.ID, DeliveryID: msg.ID}
+	t.Token, _ = strconv.ParseInt(token, 10, 64)
+	if fired, ok := msg.Values["fired"].(string); ok {
+		if ms, err := strconv.ParseInt(fired, 10, 64); err == nil {
+			t.FiredAt = time.UnixMilli(ms)
+		}
+	}
+	return t
+}
@@ -0,0 +1,46 @@
+package rimer
+
+import (
+	"context"
+)
+
+// Subscribe returns a channel that receives the key of every timer that fires
+// in this namespace, as soon as Poll notices it has expired. This is backed by
+// Redis pub/sub and is best-effort only: timers that fire while the subscriber
+// isn't connected are never delivered, and every subscriber receives every key.
+// The timers are still queued as usual, so consumers that need guaranteed
+// delivery should use Next instead.
+//
+// The channel is closed once the context is cancelled.
+func (n *Namespace) Subscribe(ctx context.Context) (<-chan string, error) {
+	sub := n.client.r.Subscribe(ctx, n.firedChannel())
+	// Wait for the subscription to be confirmed so that callers don't miss
+	// any timers that fire right after we return.
+	_, err := sub.Receive(ctx)
+	if err != nil {
+		_ = sub.Close()
+		return nil, err
+	}
+	out := make(chan string)
+	go func() {
+		defer close(out)
+		defer sub.Close()
+		msgs := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-msgs:
+				if !ok {
+					return
+				}
+				select {
+				case out <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
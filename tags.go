@@ -0,0 +1,38 @@
+package rimer
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+)
+
+// FindByTag returns the keys of the timers that were created with the given tag
+// value, see CreateOptions.Tags, without scanning the namespace. This includes
+// timers that have expired but haven't been consumed yet, as timers are only
+// removed from the index once they're consumed or cancelled. Recurring timers
+// stay in the index until they're replaced or cancelled.
+func (n *Namespace) FindByTag(ctx context.Context, tag, value string) ([]string, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	return n.client.r.SMembers(ctx, n.indexKey(tag, value)).Result()
+}
+
+// tagRecord is what's stored about a timer's tags in the tags hash: the tags
+// themselves, so that they can be returned when the timer fires, and the keys
+// of the indexes that the timer is in, so that scripts know which indexes to
+// remove it from.
+type tagRecord struct {
+	Tags    map[string]string `json:"tags"`
+	Indexes []string          `json:"indexes"`
+}
+
+// encodeTags returns the JSON tag record for the given tags.
+func (n *Namespace) encodeTags(tags map[string]string) (string, error) {
+	r := tagRecord{Tags: tags, Indexes: make([]string, 0, len(tags))}
+	for tag, value := range tags {
+		r.Indexes = append(r.Indexes, n.indexKey(tag, value))
+	}
+	sort.Strings(r.Indexes)
+	b, err := json.Marshal(r)
+	return string(b), err
+}
@@ -0,0 +1,107 @@
+package rimer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// transferQueueScript moves every timer from the KEYS[1] queue onto the KEYS[2]
+// queue, keeping their order, along with their data in the companion hashes of
+// the source namespace, which start at KEYS[3], and those of the destination
+// namespace that follow them. The source's tag records refer to its own
+// indexes, so they're removed from them and returned instead of moved, for the
+// caller to index the timers in the destination. Returns the number of timers
+// that were moved, followed by pairs of keys and tag records.
+var transferQueueScript = newNamespaceScript(`
+local src, dst = 3, 3 + companionKeyCount
+local moved, tags = 0, {}
+while true do
+	local member = redis.call('LMOVE', KEYS[1], KEYS[2], 'RIGHT', 'LEFT')
+	if not member then
+		break
+	end
+	moved = moved + 1
+	local record = redis.call('HGET', KEYS[src + companionKeyCount - 1], member)
+	if record then
+		tags[#tags + 1] = member
+		tags[#tags + 1] = record
+	end
+	untag(KEYS[src + companionKeyCount - 1], member)
+	for j = 0, companionKeyCount - 2 do
+		local v = redis.call('HGET', KEYS[src + j], member)
+		if v then
+			redis.call('HSET', KEYS[dst + j], member, v)
+			redis.call('HDEL', KEYS[src + j], member)
+		end
+	end
+	redis.call('HDEL', KEYS[src + companionKeyCount - 1], member)
+end
+touch(KEYS[2])
+table.insert(tags, 1, moved)
+return tags
+`)
+
+// tagScript indexes the timer in ARGV[1] by the ARGV[2] tag record, storing it
+// in the KEYS[1] tags hash.
+var tagScript = newNamespaceScript(`
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+for _, index in ipairs(cjson.decode(ARGV[2]).indexes) do
+	redis.call('SADD', index, ARGV[1])
+end
+return 1
+`)
+
+// TransferQueue moves every timer that has fired but hasn't been consumed yet
+// from this namespace's queue onto the head of dest's queue, as if they had
+// just fired there, keeping their order. Their labels, values, fields, tags
+// and recurrence move along with them. It returns the number of timers that
+// were moved. This is useful for draining a namespace that's being retired, or
+// for rebalancing work between consumers. Each of this namespace's queues is
+// moved atomically, but the tags are indexed in dest right after.
+//
+// TransferQueue returns ErrQueueMismatch if either namespace uses QueueStream,
+// or if dest uses QueueShards.
+func (n *Namespace) TransferQueue(ctx context.Context, dest *Namespace) (int, error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList || dest.Queue != QueueList || dest.QueueShards > 1 {
+		return 0, ErrQueueMismatch
+	}
+	srcCompanions, dstCompanions := n.companionKeys(), dest.companionKeys()
+	total := 0
+	for _, queue := range n.queueKeys() {
+		if queue == dest.queueKey() {
+			continue
+		}
+		keys := append([]string{queue, dest.queueKey()}, srcCompanions[:]...)
+		keys = append(keys, dstCompanions[:]...)
+		res, err := n.runScript(ctx, transferQueueScript, keys).Slice()
+		if err != nil {
+			return total, err
+		}
+		if len(res) == 0 || len(res)%2 != 1 {
+			return total, fmt.Errorf("unexpected transfer result of %d values", len(res))
+		}
+		moved, _ := res[0].(int64)
+		total += int(moved)
+		for i := 1; i < len(res); i += 2 {
+			key, _ := res[i].(string)
+			raw, _ := res[i+1].(string)
+			var r tagRecord
+			err = json.Unmarshal([]byte(raw), &r)
+			if err != nil {
+				return total, fmt.Errorf("decoding tags of timer %s: %w", key, err)
+			}
+			record, err := dest.encodeTags(r.Tags)
+			if err != nil {
+				return total, err
+			}
+			err = dest.runScript(ctx, tagScript, []string{dest.tagsKey()}, key, record).Err()
+			if err != nil {
+				return total, err
+			}
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,151 @@
+package rimer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// NamespaceTx is a Redis transaction that timers are created and cancelled in
+// alongside the caller's own commands, see Client.WithTx.
+type NamespaceTx struct {
+	// Tx is the connection that the transaction runs on. Commands that are
+	// run on it directly are executed right away rather than queued, which is
+	// how the values of watched keys are read before deciding what to write.
+	Tx *redis.Tx
+
+	// Pipe queues commands onto the transaction's MULTI/EXEC. The caller's own
+	// writes should be queued on it so that they're applied together with
+	// the timers.
+	Pipe redis.Pipeliner
+
+	client *Client
+	ctx    context.Context
+	// after are run once the transaction has been executed, to check the
+	// results of the queued operations and run the steps that follow them.
+	after []func(ctx context.Context) error
+}
+
+// WithTx runs fn with a transaction that rimer operations can be queued on
+// along with the caller's own commands, and then executes them all in a single
+// MULTI/EXEC, so that, for example, a domain record and the timer that acts on
+// it are written together or not at all. Nothing is written if fn returns an
+// error.
+//
+// The watched keys are WATCHed for the whole call, so fn can read them with
+// NamespaceTx.Tx and base its writes on what it read. If any of them is
+// modified by someone else before the transaction is executed, nothing is
+// written and redis.TxFailedErr is returned, in which case the caller would
+// usually retry the whole call. This is the usual optimistic locking of Redis
+// transactions, and the only way to make the writes conditional: once the
+// transaction is executed, Redis never rolls it back, so a timer that isn't
+// created because of CreateOptions.OnlyIfAbsent or ExpectedVersion doesn't
+// undo the caller's writes, it only makes WithTx return the error.
+//
+// The steps that follow creating and cancelling a timer, such as calling
+// OnCreate, creating its warnings and releasing the timers that depend on it,
+// run after the transaction was executed, and aren't part of it.
+func (c *Client) WithTx(ctx context.Context, fn func(tx *NamespaceTx) error, watch ...string) error {
+	ctx, cancel := c.withDefaultTimeout(ctx)
+	defer cancel()
+	return c.r.Watch(ctx, func(rtx *redis.Tx) error {
+		tx := &NamespaceTx{Tx: rtx, Pipe: rtx.TxPipeline(), client: c, ctx: ctx}
+		if err := fn(tx); err != nil {
+			tx.Pipe.Discard()
+			return err
+		}
+		_, err := tx.Pipe.Exec(ctx)
+		if errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+		// The errors of our commands are reported by the operations that
+		// queued them, and Exec only returns the first error, which may be
+		// from one of the caller's commands.
+		var errs []error
+		for _, after := range tx.after {
+			if err := after(ctx); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if len(errs) == 0 {
+			return err
+		}
+		return errors.Join(errs...)
+	}, watch...)
+}
+
+// evalScript queues the script created with newNamespaceScript onto the
+// transaction. The whole script is sent with EVAL, since a script that's
+// missing from the script cache would fail at EXEC, after the rest of the
+// transaction was applied.
+func (tx *NamespaceTx) evalScript(n *Namespace, s *redis.Script, keys []string, args ...any) *redis.Cmd {
+	keys, args = n.scriptArgs(keys, args)
+	return s.Eval(tx.ctx, tx.Pipe, keys, args...)
+}
+
+// Create queues the creation of a timer in the given namespace onto the
+// transaction, see Namespace.Create.
+func (tx *NamespaceTx) Create(n *Namespace, key string, duration time.Duration) error {
+	return tx.CreateWithOptions(n, key, duration, CreateOptions{})
+}
+
+// CreateWithOptions queues the creation of a timer in the given namespace onto
+// the transaction, see Namespace.CreateWithOptions. Invalid options are
+// reported right away, while ErrTimerExists and ErrVersionConflict are
+// returned by WithTx.
+func (tx *NamespaceTx) CreateWithOptions(n *Namespace, key string, duration time.Duration, opts CreateOptions) error {
+	if n.client != tx.client {
+		return fmt.Errorf("namespace %s belongs to another client", n.name)
+	}
+	p := n.scaleParams(opts.params())
+	duration, err := n.limitDuration(n.scaleDuration(duration))
+	if err != nil {
+		return err
+	}
+	keys, args, err := n.createArgs(key, duration, p)
+	if err != nil {
+		return err
+	}
+	cmd := tx.evalScript(n, createScript, keys, args...)
+	tx.after = append(tx.after, func(ctx context.Context) error {
+		res, err := cmd.Int64()
+		if err != nil {
+			return scriptError(err)
+		}
+		created, err := createResult(key, res)
+		if err != nil || !created {
+			return err
+		}
+		return n.created(ctx, key, duration, p)
+	})
+	return nil
+}
+
+// Cancel queues the cancellation of a timer in the given namespace onto the
+// transaction, see Namespace.Cancel. The timer's warnings are looked up right
+// away, so warnings that are created concurrently may not be cancelled.
+func (tx *NamespaceTx) Cancel(n *Namespace, key string) error {
+	if n.client != tx.client {
+		return fmt.Errorf("namespace %s belongs to another client", n.name)
+	}
+	keys := []string{key}
+	warnings, err := n.warnings(tx.ctx, keys)
+	if err != nil {
+		return err
+	}
+	if len(warnings) > 0 {
+		redisKeys, args := n.cancelArgs(warnings, "", false)
+		tx.evalScript(n, cancelScript, redisKeys, args...)
+	}
+	redisKeys, args := n.cancelArgs(keys, "", true)
+	cmd := tx.evalScript(n, cancelScript, redisKeys, args...)
+	tx.after = append(tx.after, func(ctx context.Context) error {
+		if err := cmd.Err(); err != nil {
+			return scriptError(err)
+		}
+		return n.releaseDependents(ctx, keys, false)
+	})
+	return nil
+}
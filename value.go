@@ -0,0 +1,68 @@
+package rimer
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+)
+
+// popValueScript pops the next timer from the KEYS[1] queue using the ARGV[1]
+// command, and takes its value out of the KEYS[2] values hash, unless the
+// timer is recurring according to the KEYS[3] hash, in which case the value
+// is kept for the next time it fires. Returns false if the queue is empty, and
+// the key and the value, or false if the timer doesn't have one, otherwise.
+var popValueScript = newNamespaceScript(`
+local key = redis.call(ARGV[1], KEYS[1])
+if not key then
+	return false
+end
+local value = redis.call('HGET', KEYS[2], key)
+if redis.call('HEXISTS', KEYS[3], key) == 0 then
+	redis.call('HDEL', KEYS[2], key)
+end
+return {key, value}
+`)
+
+// NextWithValue pops the next timer from the queue along with its value, see
+// CreateOptions, and returns ErrNoTimers if the queue is empty. Unlike Next,
+// which reads the value after popping the timer, the timer is popped and its
+// value taken in a single atomic step, so a concurrent Cancel can't remove the
+// value in between. NextWithValue doesn't block, use Subscribe to wait for
+// timers to fire.
+//
+// NextWithValue returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) NextWithValue(ctx context.Context) (t FiredTimer, err error) {
+	ctx, cancel := n.client.withDefaultTimeout(ctx)
+	defer cancel()
+	if n.Queue != QueueList {
+		return t, ErrQueueMismatch
+	}
+	defer n.present(ctx)()
+	var res []any
+	for _, queue := range n.queueKeys() {
+		res, err = n.runScript(ctx, popValueScript,
+			[]string{queue, n.valuesKey(), n.recurringKey()},
+			n.popCommand()).Slice()
+		if err != redis.Nil {
+			break
+		}
+	}
+	if err == redis.Nil {
+		return t, ErrNoTimers
+	}
+	if err != nil {
+		return
+	}
+	if len(res) != 2 {
+		return t, fmt.Errorf("expected 2 values, got %d", len(res))
+	}
+	t.Key, _ = res[0].(string)
+	if v, ok := res[1].(string); ok {
+		t.Value, err = n.client.decodeValue([]byte(v))
+		if err != nil {
+			return t, fmt.Errorf("decoding value of timer %s: %w", t.Key, err)
+		}
+	}
+	err = n.rearm(ctx, &t)
+	return
+}
@@ -0,0 +1,70 @@
+package rimer
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// waitEmptyInterval is how often WaitEmpty checks whether the namespace is
+// empty.
+const waitEmptyInterval = 50 * time.Millisecond
+
+// WaitEmpty blocks until this namespace has no registered timers and nothing
+// waiting in its queue, or until the context is cancelled, in which case the
+// context's error is returned. It checks every 50ms, which makes it useful for
+// synchronizing tests and for draining a namespace before shutting down.
+//
+// WaitEmpty doesn't poll or consume the timers itself, so something else must
+// be doing both for it to return. Timers that are created concurrently keep
+// the namespace from being empty, so a steady stream of creators can keep it
+// from ever returning, and a timer may be created right after it returns.
+// Timers that have been consumed but are still claimed, see Claim, don't count.
+//
+// WaitEmpty returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) WaitEmpty(ctx context.Context) error {
+	if n.Queue != QueueList {
+		return ErrQueueMismatch
+	}
+	ticker := time.NewTicker(waitEmptyInterval)
+	defer ticker.Stop()
+	for {
+		empty, err := n.empty(ctx)
+		if err != nil || empty {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// empty returns whether the namespace has no registered timers and nothing in
+// its queue, using a single pipeline.
+func (n *Namespace) empty(ctx context.Context) (bool, error) {
+	var cmds []*redis.IntCmd
+	_, err := n.client.r.Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, k := range n.registeredKeys() {
+			if n.Representation == RepresentationSortedSet {
+				cmds = append(cmds, p.ZCard(ctx, k))
+			} else {
+				cmds = append(cmds, p.SCard(ctx, k))
+			}
+		}
+		for _, k := range n.queueKeys() {
+			cmds = append(cmds, p.LLen(ctx, k))
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,86 @@
+package rimer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// warningSeparator separates a timer's key from the offset of one of its
+// warnings in the key of the warning timer, see CreateOptions.Warnings.
+const warningSeparator = "\x00warning:"
+
+// warningKey returns the key of the warning timer that fires offset before the
+// timer with the given key.
+func warningKey(key string, offset time.Duration) string {
+	return key + warningSeparator + strconv.FormatInt(offset.Milliseconds(), 10)
+}
+
+// splitWarningKey returns the key of the timer that the warning timer with the
+// given key warns about and its offset, or the key itself and 0 if it's not a
+// warning timer.
+func splitWarningKey(key string) (string, time.Duration) {
+	i := strings.LastIndex(key, warningSeparator)
+	if i < 0 {
+		return key, 0
+	}
+	ms, err := strconv.ParseInt(key[i+len(warningSeparator):], 10, 64)
+	if err != nil || ms <= 0 {
+		return key, 0
+	}
+	return key[:i], time.Duration(ms) * time.Millisecond
+}
+
+// encodeWarnings validates the offsets of a timer's warnings and returns them
+// as the JSON array of milliseconds that's stored in the warnings hash.
+func encodeWarnings(duration time.Duration, offsets []time.Duration) (string, error) {
+	ms := make([]int64, len(offsets))
+	for i, offset := range offsets {
+		if offset <= 0 || offset >= duration {
+			return "", fmt.Errorf("%w: warning offset %s must be between 0 and %s", ErrInvalidDuration, offset, duration)
+		}
+		ms[i], _ = durationMs(offset)
+	}
+	sort.Slice(ms, func(i, j int) bool { return ms[i] < ms[j] })
+	b, err := json.Marshal(ms)
+	return string(b), err
+}
+
+// createWarnings creates the warning timers of the timer with the given key and
+// duration. The duration and offsets have already been scaled, see
+// scaleParams, so they aren't scaled again.
+func (n *Namespace) createWarnings(ctx context.Context, key string, duration time.Duration, offsets []time.Duration) error {
+	timers := make(map[string]time.Duration, len(offsets))
+	for _, offset := range offsets {
+		timers[warningKey(key, offset)] = duration - offset
+	}
+	return n.createMany(ctx, timers, true, nil)
+}
+
+// warnings returns the keys of the warning timers of the given timers.
+func (n *Namespace) warnings(ctx context.Context, keys []string) ([]string, error) {
+	records, err := n.client.r.HMGet(ctx, n.warningsKey(), keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	var warnings []string
+	for i, record := range records {
+		raw, ok := record.(string)
+		if !ok {
+			continue
+		}
+		var offsets []int64
+		err = json.Unmarshal([]byte(raw), &offsets)
+		if err != nil {
+			return nil, fmt.Errorf("decoding warnings of timer %s: %w", keys[i], err)
+		}
+		for _, ms := range offsets {
+			warnings = append(warnings, warningKey(keys[i], time.Duration(ms)*time.Millisecond))
+		}
+	}
+	return warnings, nil
+}
@@ -0,0 +1,178 @@
+package rimer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// WebhookURLMetadataKey is the key of the CreateOptions.Metadata entry that
+// overrides WebhookOptions.URL for a single timer.
+const WebhookURLMetadataKey = "rimer-webhook-url"
+
+const (
+	// webhookTimeout is the timeout of the HTTP client that ServeWebhook
+	// uses when none is given.
+	webhookTimeout = 10 * time.Second
+
+	// webhookMaxAttempts is the default number of times that ServeWebhook
+	// tries to deliver a timer.
+	webhookMaxAttempts = 3
+
+	// webhookRetryBackoff is the default time that ServeWebhook waits before
+	// retrying a delivery.
+	webhookRetryBackoff = time.Second
+)
+
+// WebhookOptions configures ServeWebhook.
+type WebhookOptions struct {
+	// URL is the webhook that fired timers are posted to, unless the timer
+	// has its own, see WebhookURLMetadataKey.
+	URL string
+
+	// Client is the HTTP client that posts the timers. Defaults to a client
+	// with a 10 second timeout.
+	Client *http.Client
+
+	// MaxInFlight is the most timers that are posted at the same time, see
+	// ConsumeOptions. Defaults to 1.
+	MaxInFlight int
+
+	// MaxAttempts is the number of times that posting a timer is tried before
+	// it's moved to the dead letter queue. Defaults to 3.
+	MaxAttempts int
+
+	// RetryBackoff is how long to wait before retrying a timer for the first
+	// time, and doubles with each retry. Defaults to a second.
+	RetryBackoff time.Duration
+
+	// OnError is called with every error consuming a timer, see
+	// ConsumeOptions, and with every timer that couldn't be delivered.
+	OnError func(err error)
+}
+
+// WebhookPayload is the JSON body of the requests that ServeWebhook makes.
+type WebhookPayload struct {
+	Key        string            `json:"key"`
+	Token      int64             `json:"token,omitempty"`
+	DeliveryID string            `json:"delivery_id,omitempty"`
+	Label      string            `json:"label,omitempty"`
+	Value      []byte            `json:"value,omitempty"`
+	Fields     map[string][]byte `json:"fields,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Metadata   Metadata          `json:"metadata,omitempty"`
+	Replays    int               `json:"replays,omitempty"`
+}
+
+// ServeWebhook consumes the fired timers of this namespace and posts each of
+// them to a webhook as a WebhookPayload, so that services can be called on
+// schedule without writing a consumer. It runs until the context is cancelled,
+// like Consume, which it's built on, and posts at most MaxInFlight timers at a
+// time.
+//
+// A timer is delivered once the webhook responds with a 2xx status. Other
+// responses and failed requests are retried with an exponential backoff, and
+// timers that couldn't be delivered in MaxAttempts attempts are moved to the
+// dead letter queue, see DeadLetter. Timers whose delivery is interrupted by
+// the context being cancelled are requeued instead, so that the next consumer
+// delivers them, but like dead lettered timers, they lose their value and the
+// rest of their data. The requests carry an Idempotency-Key header made of the
+// timer's key and delivery ID, see FiredTimer.DeliveryID, which stays the same
+// across retries and when a requeued timer is delivered again, so the webhook
+// can ignore repeated deliveries of the same fire.
+//
+// ServeWebhook returns ErrQueueMismatch if the namespace uses QueueStream.
+func (n *Namespace) ServeWebhook(ctx context.Context, opts WebhookOptions) error {
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: webhookTimeout}
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = webhookMaxAttempts
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = webhookRetryBackoff
+	}
+	consume := ConsumeOptions{MaxInFlight: opts.MaxInFlight, OnError: opts.OnError}
+	return n.Consume(ctx, consume, func(ctx context.Context, t FiredTimer) {
+		err := n.deliverWebhook(ctx, opts, t)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			// The context is done, so use a fresh one to put the timer back.
+			rctx, cancel := n.client.withDefaultTimeout(context.Background())
+			defer cancel()
+			if rerr := n.RequeueTimer(rctx, t); rerr != nil {
+				err = fmt.Errorf("requeueing timer %s: %w", t.Key, rerr)
+			}
+		} else if derr := n.DeadLetter(ctx, t); derr != nil {
+			err = fmt.Errorf("dead lettering timer %s: %w", t.Key, derr)
+		}
+		if opts.OnError != nil {
+			opts.OnError(err)
+		}
+	})
+}
+
+// deliverWebhook posts the timer to its webhook, retrying until it succeeds,
+// the attempts run out or the context is done, and returns the last error.
+func (n *Namespace) deliverWebhook(ctx context.Context, opts WebhookOptions, t FiredTimer) error {
+	url := opts.URL
+	if u := t.Metadata.Get(WebhookURLMetadataKey); u != "" {
+		url = u
+	}
+	if url == "" {
+		return fmt.Errorf("no webhook URL for timer %s", t.Key)
+	}
+	body, err := json.Marshal(WebhookPayload{
+		Key:        t.Key,
+		Token:      t.Token,
+		DeliveryID: t.DeliveryID,
+		Label:      t.Label,
+		Value:      t.Value,
+		Fields:     t.Fields,
+		Tags:       t.Tags,
+		Metadata:   t.Metadata,
+		Replays:    t.Replays,
+	})
+	if err != nil {
+		return err
+	}
+	backoff := opts.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(ctx, opts.Client, url, t.Key+":"+t.DeliveryID, body)
+		if err == nil || attempt == opts.MaxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// postWebhook makes a single request to the webhook.
+func postWebhook(ctx context.Context, client *http.Client, url, idempotencyKey string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", idempotencyKey)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with %s", url, resp.Status)
+	}
+	return nil
+}